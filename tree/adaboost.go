@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// adaboostMinError keeps a tree that gets every row right from having an
+// infinite vote
+const adaboostMinError = 1e-10
+
+/*
+adaboost grows up to rounds trees of at most maxDepth levels from rows, each
+fit with the rows that the trees before it got wrong counting for more. This is
+SAMME, AdaBoost for any number of labels. Each tree's vote is weighted by how
+little of the rows' weight it got wrong. Boosting stops early when a tree is no
+better than chance, or gets every row right.
+*/
+func adaboost(rows []datarow, labels int, rounds int) (trees []*Tree, weights []float32) {
+	w := make([]float64, len(rows))
+	for i := range w {
+		w[i] = 1 / float64(len(rows))
+	}
+	missed := make([]bool, len(rows))
+	for round := 0; round < rounds; round++ {
+		t := getWeightedSplit(rows, w)
+		t.split(1)
+		var wrong, total float64
+		for i, row := range rows {
+			missed[i] = t.predict(row) != row[lastColumnIndex]
+			if missed[i] {
+				wrong += w[i]
+			}
+			total += w[i]
+		}
+		errorRate := wrong / total
+		if errorRate >= 1-1/float64(labels) {
+			log.Println("( adaboost ) Round", round+1, "is no better than chance, stopping")
+			break
+		}
+		perfect := errorRate < adaboostMinError
+		errorRate = math.Max(errorRate, adaboostMinError)
+		alpha := math.Log((1-errorRate)/errorRate) + math.Log(float64(labels-1))
+		trees = append(trees, t)
+		weights = append(weights, float32(alpha))
+		log.Println("( adaboost ) Round", round+1, "/", rounds, "error", errorRate, "weight", alpha)
+		if perfect {
+			break
+		}
+
+		var sum float64
+		for i := range w {
+			if missed[i] {
+				w[i] *= math.Exp(alpha)
+			}
+			sum += w[i]
+		}
+		for i := range w {
+			w[i] /= sum
+		}
+	}
+	return trees, weights
+}
+
+/*
+trainAdaboost boosts shallow trees on the loaded trainingCases, holding out the
+first of the -folds to score them, and saves the model to -save. With -final,
+the trees are boosted again on all of the data.
+*/
+func trainAdaboost() {
+	loadTrainingData()
+	labels := len(indexedVariables)
+	if labels < 2 {
+		fmt.Println("-algo=adaboost needs at least two labels")
+		return
+	}
+	// stumps, trying every feature, unless asked otherwise
+	if !flagPassed("depth") {
+		maxDepth = 1
+	}
+	if *overrideFeatureSplitSize == 0 {
+		n_features = lastColumnIndex
+	}
+	folds := splitIntoParts(trainingCases)
+	valid := folds[0]
+	var train []datarow
+	for _, fold := range folds[1:] {
+		train = append(train, fold...)
+	}
+	fmt.Println("features:", lastColumnIndex)
+	fmt.Println("feature split size (m):", n_features)
+	fmt.Println("rounds:", *boostRounds, "depth:", maxDepth)
+	fmt.Println("training cases:", len(train), "validation cases:", len(valid))
+
+	trees, weights := adaboost(train, labels, *boostRounds)
+	if len(trees) == 0 {
+		fmt.Println("The first tree was no better than chance, so there is no model to save")
+		return
+	}
+	forest := flatten(trees)
+	forest.Weights = weights
+	var predicted []float32
+	for _, row := range valid {
+		predicted = append(predicted, baggingPredict(forest, row))
+	}
+	accuracy := accuracyMetric(lastColumn(valid), predicted)
+	fmt.Println("\nComplete.")
+	fmt.Println("  Rounds:", len(trees))
+	fmt.Println("  Validation accuracy:", accuracy, "%")
+
+	meta := modelMeta{
+		Procedure:        procedureHoldout,
+		Task:             taskClassification,
+		Algorithm:        algoAdaboost,
+		Created:          time.Now(),
+		DataFile:         *dataFile,
+		Columns:          columnsPerRow,
+		ColumnNames:      columnNames,
+		Folds:            *n_folds,
+		FinalTrees:       len(trees),
+		FeatureSplitSize: n_features,
+		MaxDepth:         maxDepth,
+		Rounds:           len(trees),
+		FoldScores:       []float32{accuracy},
+		MeanAccuracy:     accuracy,
+	}
+	if *finalForest {
+		fmt.Println("\nBoosting", len(trees), "rounds on all", len(trainingCases), "training cases")
+		trees, weights = adaboost(trainingCases, labels, len(trees))
+		forest = flatten(trees)
+		forest.Weights = weights
+		meta.Procedure = procedureFinal
+		meta.FinalTrees = len(trees)
+		meta.Rounds = len(trees)
+	}
+
+	err := save(*saveTo, &saveFormat{
+		Trees:            trees,
+		Flat:             forest,
+		IndexedVariables: indexedVariables,
+		Variables:        variables,
+		Meta:             meta,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("\nSaved", len(trees), "trees and", len(indexedVariables), "variables to", *saveTo)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useStumps makes getSplit try every feature and grow stumps, until the
+// returned func puts the globals back
+func useStumps(features int) (restore func()) {
+	depth, m := maxDepth, n_features
+	setColumnGlobals(features + 1)
+	maxDepth, n_features = 1, features
+	return func() { maxDepth, n_features = depth, m }
+}
+
+func TestWeightedSplit(t *testing.T) {
+	defer useStumps(2)()
+	// x0 nearly separates the labels, x1 only isolates the row at x1 = 0
+	rows := []datarow{
+		{1, 5, 0}, {2, 5, 0}, {3, 5, 0}, {4, 5, 1}, {5, 5, 1}, {6, 5, 1},
+		{1, 0, 1}, {6, 9, 0},
+	}
+	even := []float64{1, 1, 1, 1, 1, 1, 1, 1}
+	tree := getWeightedSplit(rows, even)
+	if tree.VariableIndex != 0 || tree.ValueIndex != 4 {
+		t.Fatalf("expected x0 < 4, got x%v < %v", tree.VariableIndex, tree.ValueIndex)
+	}
+	heavy := []float64{1, 1, 1, 1, 1, 1, 10, 1}
+	tree = getWeightedSplit(rows, heavy)
+	if tree.VariableIndex != 1 || tree.ValueIndex != 5 {
+		t.Fatalf("expected x1 < 5, got x%v < %v", tree.VariableIndex, tree.ValueIndex)
+	}
+	if len(tree.leftWeights) != 1 || tree.leftWeights[0] != 10 || len(tree.rightWeights) != 7 {
+		t.Fatal("weights", tree.leftWeights, tree.rightWeights)
+	}
+
+	// one heavy row outweighs two others
+	if weightedTerminal(rows[5:8], []float64{1, 1, 5}) != 0 || toTerminal(rows[5:8]) != 1 {
+		t.Fatal("expected the heavy row's label")
+	}
+}
+
+func TestAdaboost(t *testing.T) {
+	rand.Seed(1)
+	defer useStumps(2)()
+	// a diagonal boundary, which no single stump can follow
+	r := rand.New(rand.NewSource(2))
+	var rows []datarow
+	for i := 0; i < 200; i++ {
+		a, b := r.Float32(), r.Float32()
+		var label float32
+		if a+b > 1 {
+			label = 1
+		}
+		rows = append(rows, datarow{a, b, label})
+	}
+	trees, weights := adaboost(rows, 2, 40)
+	if len(trees) != 40 || len(weights) != 40 {
+		t.Fatal("expected every round, got", len(trees), "trees and", len(weights), "weights")
+	}
+	for i, w := range weights {
+		if !(w > 0) {
+			t.Fatal("tree", i, "has weight", w)
+		}
+	}
+	accuracy := func(forest *flatForest) float32 {
+		var predicted []float32
+		for _, row := range rows {
+			predicted = append(predicted, baggingPredict(forest, row))
+		}
+		return accuracyMetric(lastColumn(rows), predicted)
+	}
+	forest := flatten(trees)
+	forest.Weights = weights
+	first := accuracy(flatten(trees[:1]))
+	if boosted := accuracy(forest); boosted < 90 || boosted <= first {
+		t.Fatal("boosted accuracy", boosted, "first stump", first)
+	}
+
+	// one stump gets every row right, so it is the only round
+	for _, row := range rows {
+		row[2] = 0
+		if row[0] > 0.5 {
+			row[2] = 1
+		}
+	}
+	trees, weights = adaboost(rows, 2, 40)
+	if len(trees) != 1 || weights[0] < 20 {
+		t.Fatal("expected one perfect tree, got", len(trees), weights)
+	}
+}
+
+func weightedTestModel() *saveFormat {
+	model := testPMMLModel()
+	model.Flat.Weights = []float32{3, 0.5, 1, 2, 0.25, 1, 1.5, 4, 0.75, 1}
+	return model
+}
+
+func TestWeightedVotes(t *testing.T) {
+	trees := []*Tree{
+		{VariableIndex: 0, ValueIndex: 5, LeftTerminal: 0, RightTerminal: 1},
+		{VariableIndex: 0, ValueIndex: 7, LeftTerminal: 0, RightTerminal: 1},
+		{VariableIndex: 0, ValueIndex: 3, LeftTerminal: 0, RightTerminal: 1},
+	}
+	forest := flatten(trees)
+	row := datarow{6, 0}
+	if baggingPredict(forest, row) != 1 {
+		t.Fatal("expected two of three trees to win")
+	}
+	forest.Weights = []float32{1, 3, 1}
+	if baggingPredict(forest, row) != 0 {
+		t.Fatal("expected the heavy tree to win")
+	}
+	if proba := baggingProba(forest, row, 2); proba[0] != 0.6 || proba[1] != 0.4 {
+		t.Fatal("proba", proba)
+	}
+
+	// TreeSHAP explains the weighted share of the votes
+	r := rand.New(rand.NewSource(3))
+	var counted []*Tree
+	for i := 0; i < 10; i++ {
+		counted = append(counted, randomCountedTree(r, 4, 3, 3, 50))
+	}
+	model := weightedTestModel()
+	model.Trees = counted
+	model.Flat = flatten(counted)
+	model.Flat.Weights = weightedTestModel().Flat.Weights
+	for i := 0; i < 50; i++ {
+		row := datarow{float32(r.Intn(7) - 3), float32(r.Intn(7) - 3), float32(r.Intn(7) - 3), 0}
+		proba := baggingProba(model.Flat, row, 3)
+		for class := range proba {
+			base, phi, err := forestSHAP(model, row, class, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := base
+			for _, c := range phi {
+				sum += c
+			}
+			if !(math.Abs(sum-float64(proba[class])) <= 1e-5) {
+				t.Fatal("contributions add up to", sum, "not", proba[class])
+			}
+		}
+	}
+}
+
+func TestWeightedExports(t *testing.T) {
+	model := weightedTestModel()
+	rows := randomRows(rand.New(rand.NewSource(6)), 200, 3)
+
+	encoded, err := toONNX(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, _ := decodeONNXNode(t, encoded)
+	e := newONNXEnsemble(onnxAttributes(t, node), "class", 3)
+	for _, row := range rows {
+		scores := e.scores(t, row)
+		proba := baggingProba(model.Flat, row, 3)
+		for i := range proba {
+			if math.Abs(float64(scores[i]-proba[i])) > 1e-5 {
+				t.Fatal("scores", scores, "expected", proba)
+			}
+		}
+	}
+
+	doc, err := toPMML(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := xml.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.MiningModel.Segmentation.MultipleModelMethod != "weightedMajorityVote" ||
+		!bytes.Contains(buf, []byte(`<Segment id="2" weight="0.5">`)) {
+		t.Fatal("expected weighted segments in\n" + string(buf))
+	}
+
+	header, err := generateCHeader(model, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(header, []byte("static const float m_weight[] = {0x1.8p+01f, 0x1p-01f,")) ||
+		!bytes.Contains(header, []byte("votes[m_predict_tree(m_roots[t], row)] += m_weight[t];")) {
+		t.Fatal("expected weighted votes in\n" + string(header))
+	}
+
+	for _, table := range []bool{false, true} {
+		src, err := generateGo(model, "m", table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(src), "var votes [3]float32") {
+			t.Fatal("expected weighted votes in\n" + string(src))
+		}
+	}
+
+	// json models keep their weights when the flat forest is rebuilt
+	path := filepath.Join(t.TempDir(), "weighted.json")
+	if err := saveJSON(path, model); err != nil {
+		t.Fatal(err)
+	}
+	var loaded saveFormat
+	if err := load(path, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Flat.Weights) != 10 || loaded.Flat.Weights[7] != 4 {
+		t.Fatal("weights", loaded.Flat.Weights)
+	}
+}
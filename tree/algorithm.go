@@ -1,14 +1,19 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
 func evaluateAlgorithm() (scores []float32, trees []*Tree) {
 	folds := splitIntoParts(trainingCases)
+	accuracyCurves = make(map[int][]float32)
 	var treeLock sync.Mutex
 	var scoreLock sync.Mutex
 	var wg sync.WaitGroup
@@ -29,7 +34,12 @@ func evaluateAlgorithm() (scores []float32, trees []*Tree) {
 			trees = append(trees, treeSet...)
 			treeLock.Unlock()
 			actual := lastColumn(testSet)
-			accuracy := accuracyMetric(actual, predicted)
+			var accuracy float32
+			if regressionTrees {
+				accuracy = rmseMetric(actual, predicted)
+			} else {
+				accuracy = accuracyMetric(actual, predicted)
+			}
 			scoreLock.Lock()
 			scores = append(scores, accuracy)
 			scoreLock.Unlock()
@@ -44,8 +54,7 @@ func evaluateAlgorithm() (scores []float32, trees []*Tree) {
 // predict takes a list of variable indexes (an input row) and predicts a single
 // variable index as the output.
 func (t *Tree) predict(row datarow) (prediction float32) {
-	inputVariableValue := row[int(t.VariableIndex)]
-	if inputVariableValue < t.ValueIndex {
+	if t.splitValue(row) < t.ValueIndex {
 		if t.LeftNode != nil {
 			return t.LeftNode.predict(row)
 		}
@@ -57,16 +66,68 @@ func (t *Tree) predict(row datarow) (prediction float32) {
 	return t.RightTerminal
 }
 
-// baggingPredict returns the most frequent variable index in the list of predictions
-func baggingPredict(trees []*Tree, row datarow) (mostFreqVariable float32) {
-	var predictions []float32
-	for _, tree := range trees {
-		predictions = append(predictions, tree.predict(row))
+// splitValue is what the node compares with its ValueIndex for row: the value
+// of its variable, or the combination of an oblique node's features
+func (t *Tree) splitValue(row datarow) float32 {
+	if len(t.ObliqueFeatures) > 0 {
+		return project(row, t.ObliqueFeatures, t.ObliqueWeights)
+	}
+	return row[int(t.VariableIndex)]
+}
+
+// baggingPredict returns the most frequent variable index in the list of
+// predictions, or the most probable one for an additive forest
+func baggingPredict(forest *flatForest, row datarow) (mostFreqVariable float32) {
+	if forest.Additive {
+		proba := forest.additiveProba(row)
+		for varIndex, p := range proba {
+			if p > proba[int(mostFreqVariable)] {
+				mostFreqVariable = float32(varIndex)
+			}
+		}
+		return mostFreqVariable
+	}
+	var highestFreq float32
+	for varIndex, count := range forest.votes(row) {
+		if count > highestFreq {
+			highestFreq = count
+			mostFreqVariable = float32(varIndex)
+		}
 	}
-	mostFreqVariable = maxCount(predictions)
 	return mostFreqVariable
 }
 
+// baggingProba returns the share of the trees' votes for each of nVariables
+// variable indexes, or an additive forest's probabilities. A regression
+// forest's one output is its prediction.
+func baggingProba(forest *flatForest, row datarow, nVariables int) (proba []float32) {
+	if forest.Additive {
+		return forest.additiveProba(row)
+	}
+	if forest.Averaged {
+		return []float32{regressionPredict(forest, row)}
+	}
+	proba = make([]float32, nVariables)
+	total := forest.totalWeight()
+	for varIndex, count := range forest.votes(row) {
+		proba[varIndex] = count / total
+	}
+	return proba
+}
+
+// regressionPredict is the summed leaf values of an additive forest, or the
+// mean of every tree's leaf value
+func regressionPredict(forest *flatForest, row datarow) float32 {
+	if forest.Additive {
+		return float32(forest.sums(row)[0])
+	}
+	var total float32
+	for _, root := range forest.Roots {
+		total += forest.predictTree(root, row)
+	}
+	return total / float32(len(forest.Roots))
+}
+
 func treeWorker(jobs <-chan []datarow, results chan<- *Tree) {
 	for trainSet := range jobs {
 		sample := getTrainingCaseSubset(trainSet)
@@ -82,37 +143,152 @@ func treeWorker(jobs <-chan []datarow, results chan<- *Tree) {
 // might be better than high accuracy per node, because the nodes should be dissimilar
 // but together they vote for the best answer.
 func randomForest(foldIndex int, trainSet []datarow, testSet []datarow) (predictions []float32, allTrees []*Tree) {
-	jobs := make(chan []datarow, parallelTrees)
-	results := make(chan *Tree, *treesPerFold)
+	if *autoTrees {
+		allTrees = autoForest(foldIndex, trainSet, testSet)
+	} else {
+		allTrees = trainForest(fmt.Sprint(foldIndex), trainSet, *treesPerFold, nil)
+	}
+
+	forest := flatten(allTrees)
+	for _, row := range testSet {
+		var pred float32
+		if forest.Averaged {
+			pred = regressionPredict(forest, row)
+		} else {
+			pred = baggingPredict(forest, row)
+		}
+		predictions = append(predictions, pred)
+	}
+	return predictions, allTrees
+}
+
+// trainForest builds up to nTrees trees from trainSet using a pool of
+// parallelTrees workers. label is only used for logging.
+//
+// When more is not nil, it is called with all the trees so far each time one
+// is done, and the forest stops growing when it returns false. Trees that were
+// already being built at that point are thrown away.
+func trainForest(label string, trainSet []datarow, nTrees int, more func(trees []*Tree) bool) (allTrees []*Tree) {
+	jobs := make(chan []datarow)
+	results := make(chan *Tree, parallelTrees)
+	stop := make(chan struct{})
 
 	// spawn worker pool
+	var wg sync.WaitGroup
+	wg.Add(parallelTrees)
 	for i := 0; i < parallelTrees; i++ {
-		go treeWorker(jobs, results)
-	}
-	// send all jobs into the pool
-	for i := 0; i < *treesPerFold; i++ {
-		jobs <- trainSet
+		go (func() {
+			treeWorker(jobs, results)
+			wg.Done()
+		})()
 	}
-	close(jobs) // disallow any more jobs to enter
+	// send all jobs into the pool, until told to stop
+	go (func() {
+		defer close(jobs) // disallow any more jobs to enter
+		for i := 0; i < nTrees; i++ {
+			select {
+			case jobs <- trainSet:
+			case <-stop:
+				return
+			}
+		}
+	})()
+	go (func() {
+		wg.Wait()
+		close(results)
+	})()
 
+	stopped := false
 	var lenAll int
 	for tree := range results {
+		if stopped {
+			continue // drain whatever was in progress
+		}
 		allTrees = append(allTrees, tree)
 		lenAll = len(allTrees)
-		log.Println("(", foldIndex, ") Tree done", lenAll, "/", *treesPerFold)
-		if lenAll >= *treesPerFold {
-			close(results)
-			break
+		log.Println("(", label, ") Tree done", lenAll, "/", nTrees)
+		if lenAll >= nTrees || (more != nil && !more(allTrees)) {
+			stopped = true
+			close(stop)
 		}
 	}
 
 	// worker pool done
+	return allTrees
+}
 
-	for _, row := range testSet {
-		pred := baggingPredict(allTrees, row)
-		predictions = append(predictions, pred)
+// accuracyCurves holds the holdout accuracy after each tree, per fold, from the
+// last run of evaluateAlgorithm with -autotrees.
+var accuracyCurves = make(map[int][]float32)
+var accuracyCurvesLock sync.Mutex
+
+/*
+autoForest keeps adding trees to a forest until it stops getting better.
+
+After each tree, the votes of the forest so far are tallied on the testSet.
+Once the accuracy has improved by less than -autotol over the last -autok
+trees, no more are added.
+*/
+func autoForest(foldIndex int, trainSet []datarow, testSet []datarow) (allTrees []*Tree) {
+	votes := make([][]int, len(testSet)) // per row, the trees voting for each variable index
+	actual := lastColumn(testSet)
+	var curve []float32
+
+	more := func(trees []*Tree) bool {
+		newest := trees[len(trees)-1]
+		predicted := make([]float32, len(testSet))
+		for i, row := range testSet {
+			v := int(newest.predict(row))
+			for len(votes[i]) <= v {
+				votes[i] = append(votes[i], 0)
+			}
+			votes[i][v]++
+			predicted[i] = mostVotes(votes[i])
+		}
+		curve = append(curve, accuracyMetric(actual, predicted))
+		return !leveledOff(curve, *autoTreesWindow, *autoTreesTolerance)
 	}
-	return predictions, allTrees
+	allTrees = trainForest(fmt.Sprint(foldIndex), trainSet, *autoTreesMax, more)
+	log.Println("(", foldIndex, ") Stopped at", len(allTrees), "trees with accuracy", curve[len(curve)-1])
+
+	accuracyCurvesLock.Lock()
+	accuracyCurves[foldIndex] = curve
+	accuracyCurvesLock.Unlock()
+	return allTrees
+}
+
+// mostVotes is the variable index with the most votes, ties going to the
+// lowest
+func mostVotes(votes []int) (varIndex float32) {
+	for i, count := range votes {
+		if count > votes[int(varIndex)] {
+			varIndex = float32(i)
+		}
+	}
+	return varIndex
+}
+
+// leveledOff is whether the accuracy curve improved by less than tolerance
+// over its last window trees
+func leveledOff(curve []float32, window int, tolerance float64) bool {
+	n := len(curve)
+	if n <= window {
+		return false
+	}
+	return curve[n-1]-curve[n-1-window] < float32(tolerance)
+}
+
+// writeAccuracyCurves saves accuracyCurves as csv with the columns
+// fold,trees,accuracy
+func writeAccuracyCurves(path string) error {
+	var b strings.Builder
+	b.WriteString("fold,trees,accuracy\n")
+	for fold := 0; fold < len(accuracyCurves); fold++ {
+		for i, acc := range accuracyCurves[fold] {
+			fmt.Fprintf(&b, "%d,%d,%g\n", fold, i+1, acc)
+		}
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), os.ModePerm)
 }
 
 var f_100 float32 = 100
@@ -142,11 +318,19 @@ var _sec = int64(time.Second)
 // getSplit selects the best split point for a dataset, for a few features only,
 // so this tree cares about only some features, not all of them.
 func getSplit(dataSubset []datarow) (t *Tree) {
+	return getWeightedSplit(dataSubset, nil)
+}
+
+// getWeightedSplit is getSplit where each row counts as much as its weight, for
+// boosting, using splitCache.weightedGini. With nil weights it is getSplit.
+// Regression trees split where the values on each side are closest to their
+// side's mean, and multi-output trees use splitCache.outputImpurity. With
+// -oblique, a combination of the features can split the rows instead, when it
+// does so better than any one of them.
+func getWeightedSplit(dataSubset []datarow, weights []float64) (t *Tree) {
 	var bestVariableIndex float32
 	var bestValueIndex float32
-	var bestLeft []datarow
-	var bestRight []datarow
-	var bestGini float32 = 9999
+	var bestGini float64 = 9999
 
 	// prevent many malloc and gc events by reusing these
 	sc := splitCache{}
@@ -155,7 +339,7 @@ func getSplit(dataSubset []datarow) (t *Tree) {
 	var features []int32 // index of
 	for len(features) < n_features {
 		// the following line is quite slow
-		index := rand.Int31n(int32(columnsPerRow - 1)) // total cases per input
+		index := rand.Int31n(int32(lastColumnIndex)) // total cases per input
 		if !includes(features, index) {
 			features = append(features, index)
 		}
@@ -176,13 +360,17 @@ func getSplit(dataSubset []datarow) (t *Tree) {
 			// create a test split
 			sc.splitOnIndex(varIndex, row[int(varIndex)], dataSubset)
 			// last column is the features
-			gini := calcGiniOnSplit(sc.leftLastCols, sc.rightLastCols, lastColumn(dataSubset))
+			var gini float64
+			if weights == nil || outputs > 1 || regressionTrees {
+				gini = sc.splitImpurity(dataSubset)
+			} else {
+				sc.splitWeights(varIndex, row[int(varIndex)], dataSubset, weights)
+				gini = sc.weightedGini()
+			}
 			if gini <= bestGini { // lowest gini is lowest error in predicting
 				bestVariableIndex = float32(varIndex)
 				bestValueIndex = row[int(varIndex)]
 				bestGini = gini
-				bestLeft = sc.left
-				bestRight = sc.right
 			}
 			index++
 			if index%_logEvery == 0 {
@@ -195,12 +383,29 @@ func getSplit(dataSubset []datarow) (t *Tree) {
 			}
 		}
 	}
+	if obliqueMode != "" && weights == nil {
+		if oblique, gini := obliqueSplit(&sc, dataSubset, features); oblique != nil && gini < bestGini {
+			return oblique
+		}
+	}
+	// sc is reused for every candidate, so the best split is made again
+	// into rows of its own
+	sc.splitOnIndex(int32(bestVariableIndex), bestValueIndex, dataSubset)
+	bestLeft := append([]datarow(nil), sc.left...)
+	bestRight := append([]datarow(nil), sc.right...)
 	t = &Tree{
 		VariableIndex: bestVariableIndex,
 		ValueIndex:    bestValueIndex,
+		LeftCount:     len(bestLeft),
+		RightCount:    len(bestRight),
 		leftSamples:   bestLeft,
 		rightSamples:  bestRight,
 	}
+	if weights != nil {
+		sc.splitWeights(int32(bestVariableIndex), bestValueIndex, dataSubset, weights)
+		t.leftWeights = append([]float64(nil), sc.leftWeights...)
+		t.rightWeights = append([]float64(nil), sc.rightWeights...)
+	}
 	return t
 }
 
@@ -281,36 +486,100 @@ func (t *Tree) split(depth int) {
 	// each side. the split index will determine which way to go when an
 	// input row comes in
 	if depth >= maxDepth {
-		t.LeftTerminal = toTerminal(t.leftSamples)
-		t.RightTerminal = toTerminal(t.rightSamples)
+		t.leftTerminal()
+		t.rightTerminal()
 		return
 	}
 
 	// process left
-	if len(t.leftSamples) <= 1 { // only one row left (?)
-		t.LeftTerminal = toTerminal(t.leftSamples)
+	if len(t.leftSamples) <= leafRows { // few enough rows left
+		t.leftTerminal()
 	} else {
-		t.LeftNode = getSplit(t.leftSamples)
+		t.LeftNode = getWeightedSplit(t.leftSamples, t.leftWeights)
 		t.LeftNode.split(depth + 1)
 	}
 
 	// process right
-	if len(t.rightSamples) <= 1 { // only one row left (?)
-		t.RightTerminal = toTerminal(t.rightSamples)
+	if len(t.rightSamples) <= leafRows { // few enough rows left
+		t.rightTerminal()
 	} else {
-		t.RightNode = getSplit(t.rightSamples)
+		t.RightNode = getWeightedSplit(t.rightSamples, t.rightWeights)
 		t.RightNode.split(depth + 1)
 	}
 }
 
+// leftTerminal makes the left of t a terminal of its training rows. In a
+// regression tree it is their mean, and keeps their values for quantiles. When
+// no rows went left it predicts the same as the right. A multi-output terminal
+// predicts each of the -targets.
+func (t *Tree) leftTerminal() {
+	if outputs > 1 {
+		samples := t.leftSamples
+		if len(samples) == 0 {
+			samples = t.rightSamples
+		}
+		t.LeftOutputs = outputTerminals(samples)
+		t.LeftTerminal = t.LeftOutputs[0]
+		return
+	}
+	if !regressionTrees {
+		t.LeftTerminal = weightedTerminal(t.leftSamples, t.leftWeights)
+		t.LeftClasses = classCounts(t.leftSamples)
+		return
+	}
+	samples := t.leftSamples
+	if len(samples) == 0 {
+		samples = t.rightSamples
+	}
+	t.LeftTerminal = meanTerminal(samples)
+	t.LeftValues = leafValues(t.leftSamples)
+}
+
+// rightTerminal is leftTerminal for the right of t
+func (t *Tree) rightTerminal() {
+	if outputs > 1 {
+		samples := t.rightSamples
+		if len(samples) == 0 {
+			samples = t.leftSamples
+		}
+		t.RightOutputs = outputTerminals(samples)
+		t.RightTerminal = t.RightOutputs[0]
+		return
+	}
+	if !regressionTrees {
+		t.RightTerminal = weightedTerminal(t.rightSamples, t.rightWeights)
+		t.RightClasses = classCounts(t.rightSamples)
+		return
+	}
+	samples := t.rightSamples
+	if len(samples) == 0 {
+		samples = t.leftSamples
+	}
+	t.RightTerminal = meanTerminal(samples)
+	t.RightValues = leafValues(t.rightSamples)
+}
+
+// classCounts counts the rows predicting each variable index, up to the
+// highest one seen
+func classCounts(dataSubset []datarow) (counts []int) {
+	for _, row := range dataSubset {
+		v := int(row[lastColumnIndex])
+		for len(counts) <= v {
+			counts = append(counts, 0)
+		}
+		counts[v]++
+	}
+	return counts
+}
+
 // whatever is most represented
 func toTerminal(dataSubset []datarow) (highestFreqVariableIndex float32) {
 	outcomes := make(map[float32]int)
 	for _, row := range dataSubset {
-		if _, exists := outcomes[row[4]]; !exists {
-			outcomes[row[4]] = 1
+		if _, exists := outcomes[row[lastColumnIndex]]; !exists {
+			outcomes[row[lastColumnIndex]] = 1
 		} else {
-			outcomes[row[4]]++
+			outcomes[row[lastColumnIndex]]++
 		}
 	}
 	var highestFreq int
@@ -322,3 +591,18 @@ func toTerminal(dataSubset []datarow) (highestFreqVariableIndex float32) {
 	}
 	return highestFreqVariableIndex
 }
+
+// weightedTerminal is the variable index with the most weight, or whatever is
+// most represented when there are no weights. Ties go to the lowest index.
+func weightedTerminal(dataSubset []datarow, weights []float64) (highestWeightVariableIndex float32) {
+	if weights == nil {
+		return toTerminal(dataSubset)
+	}
+	totals, _ := addClassWeights(nil, lastColumn(dataSubset), weights)
+	for varIndex, total := range totals {
+		if total > totals[int(highestWeightVariableIndex)] {
+			highestWeightVariableIndex = float32(varIndex)
+		}
+	}
+	return highestWeightVariableIndex
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetSplitKeepsItsRows(t *testing.T) {
+	rand.Seed(1)
+	setColumnGlobals(5)
+	n_features = 4
+	// x0 separates the labels and the rest is noise, so later candidates would
+	// overwrite the best split's rows if they shared the split cache
+	r := rand.New(rand.NewSource(2))
+	var rows []datarow
+	for i := 0; i < 60; i++ {
+		x0 := r.Float32()
+		row := datarow{x0, r.Float32(), r.Float32(), r.Float32(), 0}
+		if x0 > 0.4 {
+			row[4] = 1
+		}
+		rows = append(rows, row)
+	}
+	for i := 0; i < 10; i++ {
+		tree := getSplit(rows)
+		f := int(tree.VariableIndex)
+		if len(tree.leftSamples)+len(tree.rightSamples) != len(rows) {
+			t.Fatal("split", len(tree.leftSamples), "+", len(tree.rightSamples), "rows of", len(rows))
+		}
+		seen := make(map[*float32]int)
+		for _, row := range tree.leftSamples {
+			if row[f] >= tree.ValueIndex {
+				t.Fatal("left row", row, "is not below", tree.ValueIndex, "on x", f)
+			}
+			seen[&row[0]]++
+		}
+		for _, row := range tree.rightSamples {
+			if row[f] < tree.ValueIndex {
+				t.Fatal("right row", row, "is below", tree.ValueIndex, "on x", f)
+			}
+			seen[&row[0]]++
+		}
+		for _, row := range rows {
+			if seen[&row[0]] != 1 {
+				t.Fatal("row", row, "is in the split", seen[&row[0]], "times")
+			}
+		}
+	}
+}
+
+func TestLeveledOff(t *testing.T) {
+	for _, c := range []struct {
+		curve     []float32
+		window    int
+		tolerance float64
+		leveled   bool
+	}{
+		{[]float32{50}, 1, 0.5, false},
+		{[]float32{50, 60}, 2, 0.5, false},
+		{[]float32{50, 60}, 1, 0.5, false},
+		{[]float32{50, 60, 60.2}, 1, 0.5, true},
+		{[]float32{50, 60, 60.2}, 2, 0.5, false},
+		{[]float32{50, 60, 60.5}, 1, 0.5, false},
+		{[]float32{60, 70, 65}, 1, 0, true},
+	} {
+		if got := leveledOff(c.curve, c.window, c.tolerance); got != c.leveled {
+			t.Errorf("%v window %d tolerance %g: expected %v", c.curve, c.window, c.tolerance, c.leveled)
+		}
+	}
+}
+
+// useAutoTrees sets the -autotrees flags, and restores them afterwards
+func useAutoTrees(most, window int, tolerance float64) (restore func()) {
+	most0, window0, tolerance0, pct0 := autoTreesMax, autoTreesWindow, autoTreesTolerance, subsetSizePercent
+	pct := 1.0
+	autoTreesMax, autoTreesWindow, autoTreesTolerance, subsetSizePercent = &most, &window, &tolerance, &pct
+	return func() {
+		autoTreesMax, autoTreesWindow, autoTreesTolerance, subsetSizePercent = most0, window0, tolerance0, pct0
+	}
+}
+
+func TestAutoForest(t *testing.T) {
+	depth, m := maxDepth, n_features
+	defer func() { maxDepth, n_features = depth, m }()
+	setColumnGlobals(5)
+	maxDepth, n_features = 1, 4
+	// labeled by which side of a diagonal they are on, so stumps keep getting
+	// a little better
+	r := rand.New(rand.NewSource(8))
+	var rows []datarow
+	for i := 0; i < 80; i++ {
+		row := datarow{r.Float32(), r.Float32(), r.Float32(), r.Float32(), 0}
+		if row[0]+row[1] > 1 {
+			row[4] = 1
+		}
+		rows = append(rows, row)
+	}
+	trainSet, testSet := rows[:60], rows[60:]
+
+	// a tolerance nothing can reach stops after window+1 trees
+	restore := useAutoTrees(50, 3, 101)
+	trees := autoForest(0, trainSet, testSet)
+	restore()
+	if len(trees) != 4 || len(accuracyCurves[0]) != 4 {
+		t.Fatal("expected 4 trees, got", len(trees), "and curve", accuracyCurves[0])
+	}
+
+	// and a negative one never stops before -maxtrees
+	defer useAutoTrees(12, 3, -101)()
+	if trees := autoForest(0, trainSet, testSet); len(trees) != 12 {
+		t.Fatal("expected -maxtrees trees, got", len(trees))
+	}
+}
+
+func TestMostVotes(t *testing.T) {
+	for _, c := range []struct {
+		votes    []int
+		expected float32
+	}{
+		{[]int{1}, 0},
+		{[]int{0, 2, 1}, 1},
+		{[]int{0, 2, 2}, 1},
+		{[]int{3, 1, 3}, 0},
+		{[]int{0, 0, 0, 1}, 3},
+	} {
+		if got := mostVotes(c.votes); got != c.expected {
+			t.Fatal(c.votes, "got", got, "expected", c.expected)
+		}
+	}
+}
+
+func TestWriteAccuracyCurves(t *testing.T) {
+	before := accuracyCurves
+	defer func() { accuracyCurves = before }()
+	accuracyCurves = map[int][]float32{1: {70, 72.5}, 0: {50}}
+	path := filepath.Join(t.TempDir(), "curve.csv")
+	if err := writeAccuracyCurves(path); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "fold,trees,accuracy\n0,1,50\n1,1,70\n1,2,72.5\n"
+	if string(buf) != expected {
+		t.Fatalf("got\n%s\nexpected\n%s", buf, expected)
+	}
+}
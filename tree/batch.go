@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// batchSize is how many -input rows a worker predicts at a time
+const batchSize = 256
+
+type batchJob struct {
+	index     int
+	firstLine int // line number of the first record, for errors
+	records   [][]string
+}
+
+type batchResult struct {
+	index   int
+	rows    [][]string
+	scored  int // rows which included the predicted column
+	correct int
+	sqErr   float64 // sum of the squared errors of a regression model
+	covered int     // rows whose value was within the lowest and highest -quantiles
+	// the same for each of the -targets of a multi-output forest
+	targetCorrect []int
+	targetSqErr   []float64
+	// the scores of an isolation forest, and whether each row with the
+	// predicted column had the model's anomaly label
+	scores    []float64
+	anomalous []bool
+	err       error
+}
+
+// batchTotals adds up the batchResults of every row, in order
+type batchTotals struct {
+	rows, scored, correct, covered int
+	sqErr                          float64
+	targetCorrect                  []int
+	targetSqErr                    []float64
+	scores                         []float64
+	anomalous                      []bool
+}
+
+/*
+predictBatch predicts every row of the -input csv and writes a csv to -output
+with any -idcols, the predicted label, and the share of tree votes for every
+label. A regression model writes the predicted number, and an isolation
+forest an anomaly score. With -quantiles, a regression forest also writes each
+quantile. A multi-output forest writes a column for each of its -targets.
+
+Rows may include the predicted column at the end, the same as training data.
+When they do, the accuracy is printed at the end, or for an isolation forest
+trained with -anomaly the AUC, or for regression the root mean squared error
+and how many values were between the lowest and highest -quantiles. Rows for a
+multi-output forest may have its -targets in the same columns as the training
+data, and each is scored.
+*/
+func predictBatch() {
+	if *charMode {
+		fmt.Println("-input is not supported with -charmode")
+		return
+	}
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+	if quantileLevels != nil && len(loaded.Flat.ValueStart) == 0 {
+		fmt.Println(errNoLeafValues)
+		return
+	}
+
+	ids, err := parseIndexList(*idColumns)
+	if err != nil {
+		fmt.Println("-idcols:", err)
+		return
+	}
+
+	in, err := os.Open(*batchInput)
+	if err != nil {
+		panic(err)
+	}
+	defer in.Close()
+
+	// predictions go to stdout unless there is an -output, so status
+	// messages have to stay out of the way
+	var out io.Writer = os.Stdout
+	status := os.Stderr
+	if *batchOutput != "" {
+		f, err := os.Create(*batchOutput)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		out = f
+		status = os.Stdout
+	}
+	fmt.Fprintln(status, len(loaded.Trees), "Trees loaded")
+
+	totals, err := writeBatch(&loaded, ids, *hasHeader, in, out, runtime.NumCPU())
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Fprintln(status, "Predicted", totals.rows, "rows")
+	scored := totals.scored
+	if scored > 0 && len(loaded.Targets) > 0 {
+		for j, t := range loaded.Targets {
+			if loaded.Meta.Task == taskRegression {
+				fmt.Fprintln(status, t.Name, "RMSE:", math.Sqrt(totals.targetSqErr[j]/float64(scored)), "of", scored, "rows with the targets")
+			} else {
+				fmt.Fprintln(status, t.Name, "accuracy:", f_100*float32(totals.targetCorrect[j])/float32(scored), "% of", scored, "rows with the targets")
+			}
+		}
+	} else if len(totals.scores) > 0 {
+		fmt.Fprintln(status, "AUC:", rocAUC(totals.scores, totals.anomalous), "for", loaded.Meta.Anomaly, "over", len(totals.scores), "labeled rows")
+	} else if scored > 0 && loaded.Meta.Task == taskRegression {
+		fmt.Fprintln(status, "RMSE:", math.Sqrt(totals.sqErr/float64(scored)), "of", scored, "rows with the predicted column")
+		if len(quantileLevels) > 1 {
+			fmt.Fprintln(status, "Interval coverage:", f_100*float32(totals.covered)/float32(scored), "% between the lowest and highest -quantiles")
+		}
+	} else if scored > 0 {
+		fmt.Fprintln(status, "Accuracy:", f_100*float32(totals.correct)/float32(scored), "% of", scored, "rows with the predicted column")
+	}
+}
+
+/*
+writeBatch is predictBatch from in to out, with the model's rows shared among
+workers batchSize at a time. The rows are written in the order they were
+read. It stops at the first line that cannot be predicted, and returns its
+error after everything before it has been written.
+*/
+func writeBatch(model *saveFormat, ids []int, header bool, in io.Reader, out io.Writer, workers int) (totals batchTotals, err error) {
+	variables = model.Variables
+	indexedVariables = model.IndexedVariables
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	writer := csv.NewWriter(out)
+
+	line := 1
+	var names []string
+	if header {
+		names, err = reader.Read()
+		if err != nil {
+			return totals, err
+		}
+		line++
+	}
+	var outHeader []string
+	for _, ix := range ids {
+		name := "col" + strconv.Itoa(ix)
+		if ix < len(names) {
+			name = names[ix]
+		}
+		outHeader = append(outHeader, name)
+	}
+	if len(model.Targets) > 0 {
+		for _, t := range model.Targets {
+			outHeader = append(outHeader, t.Name)
+		}
+	} else if model.Meta.Task == taskAnomaly {
+		outHeader = append(outHeader, "score")
+	} else if model.Meta.Task == taskRegression {
+		outHeader = append(outHeader, "prediction")
+		outHeader = append(outHeader, quantileNames(quantileLevels)...)
+	} else {
+		outHeader = append(outHeader, "prediction")
+		for _, label := range indexedVariables {
+			outHeader = append(outHeader, "p_"+label)
+		}
+	}
+	writer.Write(outHeader)
+
+	first, err := reader.Read()
+	if err == io.EOF {
+		writer.Flush()
+		return totals, writer.Error()
+	}
+	if err != nil {
+		return totals, err
+	}
+	if model.Meta.Columns > 0 {
+		setColumnGlobals(model.Meta.Columns)
+		if len(model.Targets) > 0 {
+			setTargetColumns(len(model.Targets))
+		}
+	} else {
+		// models from before the column count was saved; assume the rows are
+		// only features
+		setColumnGlobals(len(first) - len(ids) + 1)
+	}
+
+	jobs := make(chan batchJob)
+	results := make(chan batchResult)
+	var readErr error
+	go (func() {
+		defer close(jobs)
+		job := batchJob{firstLine: line, records: [][]string{first}}
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				readErr = err
+				break
+			}
+			line++
+			if len(job.records) == batchSize {
+				jobs <- job
+				job = batchJob{index: job.index + 1, firstLine: line}
+			}
+			job.records = append(job.records, record)
+		}
+		jobs <- job
+	})()
+
+	done := make(chan bool)
+	for i := 0; i < workers; i++ {
+		go (func() {
+			for job := range jobs {
+				results <- predictRecords(model, ids, job)
+			}
+			done <- true
+		})()
+	}
+	go (func() {
+		for i := 0; i < workers; i++ {
+			<-done
+		}
+		close(results)
+	})()
+
+	// results arrive out of order, so hold them until it is their turn. After
+	// an error the rest are only drained.
+	pending := make(map[int]batchResult)
+	next := 0
+	totals.targetCorrect = make([]int, len(model.Targets))
+	totals.targetSqErr = make([]float64, len(model.Targets))
+	for res := range results {
+		pending[res.index] = res
+		for err == nil {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			writer.WriteAll(r.rows)
+			if r.err != nil {
+				err = r.err
+			}
+			totals.rows += len(r.rows)
+			totals.scored += r.scored
+			totals.correct += r.correct
+			totals.covered += r.covered
+			totals.sqErr += r.sqErr
+			for j := range r.targetCorrect {
+				totals.targetCorrect[j] += r.targetCorrect[j]
+				totals.targetSqErr[j] += r.targetSqErr[j]
+			}
+			totals.scores = append(totals.scores, r.scores...)
+			totals.anomalous = append(totals.anomalous, r.anomalous...)
+		}
+	}
+	writer.Flush()
+	if err != nil {
+		return totals, err
+	}
+	if err = writer.Error(); err != nil {
+		return totals, err
+	}
+	return totals, readErr
+}
+
+// predictRecords predicts each of the job's csv records
+func predictRecords(model *saveFormat, ids []int, job batchJob) (res batchResult) {
+	forest := model.Flat
+	res.index = job.index
+	isID := make(map[int]bool)
+	for _, ix := range ids {
+		isID[ix] = true
+	}
+	for r, record := range job.records {
+		var out []string
+		var cols []string
+		for i, col := range record {
+			if isID[i] {
+				out = append(out, col)
+			} else {
+				cols = append(cols, col)
+			}
+		}
+		if len(out) != len(ids) {
+			res.err = fmt.Errorf("line %d is missing some of the -idcols", job.firstLine+r)
+			return res
+		}
+
+		var actual string
+		var actuals []string
+		hasActual := len(cols) == columnsPerRow
+		if hasActual && len(model.Targets) > 0 {
+			cols, actuals = splitTargets(model.Targets, cols)
+		} else if hasActual {
+			actual = cols[lastColumnIndex]
+			cols = cols[:lastColumnIndex]
+		} else if len(cols) != lastColumnIndex {
+			res.err = fmt.Errorf("line %d has %d feature columns, expected %d", job.firstLine+r, len(cols), lastColumnIndex)
+			return res
+		}
+		row, err := parseFeatures(cols)
+		if err != nil {
+			res.err = fmt.Errorf("line %d: %v", job.firstLine+r, err)
+			return res
+		}
+
+		if len(model.Targets) > 0 {
+			predicted := outputPredict(forest, row, model.Meta.Task == taskRegression)
+			for j, v := range predicted {
+				out = append(out, model.Targets[j].label(v))
+			}
+			res.rows = append(res.rows, out)
+			if !hasActual {
+				continue
+			}
+			if res.targetCorrect == nil {
+				res.targetCorrect = make([]int, len(predicted))
+				res.targetSqErr = make([]float64, len(predicted))
+			}
+			res.scored++
+			for j, p := range predicted {
+				a := strings.TrimSpace(actuals[j])
+				if model.Meta.Task != taskRegression {
+					if a == model.Targets[j].label(p) {
+						res.targetCorrect[j]++
+					}
+					continue
+				}
+				v, err := strconv.ParseFloat(a, 64)
+				if err != nil {
+					res.err = fmt.Errorf("line %d: %v", job.firstLine+r, err)
+					return res
+				}
+				res.targetSqErr[j] += (float64(p) - v) * (float64(p) - v)
+			}
+			continue
+		}
+		if model.Meta.Task == taskAnomaly {
+			score := anomalyScore(forest, model.Meta.IsolationSamples, row)
+			res.rows = append(res.rows, append(out, strconv.FormatFloat(score, 'g', 4, 64)))
+			if hasActual && model.Meta.Anomaly != "" {
+				res.scores = append(res.scores, score)
+				res.anomalous = append(res.anomalous, strings.TrimSpace(actual) == model.Meta.Anomaly)
+			}
+			continue
+		}
+		if model.Meta.Task == taskRegression {
+			value := regressionPredict(forest, row)
+			out = append(out, strconv.FormatFloat(float64(value), 'g', -1, 32))
+			var estimates []float32
+			if quantileLevels != nil {
+				estimates = forest.quantiles(row, quantileLevels)
+				out = append(out, formatQuantiles(estimates)...)
+			}
+			res.rows = append(res.rows, out)
+			if hasActual {
+				v, err := strconv.ParseFloat(strings.TrimSpace(actual), 64)
+				if err != nil {
+					res.err = fmt.Errorf("line %d: %v", job.firstLine+r, err)
+					return res
+				}
+				res.scored++
+				res.sqErr += (float64(value) - v) * (float64(value) - v)
+				if len(estimates) > 1 {
+					low, high := interval(quantileLevels, estimates)
+					if v >= float64(low) && v <= float64(high) {
+						res.covered++
+					}
+				}
+			}
+			continue
+		}
+
+		proba := baggingProba(forest, row, len(indexedVariables))
+		best := 0
+		for i, p := range proba {
+			if p > proba[best] {
+				best = i
+			}
+		}
+		out = append(out, indexedVariables[best])
+		for _, p := range proba {
+			out = append(out, strconv.FormatFloat(float64(p), 'g', 4, 32))
+		}
+		res.rows = append(res.rows, out)
+
+		if hasActual {
+			res.scored++
+			if strings.TrimSpace(actual) == indexedVariables[best] {
+				res.correct++
+			}
+		}
+	}
+	return res
+}
+
+// parseFeatures turns the feature columns of a row into a datarow. Unlike
+// parseRow, there is no predicted column, and bad values are returned as errors.
+func parseFeatures(cols []string) (dr datarow, err error) {
+	dr = make(datarow, columnsPerRow)
+	for i := 0; i < lastColumnIndex && i < len(cols); i++ {
+		nc, err := strconv.ParseFloat(strings.TrimSpace(cols[i]), 32)
+		if err != nil {
+			return nil, err
+		}
+		dr[i] = float32(nc)
+	}
+	return dr, nil
+}
+
+// parseIndexList parses a comma separated list of column indexes
+func parseIndexList(s string) (indexes []int, err error) {
+	if s == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		ix, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if ix < 0 {
+			return nil, fmt.Errorf("column index %d is negative", ix)
+		}
+		indexes = append(indexes, ix)
+	}
+	return indexes, nil
+}
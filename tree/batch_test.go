@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testBatchModel is ten random trees on three features, voting for a, b or c
+func testBatchModel() *saveFormat {
+	r := rand.New(rand.NewSource(3))
+	var trees []*Tree
+	for i := 0; i < 10; i++ {
+		trees = append(trees, randomTree(r, 1+r.Intn(5), 3, 3))
+	}
+	return &saveFormat{
+		Trees:            trees,
+		Flat:             flatten(trees),
+		IndexedVariables: []string{"a", "b", "c"},
+		Variables:        map[string]float32{"a": 0, "b": 1, "c": 2},
+		Meta:             modelMeta{Columns: 4},
+	}
+}
+
+// batchLabel is the label writeBatch predicts: the most votes, and the first
+// of them when there is a tie
+func batchLabel(model *saveFormat, row datarow) string {
+	proba := baggingProba(model.Flat, row, len(model.IndexedVariables))
+	best := 0
+	for i, p := range proba {
+		if p > proba[best] {
+			best = i
+		}
+	}
+	return model.IndexedVariables[best]
+}
+
+// batchRows is n csv lines of an id, three features, and the model's label for
+// them when labeled
+func batchRows(model *saveFormat, n int, labeled bool) (lines []string, rows []datarow) {
+	r := rand.New(rand.NewSource(9))
+	for i := 0; i < n; i++ {
+		row := datarow{r.Float32(), r.Float32(), r.Float32(), 0}
+		line := fmt.Sprintf("id%d,%v,%v,%v", i, row[0], row[1], row[2])
+		if labeled {
+			line += "," + batchLabel(model, row)
+		}
+		lines = append(lines, line)
+		rows = append(rows, row)
+	}
+	return lines, rows
+}
+
+func readBatchOutput(t *testing.T, out *bytes.Buffer) [][]string {
+	records, err := csv.NewReader(out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestWriteBatchKeepsOrder(t *testing.T) {
+	model := testBatchModel()
+	// several batches, so workers finish them out of order
+	lines, rows := batchRows(model, 3*batchSize+17, false)
+	var out bytes.Buffer
+	input := "name,f0,f1,f2\n" + strings.Join(lines, "\n") + "\n"
+	totals, err := writeBatch(model, []int{0}, true, strings.NewReader(input), &out, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals.rows != len(rows) || totals.scored != 0 {
+		t.Fatalf("totals %+v", totals)
+	}
+	records := readBatchOutput(t, &out)
+	if strings.Join(records[0], ",") != "name,prediction,p_a,p_b,p_c" {
+		t.Fatal("header", records[0])
+	}
+	records = records[1:]
+	if len(records) != len(rows) {
+		t.Fatal("wrote", len(records), "rows of", len(rows))
+	}
+	for i, record := range records {
+		if record[0] != "id"+strconv.Itoa(i) {
+			t.Fatal("row", i, "came back as", record[0])
+		}
+		expected := batchLabel(model, rows[i])
+		if record[1] != expected {
+			t.Fatal("row", i, "predicted", record[1], "expected", expected)
+		}
+		proba := baggingProba(model.Flat, rows[i], len(model.IndexedVariables))
+		var total float64
+		for j, p := range proba {
+			got, err := strconv.ParseFloat(record[2+j], 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := got - float64(p); diff > 1e-3 || diff < -1e-3 {
+				t.Fatal("row", i, "p_"+model.IndexedVariables[j], got, "expected", p)
+			}
+			total += got
+		}
+		if total < 0.99 || total > 1.01 {
+			t.Fatal("row", i, "probabilities add up to", total)
+		}
+	}
+}
+
+func TestWriteBatchAccuracy(t *testing.T) {
+	model := testBatchModel()
+	lines, _ := batchRows(model, 40, true)
+	// the last ten are labeled wrong, and the first five have spaces around
+	// their label, which still counts
+	for i := 30; i < 40; i++ {
+		lines[i] = lines[i][:strings.LastIndex(lines[i], ",")] + ",nope"
+	}
+	for i := 0; i < 5; i++ {
+		cut := strings.LastIndex(lines[i], ",")
+		lines[i] = lines[i][:cut] + ", " + lines[i][cut+1:] + " "
+	}
+	var out bytes.Buffer
+	totals, err := writeBatch(model, []int{0}, false, strings.NewReader(strings.Join(lines, "\n")), &out, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals.rows != 40 || totals.scored != 40 || totals.correct != 30 {
+		t.Fatalf("totals %+v", totals)
+	}
+	records := readBatchOutput(t, &out)
+	if records[0][0] != "col0" || len(records) != 41 {
+		t.Fatal("output", records[0], len(records))
+	}
+}
+
+func TestWriteBatchErrors(t *testing.T) {
+	model := testBatchModel()
+	for _, c := range []struct {
+		name    string
+		bad     string
+		message string
+	}{
+		{"a value that is not a number", "idx,1,x,3", "line 301"},
+		{"too few features", "idx,1,2", "line 301 has 2 feature columns, expected 3"},
+		{"too many features", "idx,1,2,3,4,5", "line 301 has 5 feature columns"},
+	} {
+		lines, _ := batchRows(model, 2*batchSize, false)
+		lines[300] = c.bad
+		var out bytes.Buffer
+		totals, err := writeBatch(model, []int{0}, false, strings.NewReader(strings.Join(lines, "\n")), &out, 3)
+		if err == nil || !strings.Contains(err.Error(), c.message) {
+			t.Fatal(c.name, "expected an error with", c.message, "got", err)
+		}
+		// everything before the bad line is still written, and nothing after
+		records := readBatchOutput(t, &out)
+		if len(records) != 301 || totals.rows != 300 || records[300][0] != "id299" {
+			t.Fatal(c.name, "wrote", len(records), "records and counted", totals.rows)
+		}
+	}
+
+	var out bytes.Buffer
+	if _, err := writeBatch(model, nil, false, strings.NewReader("1,2,3\n\"4,5,6\n"), &out, 1); err == nil {
+		t.Fatal("expected a csv error")
+	}
+}
+
+func TestWriteBatchEmpty(t *testing.T) {
+	var out bytes.Buffer
+	totals, err := writeBatch(testBatchModel(), nil, false, strings.NewReader(""), &out, 2)
+	if err != nil || totals.rows != 0 || out.String() != "prediction,p_a,p_b,p_c\n" {
+		t.Fatalf("%q %+v %v", out.String(), totals, err)
+	}
+}
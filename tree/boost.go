@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// boostLambda is the L2 penalty on leaf values, which keeps leaves with little
+// weight from growing huge
+const boostLambda = 1
+
+type boostSettings struct {
+	rounds       int
+	learningRate float64
+	depth        int     // levels of splits in each tree
+	features     int     // features tried at each split
+	subsample    float64 // share of the rows each round is fit to
+	patience     int     // rounds without a better validation loss before stopping, 0 for never
+}
+
+/*
+booster grows the trees of one boosting round, each fit to the gradients of
+the loss for one class. Splits are chosen by the second order gain and leaves
+are Newton steps, as in XGBoost, so the same code handles squared error (where
+the hessian is always 1), log loss and softmax.
+*/
+type booster struct {
+	boostSettings
+	rows     []datarow
+	features int // columns before the predicted one
+	grad     []float64
+	hess     []float64
+}
+
+// leaf is the learning rate times the Newton step for rows
+func (b *booster) leaf(rows []int) float32 {
+	var g, h float64
+	for _, i := range rows {
+		g += b.grad[i]
+		h += b.hess[i]
+	}
+	return float32(-g / (h + boostLambda) * b.learningRate)
+}
+
+// tree grows a tree from rows, which index b.rows
+func (b *booster) tree(rows []int) *Tree {
+	t, terminal := b.grow(rows, 0)
+	if t == nil {
+		// no split helps, so every row gets the same step
+		t = &Tree{LeftTerminal: terminal, RightTerminal: terminal, RightCount: len(rows)}
+	}
+	return t
+}
+
+// grow splits rows where it lowers the loss most, or returns their leaf
+func (b *booster) grow(rows []int, depth int) (t *Tree, terminal float32) {
+	terminal = b.leaf(rows)
+	if depth >= b.depth || len(rows) < 2 {
+		return nil, terminal
+	}
+	var g, h float64
+	for _, i := range rows {
+		g += b.grad[i]
+		h += b.hess[i]
+	}
+	parent := g * g / (h + boostLambda)
+
+	features := rand.Perm(b.features)
+	if b.boostSettings.features > 0 && b.boostSettings.features < len(features) {
+		features = features[:b.boostSettings.features]
+	}
+	bestGain := 1e-12
+	bestFeature := -1
+	var bestThreshold float32
+	sorted := append([]int(nil), rows...)
+	for _, f := range features {
+		sort.Slice(sorted, func(x, y int) bool { return b.rows[sorted[x]][f] < b.rows[sorted[y]][f] })
+		var gl, hl float64
+		for k := 1; k < len(sorted); k++ {
+			gl += b.grad[sorted[k-1]]
+			hl += b.hess[sorted[k-1]]
+			value := b.rows[sorted[k]][f]
+			if value == b.rows[sorted[k-1]][f] {
+				continue
+			}
+			gr, hr := g-gl, h-hl
+			gain := gl*gl/(hl+boostLambda) + gr*gr/(hr+boostLambda) - parent
+			if gain > bestGain {
+				bestGain = gain
+				bestFeature = f
+				bestThreshold = value
+			}
+		}
+	}
+	if bestFeature == -1 {
+		return nil, terminal
+	}
+
+	var left, right []int
+	for _, i := range rows {
+		if b.rows[i][bestFeature] < bestThreshold {
+			left = append(left, i)
+		} else {
+			right = append(right, i)
+		}
+	}
+	t = &Tree{VariableIndex: float32(bestFeature), ValueIndex: bestThreshold, LeftCount: len(left), RightCount: len(right)}
+	t.LeftNode, t.LeftTerminal = b.grow(left, depth+1)
+	t.RightNode, t.RightTerminal = b.grow(right, depth+1)
+	return t, 0
+}
+
+// boostedForest is the empty additive forest that boosting adds trees to.
+// Two labels share a single sum for the log odds of the second, more get a
+// sum each for softmax, and regression sums the predicted value.
+func boostedForest(labels int, regression bool) *flatForest {
+	f := &flatForest{Additive: true, Classes: 1, Labels: 1, Output: outputSum}
+	if !regression {
+		f.Labels = labels
+		f.Output = outputLogistic
+		if labels > 2 {
+			f.Classes = labels
+			f.Output = outputSoftmax
+		}
+	}
+	return f
+}
+
+// boostLoss is the mean squared error, or the mean log loss, of the sums
+// predicted for rows
+func boostLoss(f *flatForest, sums [][]float64, rows []datarow) (loss float64, accuracy float32) {
+	var correct int
+	for i, row := range rows {
+		actual := float64(row[lastColumnIndex])
+		if f.Output == outputSum {
+			loss += (sums[i][0] - actual) * (sums[i][0] - actual)
+			continue
+		}
+		proba := f.sumsProba(append([]float64(nil), sums[i]...))
+		loss -= math.Log(math.Max(float64(proba[int(actual)]), 1e-15))
+		best := 0
+		for label, p := range proba {
+			if p > proba[best] {
+				best = label
+			}
+		}
+		if best == int(actual) {
+			correct++
+		}
+	}
+	return loss / float64(len(rows)), f_100 * float32(correct) / float32(len(rows))
+}
+
+/*
+boost fits s.rounds rounds of trees to train, each to the gradients of the
+loss of the rounds before it. When there are valid rows, their loss is tracked
+after each round, and boosting stops once it has not improved for s.patience
+rounds. The forest then keeps the rounds up to the best one, and it is an
+error when no round had a validation loss to compare, like when it is NaN.
+*/
+func boost(train []datarow, valid []datarow, labels int, regression bool, s boostSettings) (f *flatForest, trees []*Tree, validLoss []float64, err error) {
+	f = boostedForest(labels, regression)
+	var base float64
+	if regression {
+		for _, row := range train {
+			base += float64(row[lastColumnIndex]) / float64(len(train))
+		}
+	} else if f.Output == outputLogistic {
+		var p float64
+		for _, row := range train {
+			p += float64(row[lastColumnIndex]) / float64(len(train))
+		}
+		p = math.Min(math.Max(p, 1e-6), 1-1e-6)
+		base = math.Log(p / (1 - p))
+	}
+	f.BaseScore = float32(base)
+
+	startSums := func(rows []datarow) [][]float64 {
+		sums := make([][]float64, len(rows))
+		for i := range sums {
+			sums[i] = make([]float64, f.Classes)
+			for c := range sums[i] {
+				sums[i][c] = float64(f.BaseScore)
+			}
+		}
+		return sums
+	}
+	trainSums := startSums(train)
+	validSums := startSums(valid)
+
+	b := &booster{
+		boostSettings: s,
+		rows:          train,
+		features:      lastColumnIndex,
+		grad:          make([]float64, len(train)),
+		hess:          make([]float64, len(train)),
+	}
+	sampleSize := int(math.Max(1, math.Round(s.subsample*float64(len(train)))))
+	if sampleSize > len(train) {
+		sampleSize = len(train)
+	}
+	bestLoss := math.Inf(1)
+	bestRound := -1
+	for round := 0; round < s.rounds; round++ {
+		sample := rand.Perm(len(train))[:sampleSize]
+		// every class's tree this round is fit to the probabilities from
+		// before the round
+		var proba [][]float32
+		if !regression {
+			proba = make([][]float32, len(train))
+			for i := range train {
+				proba[i] = f.sumsProba(append([]float64(nil), trainSums[i]...))
+			}
+		}
+		var roundTrees []*Tree
+		for c := 0; c < f.Classes; c++ {
+			for i, row := range train {
+				actual := row[lastColumnIndex]
+				switch {
+				case regression:
+					b.grad[i] = trainSums[i][0] - float64(actual)
+					b.hess[i] = 1
+				default:
+					label := c
+					if f.Output == outputLogistic {
+						label = 1 // the sum is for the second label
+					}
+					var y float64
+					if int(actual) == label {
+						y = 1
+					}
+					p := float64(proba[i][label])
+					b.grad[i] = p - y
+					b.hess[i] = math.Max(p*(1-p), 1e-6)
+				}
+			}
+			roundTrees = append(roundTrees, b.tree(sample))
+		}
+		for c, t := range roundTrees {
+			for i, row := range train {
+				trainSums[i][c] += float64(t.predict(row))
+			}
+			for i, row := range valid {
+				validSums[i][c] += float64(t.predict(row))
+			}
+		}
+		trees = append(trees, roundTrees...)
+
+		if len(valid) == 0 {
+			continue
+		}
+		loss, accuracy := boostLoss(f, validSums, valid)
+		validLoss = append(validLoss, loss)
+		if regression {
+			log.Println("( boost ) Round", round+1, "/", s.rounds, "validation loss", loss)
+		} else {
+			log.Println("( boost ) Round", round+1, "/", s.rounds, "validation loss", loss, "accuracy", accuracy)
+		}
+		if loss < bestLoss {
+			bestLoss = loss
+			bestRound = round
+		} else if s.patience > 0 && round-bestRound >= s.patience {
+			break
+		}
+	}
+	if len(valid) > 0 && s.patience > 0 {
+		if bestRound < 0 {
+			return nil, nil, validLoss, fmt.Errorf("none of the %d rounds had a validation loss to keep, so there is no best round", len(validLoss))
+		}
+		trees = trees[:(bestRound+1)*f.Classes]
+	}
+
+	forest := flatten(trees)
+	forest.Additive, forest.Classes, forest.Labels = true, f.Classes, f.Labels
+	forest.BaseScore, forest.Output = f.BaseScore, f.Output
+	return forest, trees, validLoss, nil
+}
+
+/*
+trainBoost boosts trees on the loaded trainingCases, holding out the first of
+the -folds to stop early on, and saves the model to -save. With -final, the
+trees are boosted again on all of the data for as many rounds as the
+validation fold chose.
+*/
+func trainBoost() {
+	loadTrainingData()
+	labels := len(indexedVariables)
+	if !*regression && labels < 2 {
+		fmt.Println("-algo=boost needs at least two labels, or -regression")
+		return
+	}
+	depth := *treeDepth
+	if !flagPassed("depth") {
+		depth = 3
+	}
+	s := boostSettings{
+		rounds:       *boostRounds,
+		learningRate: *learningRate,
+		depth:        depth,
+		features:     *overrideFeatureSplitSize,
+		subsample:    *subsetSizePercent,
+		patience:     *boostPatience,
+	}
+	folds := splitIntoParts(trainingCases)
+	valid := folds[0]
+	var train []datarow
+	for _, fold := range folds[1:] {
+		train = append(train, fold...)
+	}
+	task := taskClassification
+	if *regression {
+		task = taskRegression
+	}
+	fmt.Println("task:", task)
+	fmt.Println("features:", lastColumnIndex)
+	fmt.Println("rounds:", s.rounds, "learning rate:", s.learningRate, "depth:", s.depth)
+	fmt.Println("training cases:", len(train), "validation cases:", len(valid))
+
+	forest, trees, validLoss, err := boost(train, valid, labels, *regression, s)
+	if err != nil {
+		fmt.Println("Not saving the model:", err)
+		return
+	}
+	classes := forest.Classes
+	rounds := len(trees) / classes
+	var validSums [][]float64
+	for _, row := range valid {
+		validSums = append(validSums, forest.sums(row))
+	}
+	bestLoss, accuracy := boostLoss(forest, validSums, valid)
+	fmt.Println("\nComplete.")
+	fmt.Println("  Rounds:", rounds, "of", len(validLoss), "boosted")
+	fmt.Println("  Validation loss:", bestLoss)
+	if !*regression {
+		fmt.Println("  Validation accuracy:", accuracy, "%")
+	}
+
+	meta := modelMeta{
+		Procedure:        procedureHoldout,
+		Task:             task,
+		Algorithm:        algoBoost,
+		Created:          time.Now(),
+		DataFile:         *dataFile,
+		Columns:          columnsPerRow,
+		ColumnNames:      columnNames,
+		Folds:            *n_folds,
+		FinalTrees:       len(trees),
+		FeatureSplitSize: s.features,
+		MaxDepth:         s.depth,
+		SubsetPercent:    s.subsample,
+		Rounds:           rounds,
+		LearningRate:     s.learningRate,
+		ValidationLoss:   bestLoss,
+	}
+	if !*regression {
+		meta.FoldScores = []float32{accuracy}
+		meta.MeanAccuracy = accuracy
+	}
+	if *finalForest {
+		fmt.Println("\nBoosting", rounds, "rounds on all", len(trainingCases), "training cases")
+		s.rounds = rounds
+		forest, trees, _, err = boost(trainingCases, nil, labels, *regression, s)
+		if err != nil {
+			fmt.Println("Not saving the model:", err)
+			return
+		}
+		meta.Procedure = procedureFinal
+		meta.FinalTrees = len(trees)
+	}
+
+	err = save(*saveTo, &saveFormat{
+		Trees:            trees,
+		Flat:             forest,
+		IndexedVariables: indexedVariables,
+		Variables:        variables,
+		Meta:             meta,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("\nSaved", len(trees), "trees and", len(indexedVariables), "variables to", *saveTo)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func boostTestSettings() boostSettings {
+	return boostSettings{rounds: 200, learningRate: 0.3, depth: 3, subsample: 1, patience: 10}
+}
+
+func TestBoosterFindsStep(t *testing.T) {
+	setColumnGlobals(3)
+	var rows []datarow
+	for i := 0; i < 20; i++ {
+		rows = append(rows, datarow{float32(i), float32(i % 3), 0})
+	}
+	b := &booster{boostSettings: boostSettings{depth: 1, learningRate: 1}, rows: rows, features: 2}
+	for i, row := range rows {
+		// squared error gradients of a step at 12
+		b.grad = append(b.grad, -1)
+		if row[0] >= 12 {
+			b.grad[i] = 1
+		}
+		b.hess = append(b.hess, 1)
+	}
+	all := make([]int, len(rows))
+	for i := range all {
+		all[i] = i
+	}
+	tree := b.tree(all)
+	if tree.VariableIndex != 0 || tree.ValueIndex != 12 || tree.LeftNode != nil || tree.RightNode != nil {
+		t.Fatalf("expected a stump at x0 < 12, got %+v", tree)
+	}
+	if tree.LeftCount != 12 || tree.RightCount != 8 || tree.LeftTerminal <= 0 || tree.RightTerminal >= 0 {
+		t.Fatalf("leaves %+v", tree)
+	}
+}
+
+func TestBoostRegression(t *testing.T) {
+	rand.Seed(2)
+	setColumnGlobals(3)
+	r := rand.New(rand.NewSource(3))
+	target := func(a, b float32) float32 { return 3*a + float32(math.Sin(2*float64(b))) }
+	var rows []datarow
+	for i := 0; i < 300; i++ {
+		a, b := r.Float32()*5, r.Float32()*5
+		rows = append(rows, datarow{a, b, target(a, b)})
+	}
+	forest, trees, losses, err := boost(rows[:240], rows[240:], 0, true, boostTestSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !forest.Additive || forest.Output != outputSum || forest.Classes != 1 || len(trees) != len(forest.Roots) {
+		t.Fatalf("forest %+v", forest)
+	}
+	if losses[len(losses)-1] > losses[0]/20 {
+		t.Fatal("validation loss did not fall", losses[0], losses[len(losses)-1])
+	}
+	row := datarow{2, 1, 0}
+	if got := regressionPredict(forest, row); math.Abs(float64(got-target(2, 1))) > 0.5 {
+		t.Fatal("predicted", got, "for", target(2, 1))
+	}
+	// the forest's sums are the base plus every tree
+	sum := forest.BaseScore
+	for _, tree := range trees {
+		sum += tree.predict(row)
+	}
+	if math.Abs(float64(sum-regressionPredict(forest, row))) > 1e-4 {
+		t.Fatal("trees add up to", sum, "not", regressionPredict(forest, row))
+	}
+}
+
+func TestBoostClassification(t *testing.T) {
+	rand.Seed(4)
+	setColumnGlobals(3)
+	r := rand.New(rand.NewSource(5))
+	// three labels in bands of x0, with x1 as noise
+	var rows []datarow
+	for i := 0; i < 300; i++ {
+		a := r.Float32() * 3
+		rows = append(rows, datarow{a, r.Float32(), float32(int(a))})
+	}
+	s := boostTestSettings()
+	s.subsample = 0.8
+	forest, _, _, err := boost(rows[:240], rows[240:], 3, false, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forest.Output != outputSoftmax || forest.Classes != 3 {
+		t.Fatalf("forest %+v", forest)
+	}
+	var validSums [][]float64
+	for _, row := range rows[240:] {
+		validSums = append(validSums, forest.sums(row))
+	}
+	if _, accuracy := boostLoss(forest, validSums, rows[240:]); accuracy < 95 {
+		t.Fatal("accuracy", accuracy)
+	}
+
+	// two labels share one logistic sum
+	for _, row := range rows {
+		row[2] = float32(int(row[0]) % 2)
+	}
+	forest, _, _, err = boost(rows[:240], rows[240:], 2, false, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forest.Output != outputLogistic || forest.Classes != 1 || forest.Labels != 2 {
+		t.Fatalf("forest %+v", forest)
+	}
+	if baggingPredict(forest, datarow{1.5, 0, 0}) != 1 || baggingPredict(forest, datarow{2.5, 0, 0}) != 0 {
+		t.Fatal("expected the middle band to be the second label")
+	}
+}
+
+func TestBoostStopsEarly(t *testing.T) {
+	rand.Seed(6)
+	setColumnGlobals(2)
+	// labels with nothing to learn from, so the validation loss soon rises
+	var rows []datarow
+	for i := 0; i < 200; i++ {
+		rows = append(rows, datarow{rand.Float32(), float32(rand.Intn(3))})
+	}
+	s := boostTestSettings()
+	_, trees, losses, err := boost(rows[:150], rows[150:], 3, false, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	best := 0
+	for i, loss := range losses {
+		if loss < losses[best] {
+			best = i
+		}
+	}
+	if len(losses) != best+1+s.patience || len(trees) != 3*(best+1) {
+		t.Fatal("boosted", len(losses), "rounds, kept", len(trees)/3, "best", best+1)
+	}
+
+	// without patience, every round is kept
+	s.rounds, s.patience = 20, 0
+	_, trees, losses, err = boost(rows[:150], rows[150:], 3, false, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(losses) != 20 || len(trees) != 60 {
+		t.Fatal("boosted", len(losses), "rounds, kept", len(trees)/3)
+	}
+}
+
+func TestBoostWithoutBestRound(t *testing.T) {
+	rand.Seed(7)
+	setColumnGlobals(2)
+	var rows []datarow
+	for i := 0; i < 100; i++ {
+		rows = append(rows, datarow{rand.Float32(), rand.Float32()})
+	}
+	// a validation loss that is never a number never improves
+	valid := []datarow{{0.5, float32(math.NaN())}}
+	s := boostTestSettings()
+	s.rounds = 5
+	if _, trees, _, err := boost(rows, valid, 0, true, s); err == nil {
+		t.Fatal("expected an error, kept", len(trees), "trees")
+	}
+}
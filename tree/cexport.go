@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+exportC writes the -model as a single C header, with the flattened trees in
+static arrays and an inline predict function. It follows Tree.predict exactly:
+a row goes left when its value is < the split value, and the split values are
+written as hex floats so none are rounded.
+*/
+func exportC() {
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(len(loaded.Trees), "Trees loaded")
+
+	header, err := generateCHeader(&loaded, *codegenPkg)
+	if err != nil {
+		panic(err)
+	}
+	outFile := *saveTo
+	if outFile == "" {
+		outFile = *codegenPkg + ".h"
+	}
+	err = ioutil.WriteFile(outFile, header, 0644)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Wrote C header to", outFile)
+}
+
+func generateCHeader(model *saveFormat, prefix string) (src []byte, err error) {
+	f := model.Flat
+	if f.Additive {
+		return nil, fmt.Errorf("cannot export an additive forest to C")
+	}
+	if model.Meta.Task == taskAnomaly {
+		return nil, fmt.Errorf("cannot export an isolation forest to C")
+	}
+	if model.Meta.Task == taskRegression {
+		return nil, fmt.Errorf("cannot export a regression forest to C")
+	}
+	if f.Outputs > 0 {
+		return nil, fmt.Errorf("cannot export a multi-output forest to C")
+	}
+	if f.ObliqueStart != nil {
+		return nil, fmt.Errorf("cannot export a forest with oblique splits to C")
+	}
+	if len(f.Roots) == 0 {
+		return nil, fmt.Errorf("the model has no trees")
+	}
+	upper := strings.ToUpper(prefix)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "/* Generated by pine tree -toc. Do not edit. */\n\n")
+	fmt.Fprintf(&b, "#ifndef %s_H\n#define %s_H\n\n#include <stdint.h>\n\n", upper, upper)
+	if model.Meta.Columns > 0 {
+		fmt.Fprintf(&b, "#define %s_FEATURES %d\n", upper, model.Meta.Columns-1)
+	}
+	fmt.Fprintf(&b, "#define %s_LABELS %d\n", upper, len(model.IndexedVariables))
+	fmt.Fprintf(&b, "#define %s_TREES %d\n\n", upper, len(f.Roots))
+
+	fmt.Fprintf(&b, "static const char *const %s_labels[%s_LABELS] = {", prefix, upper)
+	for i, label := range model.IndexedVariables {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(cString(label))
+	}
+	b.WriteString("};\n\n")
+
+	b.WriteString("/* Node i goes left when row[feature[i]] < threshold[i]. A negative child c\n")
+	b.WriteString("   is the terminal leaf[-c-1]. */\n")
+	writeCInts(&b, prefix+"_roots", f.Roots)
+	writeCInts(&b, prefix+"_feature", f.Feature)
+	if err = writeCFloats(&b, prefix+"_threshold", f.Threshold); err != nil {
+		return nil, err
+	}
+	writeCInts(&b, prefix+"_left", f.Left)
+	writeCInts(&b, prefix+"_right", f.Right)
+	leaves := make([]int32, len(f.Leaf))
+	for i, v := range f.Leaf {
+		leaves[i] = int32(v)
+	}
+	writeCInts(&b, prefix+"_leaf", leaves)
+
+	// weighted trees add their weight to the label they vote for
+	voteType, vote, most := "int32_t", "++", "most trees voted for"
+	if f.Weights != nil {
+		if err = writeCFloats(&b, prefix+"_weight", f.Weights); err != nil {
+			return nil, err
+		}
+		voteType = "float"
+		vote = fmt.Sprintf(" += %s_weight[t]", prefix)
+		most = "has the most weight of votes"
+	}
+
+	fmt.Fprintf(&b, `
+/* Returns the label index one tree predicts for the row. */
+static inline int32_t %[1]s_predict_tree(int32_t node, const float *row) {
+    for (;;) {
+        int32_t next = row[%[1]s_feature[node]] < %[1]s_threshold[node]
+            ? %[1]s_left[node] : %[1]s_right[node];
+        if (next < 0) {
+            return %[1]s_leaf[-next - 1];
+        }
+        node = next;
+    }
+}
+
+/* Returns the index into %[1]s_labels that %[5]s.
+   Ties go to the lowest index. */
+static inline int32_t %[1]s_predict(const float *row) {
+    %[3]s votes[%[2]s_LABELS] = {0};
+    int32_t t, i, best = 0;
+    for (t = 0; t < %[2]s_TREES; t++) {
+        votes[%[1]s_predict_tree(%[1]s_roots[t], row)]%[4]s;
+    }
+    for (i = 1; i < %[2]s_LABELS; i++) {
+        if (votes[i] > votes[best]) {
+            best = i;
+        }
+    }
+    return best;
+}
+
+#endif
+`, prefix, upper, voteType, vote, most)
+	return b.Bytes(), nil
+}
+
+func writeCInts(b *bytes.Buffer, name string, values []int32) {
+	fmt.Fprintf(b, "static const int32_t %s[] = {", name)
+	for i, v := range values {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.Itoa(int(v)))
+	}
+	b.WriteString("};\n")
+}
+
+// writeCFloats writes values as hex floats, so none are rounded
+func writeCFloats(b *bytes.Buffer, name string, values []float32) error {
+	fmt.Fprintf(b, "static const float %s[] = {", name)
+	for i, v := range values {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return fmt.Errorf("cannot export the value %v in %s", v, name)
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.FormatFloat(float64(v), 'x', -1, 32) + "f")
+	}
+	b.WriteString("};\n")
+	return nil
+}
+
+// cString quotes s for C, escaping anything that is not printable ASCII
+func cString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		} else if c >= ' ' && c <= '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "\\%03o", c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cDriver is a C program which prints what the header predicts for each of
+// the rows, then what each tree voted
+func cDriver(rows []datarow) []byte {
+	var b bytes.Buffer
+	b.WriteString("#include <stdio.h>\n#include \"pinemodel.h\"\n\n")
+	b.WriteString("static const float rows[] = {")
+	for i, row := range rows {
+		for j, v := range row[:3] {
+			if i > 0 || j > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(strconv.FormatFloat(float64(v), 'x', -1, 32) + "f")
+		}
+	}
+	b.WriteString("};\n")
+	fmt.Fprintf(&b, `
+int main(void) {
+    int32_t r, t;
+    for (r = 0; r < %d; r++) {
+        printf("%%d", pinemodel_predict(&rows[r * 3]));
+        for (t = 0; t < PINEMODEL_TREES; t++) {
+            printf(" %%d", pinemodel_predict_tree(pinemodel_roots[t], &rows[r * 3]));
+        }
+        printf("\n");
+    }
+    return 0;
+}
+`, len(rows))
+	return b.Bytes()
+}
+
+// the header compiled by a C compiler predicts the same as baggingPredict,
+// and its trees vote the same as baggingProba counts
+func TestCHeaderMatchesForest(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	r := rand.New(rand.NewSource(5))
+	rows := randomRows(r, 200, 3)
+	weighted := testBatchModel()
+	weighted.Flat.Weights = make([]float32, len(weighted.Trees))
+	for i := range weighted.Flat.Weights {
+		weighted.Flat.Weights[i] = 0.1 + r.Float32()
+	}
+
+	for name, model := range map[string]*saveFormat{"unweighted": testBatchModel(), "weighted": weighted} {
+		dir := t.TempDir()
+		header, err := generateCHeader(model, "pinemodel")
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "pinemodel.h"), header, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "driver.c"), cDriver(rows), 0644); err != nil {
+			t.Fatal(err)
+		}
+		driver := filepath.Join(dir, "driver")
+		if out, err := exec.Command(cc, "-std=c99", "-Wall", "-Werror", "-o", driver, filepath.Join(dir, "driver.c")).CombinedOutput(); err != nil {
+			t.Fatal(name, err, "\n"+string(out))
+		}
+		out, err := exec.Command(driver).Output()
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if len(lines) != len(rows) {
+			t.Fatal(name, "got", len(lines), "lines for", len(rows), "rows")
+		}
+		f := model.Flat
+		for i, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) != 1+len(f.Roots) {
+				t.Fatal(name, "row", i, "printed", line)
+			}
+			if expected := strconv.Itoa(int(baggingPredict(f, rows[i]))); fields[0] != expected {
+				t.Fatal(name, "row", i, "predicted", fields[0], "expected", expected)
+			}
+			votes := make([]float32, len(model.IndexedVariables))
+			for tree, field := range fields[1:] {
+				label, err := strconv.Atoi(field)
+				if err != nil {
+					t.Fatal(err)
+				}
+				votes[label] += f.treeWeight(tree)
+			}
+			for label, p := range baggingProba(f, rows[i], len(votes)) {
+				if diff := votes[label]/f.totalWeight() - p; diff > 1e-6 || diff < -1e-6 {
+					t.Fatal(name, "row", i, "votes", votes, "for proba", p)
+				}
+			}
+		}
+	}
+}
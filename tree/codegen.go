@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// codegenSamples is how many rows the generated test checks
+const codegenSamples = 50
+
+/*
+codegen writes a dependency free Go package that predicts the same as the
+-model. Each tree becomes a function of nested if statements, or with -table,
+all of the trees become static arrays walked in a loop. A test file is written
+next to it which checks Predict against baggingPredict on sample rows.
+*/
+func codegen() {
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(len(loaded.Trees), "Trees loaded")
+
+	dir := *saveTo
+	if dir == "" {
+		dir = *codegenPkg
+	}
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		panic(err)
+	}
+
+	src, err := generateGo(&loaded, *codegenPkg, *codegenTable)
+	if err != nil {
+		panic(err)
+	}
+	srcFile := filepath.Join(dir, *codegenPkg+".go")
+	err = ioutil.WriteFile(srcFile, src, 0644)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Wrote", srcFile)
+
+	samples, err := codegenSampleRows(&loaded)
+	if err != nil {
+		panic(err)
+	}
+	test, err := generateGoTest(&loaded, *codegenPkg, samples)
+	if err != nil {
+		panic(err)
+	}
+	testFile := filepath.Join(dir, *codegenPkg+"_test.go")
+	err = ioutil.WriteFile(testFile, test, 0644)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Wrote", testFile)
+}
+
+func generateGo(model *saveFormat, pkg string, table bool) (src []byte, err error) {
+	if model.Flat.Additive {
+		return nil, fmt.Errorf("cannot generate code for an additive forest")
+	}
+	if model.Meta.Task == taskAnomaly {
+		return nil, fmt.Errorf("cannot generate code for an isolation forest")
+	}
+	if model.Meta.Task == taskRegression {
+		return nil, fmt.Errorf("cannot generate code for a regression forest")
+	}
+	if model.Flat.Outputs > 0 {
+		return nil, fmt.Errorf("cannot generate code for a multi-output forest")
+	}
+	if model.Flat.ObliqueStart != nil {
+		return nil, fmt.Errorf("cannot generate code for a forest with oblique splits")
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by pine tree -codegen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "// Package %s predicts with a random decision forest of %d trees.\n", pkg, len(model.Trees))
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "// Labels are every value Predict can return.\n")
+	fmt.Fprintf(&b, "var Labels = %#v\n\n", model.IndexedVariables)
+	if model.Meta.Columns > 0 {
+		fmt.Fprintf(&b, "// Features is how many values a row should have.\n")
+		fmt.Fprintf(&b, "const Features = %d\n\n", model.Meta.Columns-1)
+	}
+	weights := model.Flat.Weights
+	if weights == nil {
+		fmt.Fprintf(&b, `// Predict returns the label most trees voted for. Ties go to the label that
+// comes first in Labels.
+func Predict(row []float32) string {
+	var votes [%d]int
+`, len(model.IndexedVariables))
+	} else {
+		fmt.Fprintf(&b, `// Predict returns the label with the most weight of votes. Ties go to the label
+// that comes first in Labels.
+func Predict(row []float32) string {
+	var votes [%d]float32
+`, len(model.IndexedVariables))
+	}
+
+	switch {
+	case table && weights == nil:
+		b.WriteString(`	for _, root := range roots {
+		votes[predictTree(root, row)]++
+	}
+`)
+	case table:
+		b.WriteString(`	for t, root := range roots {
+		votes[predictTree(root, row)] += weights[t]
+	}
+`)
+	default:
+		for i := range model.Trees {
+			if weights == nil {
+				fmt.Fprintf(&b, "\tvotes[tree%d(row)]++\n", i)
+				continue
+			}
+			weight, err := goFloat(weights[i])
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, "\tvotes[tree%d(row)] += %s\n", i, weight)
+		}
+	}
+	b.WriteString(`	best := 0
+	for i, count := range votes {
+		if count > votes[best] {
+			best = i
+		}
+	}
+	return Labels[best]
+}
+`)
+
+	if table {
+		err = writeGoTable(&b, model.Flat)
+	} else {
+		for i, t := range model.Trees {
+			fmt.Fprintf(&b, "\nfunc tree%d(row []float32) int {\n", i)
+			err = writeGoNode(&b, t, 1)
+			if err != nil {
+				break
+			}
+			b.WriteString("}\n")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(b.Bytes())
+}
+
+// writeGoNode writes the if statements for t, which return a variable index
+func writeGoNode(b *bytes.Buffer, t *Tree, depth int) error {
+	indent := strings.Repeat("\t", depth)
+	threshold, err := goFloat(t.ValueIndex)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(b, "%sif row[%d] < %s {\n", indent, int(t.VariableIndex), threshold)
+	if t.LeftNode != nil {
+		err = writeGoNode(b, t.LeftNode, depth+1)
+		if err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(b, "%s\treturn %d\n", indent, int(t.LeftTerminal))
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+	if t.RightNode != nil {
+		return writeGoNode(b, t.RightNode, depth)
+	}
+	fmt.Fprintf(b, "%sreturn %d\n", indent, int(t.RightTerminal))
+	return nil
+}
+
+// writeGoTable writes the flatForest arrays and a loop to walk them
+func writeGoTable(b *bytes.Buffer, f *flatForest) error {
+	fmt.Fprintf(b, "\n// Node i goes left when row[feature[i]] < threshold[i]. A negative child c\n")
+	fmt.Fprintf(b, "// is the terminal leaf[-c-1].\n")
+	fmt.Fprintf(b, "var roots = %#v\n\n", f.Roots)
+	fmt.Fprintf(b, "var feature = %#v\n\n", f.Feature)
+	b.WriteString("var threshold = []float32{")
+	for i, v := range f.Threshold {
+		s, err := goFloat(v)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(s)
+	}
+	b.WriteString("}\n\n")
+	if f.Weights != nil {
+		b.WriteString("// weights is how much the vote of each tree counts.\nvar weights = []float32{")
+		for i, v := range f.Weights {
+			s, err := goFloat(v)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(s)
+		}
+		b.WriteString("}\n\n")
+	}
+	fmt.Fprintf(b, "var left = %#v\n\n", f.Left)
+	fmt.Fprintf(b, "var right = %#v\n\n", f.Right)
+	b.WriteString("var leaf = []int{")
+	for i, v := range f.Leaf {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.Itoa(int(v)))
+	}
+	b.WriteString("}\n")
+	b.WriteString(`
+func predictTree(node int32, row []float32) int {
+	for {
+		var next int32
+		if row[feature[node]] < threshold[node] {
+			next = left[node]
+		} else {
+			next = right[node]
+		}
+		if next < 0 {
+			return leaf[-next-1]
+		}
+		node = next
+	}
+}
+`)
+	return nil
+}
+
+// goFloat formats v as a Go constant which converts back to exactly v
+func goFloat(v float32) (string, error) {
+	if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+		return "", fmt.Errorf("cannot generate code for the split value %v", v)
+	}
+	s := strconv.FormatFloat(float64(v), 'g', -1, 32)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s, nil
+}
+
+// codegenSampleRows takes rows from -data when it is given, otherwise makes
+// random rows spread around the split values of each feature.
+func codegenSampleRows(model *saveFormat) (rows []datarow, err error) {
+	features := model.Meta.Columns - 1
+	if features <= 0 {
+		for _, f := range model.Flat.Feature {
+			if int(f)+1 > features {
+				features = int(f) + 1
+			}
+		}
+	}
+	setColumnGlobals(features + 1)
+
+	if *dataFile != "" {
+		buf, err := ioutil.ReadFile(*dataFile)
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+		if *hasHeader {
+			lines = lines[1:]
+		}
+		for _, line := range lines {
+			if len(rows) == codegenSamples {
+				break
+			}
+			row, err := parseFeatures(strings.Split(line, ","))
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	}
+
+	low := make([]float32, features)
+	high := make([]float32, features)
+	for i, f := range model.Flat.Feature {
+		v := model.Flat.Threshold[i]
+		if v < low[f] {
+			low[f] = v
+		}
+		if v > high[f] {
+			high[f] = v
+		}
+	}
+	for i := 0; i < codegenSamples; i++ {
+		row := make(datarow, columnsPerRow)
+		for f := 0; f < features; f++ {
+			spread := high[f] - low[f] + 1
+			row[f] = low[f] - 0.5 + rand.Float32()*spread
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func generateGoTest(model *saveFormat, pkg string, rows []datarow) (src []byte, err error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by pine tree -codegen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	b.WriteString("import \"testing\"\n\n")
+	b.WriteString("// samples were predicted by the pine model this package was generated from\n")
+	b.WriteString("var samples = []struct {\n\trow   []float32\n\tlabel string\n}{\n")
+	for _, row := range rows {
+		label := model.IndexedVariables[int(baggingPredict(model.Flat, row))]
+		b.WriteString("\t{[]float32{")
+		for i, v := range row[:lastColumnIndex] {
+			s, err := goFloat(v)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(s)
+		}
+		fmt.Fprintf(&b, "}, %q},\n", label)
+	}
+	b.WriteString("}\n")
+	b.WriteString(`
+func TestPredict(t *testing.T) {
+	for i, s := range samples {
+		if got := Predict(s.row); got != s.label {
+			t.Errorf("sample %d: got %q, expected %q", i, got, s.label)
+		}
+	}
+}
+`)
+	return format.Source(b.Bytes())
+}
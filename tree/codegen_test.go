@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// the generated package is built in a module of its own, and its own test
+// checks Predict against baggingPredict on the sample rows
+func TestGeneratedPackage(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go tool to build the generated package with")
+	}
+	r := rand.New(rand.NewSource(4))
+	setColumnGlobals(4)
+	rows := randomRows(r, codegenSamples, 3)
+	weighted := testBatchModel()
+	weighted.Flat.Weights = make([]float32, len(weighted.Trees))
+	for i := range weighted.Flat.Weights {
+		weighted.Flat.Weights[i] = 0.1 + r.Float32()
+	}
+
+	for _, c := range []struct {
+		name  string
+		model *saveFormat
+		table bool
+	}{
+		{"if statements", testBatchModel(), false},
+		{"table", testBatchModel(), true},
+		{"weighted if statements", weighted, false},
+		{"weighted table", weighted, true},
+	} {
+		dir := t.TempDir()
+		src, err := generateGo(c.model, "pinemodel", c.table)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		test, err := generateGoTest(c.model, "pinemodel", rows)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		for name, content := range map[string][]byte{
+			"go.mod":            []byte("module example.com/pinemodel\n\ngo 1.16\n"),
+			"pinemodel.go":      src,
+			"pinemodel_test.go": test,
+		} {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, args := range [][]string{{"vet", "."}, {"test", "."}} {
+			cmd := exec.Command(goTool, args...)
+			cmd.Dir = dir
+			cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatal(c.name, "go", args[0], err, "\n"+string(out))
+			}
+		}
+	}
+}
+
+func TestGenerateGoRejectsUnsupportedForests(t *testing.T) {
+	oblique := testBatchModel()
+	oblique.Trees[0].ObliqueFeatures = []int32{0, 1}
+	oblique.Trees[0].ObliqueWeights = []float32{1, 1}
+	oblique.Flat = flatten(oblique.Trees)
+	regression := testBatchModel()
+	regression.Meta.Task = taskRegression
+	for name, model := range map[string]*saveFormat{"oblique": oblique, "regression": regression} {
+		if _, err := generateGo(model, "pinemodel", false); err == nil {
+			t.Fatal("expected an error for a", name, "forest")
+		}
+	}
+}
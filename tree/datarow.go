@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"strconv"
 	"strings"
 )
@@ -26,6 +27,15 @@ func parseRow(row string, rowIndex int) (dr datarow) {
 		dr[i] = float32(nc)
 	}
 	prediction := cols[lastColumnIndex]
+	if *regression {
+		nc, err := strconv.ParseFloat(strings.TrimSpace(prediction), 32)
+		if err != nil {
+			fmt.Println("row=", rowIndex, "col=", lastColumnIndex)
+			panic(err)
+		}
+		dr[lastColumnIndex] = float32(nc)
+		return dr
+	}
 	if _, existsYet := variables[prediction]; !existsYet {
 		indexedVariables = append(indexedVariables, prediction)
 		newIndex := len(indexedVariables) - 1
@@ -35,3 +45,34 @@ func parseRow(row string, rowIndex int) (dr datarow) {
 
 	return dr
 }
+
+// readFeatureRows reads the feature columns of a csv file, skipping the
+// -header if there is one, and the predicted column's text when a line has it
+func readFeatureRows(path string) (header []string, rows []datarow, labels []string, err error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	if *hasHeader {
+		header = strings.Split(lines[0], ",")
+		lines = lines[1:]
+	}
+	for i, line := range lines {
+		cols := strings.Split(strings.TrimSpace(line), ",")
+		if len(cols) < lastColumnIndex {
+			return nil, nil, nil, fmt.Errorf("line %d has %d columns, expected %d features", i+1, len(cols), lastColumnIndex)
+		}
+		row, err := parseFeatures(cols)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		rows = append(rows, row)
+		var label string
+		if len(cols) > lastColumnIndex {
+			label = cols[lastColumnIndex]
+		}
+		labels = append(labels, label)
+	}
+	return header, rows, labels, nil
+}
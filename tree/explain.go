@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// pathStep is one split along the way from the root of a tree to its terminal.
+// An oblique split's Value is the sum of its Features times their Weights.
+type pathStep struct {
+	Feature   int       `json:"feature"`
+	Name      string    `json:"name,omitempty"`
+	Features  []int32   `json:"features,omitempty"`
+	Weights   []float32 `json:"weights,omitempty"`
+	Threshold float32   `json:"threshold"`
+	Value     float32   `json:"value"`
+	Left      bool      `json:"left"` // Value < Threshold
+}
+
+type treeExplanation struct {
+	Tree  int      `json:"tree"`
+	Vote  string   `json:"vote,omitempty"`
+	Value *float32 `json:"value,omitempty"` // the leaf value, in an additive forest
+	// Weight is how much the tree's vote counts, when they do not all count once
+	Weight *float32   `json:"weight,omitempty"`
+	Steps  []pathStep `json:"steps"`
+	// Leaf is how many training rows with each label reached the terminal,
+	// when the model has them
+	Leaf map[string]int `json:"leaf,omitempty"`
+}
+
+// featureContribution is how much a feature's value moved the explained
+// output away from the base value
+type featureContribution struct {
+	Feature      int     `json:"feature"`
+	Name         string  `json:"name"`
+	Value        float32 `json:"value"`
+	Contribution float64 `json:"contribution"`
+}
+
+type explainResponse struct {
+	Label         string             `json:"label"`
+	Probabilities map[string]float32 `json:"probabilities,omitempty"`
+	// Base plus every contribution is the predicted label's share of the
+	// votes, or for an additive forest its summed leaf values. Base is that
+	// output averaged over the training rows.
+	Base          *float64              `json:"base,omitempty"`
+	Contributions []featureContribution `json:"contributions,omitempty"` // largest first
+	// ContributionsError says why there are no contributions, like models
+	// trained before the row counts were kept
+	ContributionsError string            `json:"contributionsError,omitempty"`
+	Trees              []treeExplanation `json:"trees"`
+}
+
+var errNoCounts = errors.New("the model has no training row counts, so it cannot be explained with TreeSHAP; train it again")
+var errOblique = errors.New("oblique splits combine features, so the model cannot be explained with TreeSHAP")
+
+// decisionPath follows row through the tree the same way as predict, and
+// returns every split it passed and the terminal's class counts.
+func (t *Tree) decisionPath(row datarow, names []string) (steps []pathStep, prediction float32, classes []int) {
+	node := t
+	for {
+		feature := int(node.VariableIndex)
+		step := pathStep{
+			Feature:   feature,
+			Features:  node.ObliqueFeatures,
+			Weights:   node.ObliqueWeights,
+			Threshold: node.ValueIndex,
+			Value:     node.splitValue(row),
+		}
+		step.Left = step.Value < node.ValueIndex
+		if feature < len(names) {
+			step.Name = names[feature]
+		}
+		steps = append(steps, step)
+		if step.Left {
+			if node.LeftNode == nil {
+				return steps, node.LeftTerminal, node.LeftClasses
+			}
+			node = node.LeftNode
+		} else {
+			if node.RightNode == nil {
+				return steps, node.RightTerminal, node.RightClasses
+			}
+			node = node.RightNode
+		}
+	}
+}
+
+// explainRow predicts row, with the path through every tree and the TreeSHAP
+// contribution of each feature toward the predicted label
+func explainRow(model *saveFormat, row datarow, features int) (res explainResponse) {
+	best := 0
+	if model.Meta.Task == taskRegression {
+		res.Label = strconv.FormatFloat(float64(regressionPredict(model.Flat, row)), 'g', -1, 32)
+	} else {
+		proba := baggingProba(model.Flat, row, len(model.IndexedVariables))
+		for i, p := range proba {
+			if p > proba[best] {
+				best = i
+			}
+		}
+		res.Label = model.IndexedVariables[best]
+		res.Probabilities = make(map[string]float32)
+		for i, p := range proba {
+			res.Probabilities[model.IndexedVariables[i]] = p
+		}
+	}
+
+	names := treeRenderer{model: model}
+	for i, tree := range model.Trees {
+		steps, vote, classes := tree.decisionPath(row, model.Meta.ColumnNames)
+		explanation := treeExplanation{Tree: i, Steps: steps}
+		if model.Flat.Additive || model.Flat.Averaged {
+			explanation.Value = &vote
+		} else {
+			explanation.Vote = model.IndexedVariables[int(vote)]
+			if model.Flat.Weights != nil {
+				explanation.Weight = &model.Flat.Weights[i]
+			}
+		}
+		for label, count := range classes {
+			if count > 0 && label < len(model.IndexedVariables) {
+				if explanation.Leaf == nil {
+					explanation.Leaf = make(map[string]int)
+				}
+				explanation.Leaf[model.IndexedVariables[label]] = count
+			}
+		}
+		res.Trees = append(res.Trees, explanation)
+	}
+
+	base, phi, err := forestSHAP(model, row, best, features)
+	if err != nil {
+		res.ContributionsError = err.Error()
+		return res
+	}
+	res.Base = &base
+	for f, c := range phi {
+		res.Contributions = append(res.Contributions, featureContribution{
+			Feature:      f,
+			Name:         names.feature(&Tree{VariableIndex: float32(f)}),
+			Value:        row[f],
+			Contribution: c,
+		})
+	}
+	sort.SliceStable(res.Contributions, func(i, j int) bool {
+		return math.Abs(res.Contributions[i].Contribution) > math.Abs(res.Contributions[j].Contribution)
+	})
+	return res
+}
+
+/*
+forestSHAP returns the exact TreeSHAP values of each of the features for the
+forest's output toward class, and the base value they start from. For a
+voting forest the output is the share of the votes for class, and each tree
+explains a leaf value of its share of the votes when it votes for class and 0
+otherwise. For an additive forest it is the class's summed leaf values, before
+they are turned into probabilities, and for a regression forest the average.
+*/
+func forestSHAP(model *saveFormat, row datarow, class int, features int) (base float64, phi []float64, err error) {
+	phi = make([]float64, features)
+	f := model.Flat
+	if f.ObliqueStart != nil {
+		return 0, nil, errOblique
+	}
+	var value func(terminal float32) float64
+	var share float64
+	sign := 1.0
+	if f.Additive {
+		value = func(terminal float32) float64 { return float64(terminal) }
+		base = float64(f.BaseScore)
+		if f.Output == outputLogistic && class == 0 {
+			// the trees add to the log odds of the second label
+			sign = -1
+		}
+	} else if f.Averaged {
+		value = func(terminal float32) float64 { return float64(terminal) * share }
+	} else {
+		value = func(terminal float32) float64 {
+			if int(terminal) == class {
+				return share
+			}
+			return 0
+		}
+	}
+	total := float64(f.totalWeight())
+	for i, t := range model.Trees {
+		if f.Additive && f.Classes > 1 && i%f.Classes != class {
+			continue
+		}
+		if t.LeftCount+t.RightCount == 0 {
+			return 0, nil, errNoCounts
+		}
+		share = float64(f.treeWeight(i)) / total
+		base += t.expectedValue(value)
+		t.shap(row, value, phi)
+	}
+	for i := range phi {
+		phi[i] *= sign
+	}
+	return base * sign, phi, nil
+}
+
+// expectedValue is the average leaf value over the training rows
+func (t *Tree) expectedValue(value func(terminal float32) float64) float64 {
+	var sum float64
+	if t.LeftNode != nil {
+		sum += t.LeftNode.expectedValue(value) * float64(t.LeftCount)
+	} else {
+		sum += value(t.LeftTerminal) * float64(t.LeftCount)
+	}
+	if t.RightNode != nil {
+		sum += t.RightNode.expectedValue(value) * float64(t.RightCount)
+	} else {
+		sum += value(t.RightTerminal) * float64(t.RightCount)
+	}
+	return sum / float64(t.LeftCount+t.RightCount)
+}
+
+// shapNode is a split, or a terminal when t is nil
+type shapNode struct {
+	t        *Tree
+	terminal float32
+	cover    float64
+}
+
+// shapPathElement is a feature on the path to the current node, with the
+// share of rows that go this way without it (zero) and with it (one)
+type shapPathElement struct {
+	feature int
+	zero    float64
+	one     float64
+	weight  float64
+}
+
+// shap adds the tree's TreeSHAP values to phi, following Algorithm 2 of
+// Lundberg et al., "Consistent Individualized Feature Attribution for Tree
+// Ensembles". It goes left on < like predict.
+func (t *Tree) shap(row datarow, value func(terminal float32) float64, phi []float64) {
+	root := shapNode{t: t, cover: float64(t.LeftCount + t.RightCount)}
+	shapRecurse(root, row, value, phi, nil, 0, 1, 1, -1)
+}
+
+func shapRecurse(n shapNode, row datarow, value func(terminal float32) float64, phi []float64,
+	parent []shapPathElement, depth int, zero, one float64, feature int) {
+	path := make([]shapPathElement, depth+1)
+	copy(path, parent)
+	shapExtend(path, depth, zero, one, feature)
+
+	if n.t == nil {
+		v := value(n.terminal)
+		for i := 1; i <= depth; i++ {
+			w := shapUnwoundSum(path, depth, i)
+			phi[path[i].feature] += w * (path[i].one - path[i].zero) * v
+		}
+		return
+	}
+
+	t := n.t
+	left := shapNode{t: t.LeftNode, terminal: t.LeftTerminal, cover: float64(t.LeftCount)}
+	right := shapNode{t: t.RightNode, terminal: t.RightTerminal, cover: float64(t.RightCount)}
+	hot, cold := right, left
+	split := int(t.VariableIndex)
+	if row[split] < t.ValueIndex {
+		hot, cold = left, right
+	}
+
+	// a feature split on again above is taken out of the path and added back
+	// with both splits' shares
+	incomingZero, incomingOne := 1.0, 1.0
+	for i := 0; i <= depth; i++ {
+		if path[i].feature == split {
+			incomingZero, incomingOne = path[i].zero, path[i].one
+			shapUnwind(path, depth, i)
+			depth--
+			break
+		}
+	}
+	// a side that neither the row nor any training rows reach adds nothing,
+	// and would divide by zero
+	if hotZero := hot.cover / n.cover * incomingZero; hotZero > 0 || incomingOne > 0 {
+		shapRecurse(hot, row, value, phi, path, depth+1, hotZero, incomingOne, split)
+	}
+	if coldZero := cold.cover / n.cover * incomingZero; coldZero > 0 {
+		shapRecurse(cold, row, value, phi, path, depth+1, coldZero, 0, split)
+	}
+}
+
+func shapExtend(path []shapPathElement, depth int, zero, one float64, feature int) {
+	path[depth] = shapPathElement{feature: feature, zero: zero, one: one}
+	if depth == 0 {
+		path[depth].weight = 1
+	}
+	for i := depth - 1; i >= 0; i-- {
+		path[i+1].weight += one * path[i].weight * float64(i+1) / float64(depth+1)
+		path[i].weight = zero * path[i].weight * float64(depth-i) / float64(depth+1)
+	}
+}
+
+func shapUnwind(path []shapPathElement, depth int, index int) {
+	one, zero := path[index].one, path[index].zero
+	next := path[depth].weight
+	for i := depth - 1; i >= 0; i-- {
+		if one != 0 {
+			w := path[i].weight
+			path[i].weight = next * float64(depth+1) / (float64(i+1) * one)
+			next = w - path[i].weight*zero*float64(depth-i)/float64(depth+1)
+		} else {
+			path[i].weight = path[i].weight * float64(depth+1) / (zero * float64(depth-i))
+		}
+	}
+	for i := index; i < depth; i++ {
+		path[i].feature = path[i+1].feature
+		path[i].zero = path[i+1].zero
+		path[i].one = path[i+1].one
+	}
+}
+
+// shapUnwoundSum is the total weight of the path with element index taken
+// out, without changing the path
+func shapUnwoundSum(path []shapPathElement, depth int, index int) (total float64) {
+	one, zero := path[index].one, path[index].zero
+	next := path[depth].weight
+	for i := depth - 1; i >= 0; i-- {
+		if one != 0 {
+			w := next * float64(depth+1) / (float64(i+1) * one)
+			total += w
+			next = path[i].weight - w*zero*float64(depth-i)/float64(depth+1)
+		} else {
+			total += path[i].weight / zero * float64(depth+1) / float64(depth-i)
+		}
+	}
+	return total
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// randomCountedTree is like randomTree, but records how many of rows
+// training rows went each way, often none, and often repeats its parent's
+// split like trained trees do
+func randomCountedTree(r *rand.Rand, depth, features, labels, rows int) *Tree {
+	t := &Tree{
+		VariableIndex: float32(r.Intn(features)),
+		ValueIndex:    float32(r.Intn(7)) - 3,
+		LeftTerminal:  float32(r.Intn(labels)),
+		RightTerminal: float32(r.Intn(labels)),
+	}
+	switch r.Intn(4) {
+	case 0:
+		t.LeftCount = 0
+	case 1:
+		t.LeftCount = rows
+	default:
+		t.LeftCount = r.Intn(rows + 1)
+	}
+	t.RightCount = rows - t.LeftCount
+	if depth > 1 && t.LeftCount > 1 && r.Intn(4) > 0 {
+		t.LeftNode = randomCountedTree(r, depth-1, features, labels, t.LeftCount)
+	}
+	if depth > 1 && t.RightCount > 1 && r.Intn(4) > 0 {
+		t.RightNode = randomCountedTree(r, depth-1, features, labels, t.RightCount)
+	}
+	for _, child := range []*Tree{t.LeftNode, t.RightNode} {
+		if child != nil && r.Intn(3) == 0 {
+			child.VariableIndex, child.ValueIndex = t.VariableIndex, t.ValueIndex
+		}
+	}
+	return t
+}
+
+// conditionalValue is the tree's expected value when only the features in
+// known are taken from row, following the training rows for the rest
+func conditionalValue(t *Tree, row datarow, known map[int]bool, value func(float32) float64) float64 {
+	side := func(child *Tree, terminal float32) float64 {
+		if child != nil {
+			return conditionalValue(child, row, known, value)
+		}
+		return value(terminal)
+	}
+	left := side(t.LeftNode, t.LeftTerminal)
+	right := side(t.RightNode, t.RightTerminal)
+	f := int(t.VariableIndex)
+	if known[f] {
+		if row[f] < t.ValueIndex {
+			return left
+		}
+		return right
+	}
+	return (left*float64(t.LeftCount) + right*float64(t.RightCount)) / float64(t.LeftCount+t.RightCount)
+}
+
+// bruteForceShapley adds up every subset of the other features
+func bruteForceShapley(t *Tree, row datarow, features int, value func(float32) float64) []float64 {
+	factorial := func(n int) float64 {
+		f := 1.0
+		for i := 2; i <= n; i++ {
+			f *= float64(i)
+		}
+		return f
+	}
+	phi := make([]float64, features)
+	for i := 0; i < features; i++ {
+		for subset := 0; subset < 1<<features; subset++ {
+			if subset&(1<<i) != 0 {
+				continue
+			}
+			known := make(map[int]bool)
+			for f := 0; f < features; f++ {
+				if subset&(1<<f) != 0 {
+					known[f] = true
+				}
+			}
+			size := len(known)
+			weight := factorial(size) * factorial(features-size-1) / factorial(features)
+			without := conditionalValue(t, row, known, value)
+			known[i] = true
+			phi[i] += weight * (conditionalValue(t, row, known, value) - without)
+		}
+	}
+	return phi
+}
+
+func TestTreeSHAPMatchesShapleyValues(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	const features = 4
+	value := func(terminal float32) float64 { return float64(terminal) }
+	for trial := 0; trial < 2000; trial++ {
+		tree := randomCountedTree(r, 1+r.Intn(8), features, 3, 2+r.Intn(100))
+		row := make(datarow, features+1)
+		for f := range row[:features] {
+			row[f] = float32(r.Intn(9)) - 4
+		}
+		phi := make([]float64, features)
+		tree.shap(row, value, phi)
+		expected := bruteForceShapley(tree, row, features, value)
+		for f := range phi {
+			if !(math.Abs(phi[f]-expected[f]) <= 1e-9) { // NaN fails too
+				t.Fatalf("trial %d feature %d: TreeSHAP %v, Shapley %v", trial, f, phi, expected)
+			}
+		}
+
+		// the contributions account for the difference from the expected value
+		sum := tree.expectedValue(value)
+		for _, c := range phi {
+			sum += c
+		}
+		if !(math.Abs(sum-float64(tree.predict(row))) <= 1e-9) {
+			t.Fatal("expected value plus contributions", sum, "predicted", tree.predict(row))
+		}
+	}
+}
+
+func TestExplainRowAddsUpToProbability(t *testing.T) {
+	r := rand.New(rand.NewSource(12))
+	var trees []*Tree
+	for i := 0; i < 15; i++ {
+		trees = append(trees, randomCountedTree(r, 5, 3, 3, 60))
+	}
+	model := &saveFormat{
+		Trees:            trees,
+		Flat:             flatten(trees),
+		IndexedVariables: []string{"a", "b", "c"},
+		Meta:             modelMeta{Columns: 4, ColumnNames: []string{"f0", "f1", "f2", "label"}},
+	}
+	for _, row := range randomRows(r, 50, 3) {
+		res := explainRow(model, row, 3)
+		if res.Base == nil || len(res.Contributions) != 3 || len(res.Trees) != 15 {
+			t.Fatal("explanation", res)
+		}
+		sum := *res.Base
+		for i, c := range res.Contributions {
+			sum += c.Contribution
+			if i > 0 && math.Abs(c.Contribution) > math.Abs(res.Contributions[i-1].Contribution) {
+				t.Fatal("contributions are not largest first", res.Contributions)
+			}
+			if c.Name != model.Meta.ColumnNames[c.Feature] || c.Value != row[c.Feature] {
+				t.Fatal("contribution", c)
+			}
+		}
+		if math.Abs(sum-float64(res.Probabilities[res.Label])) > 1e-6 {
+			t.Fatal("base plus contributions", sum, "probability", res.Probabilities[res.Label])
+		}
+	}
+}
+
+func TestExplainAdditive(t *testing.T) {
+	model, err := importXGBoostForest([]byte(xgboostTestForest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := explainRow(model, datarow{6, 0, 5, 2, 0}, 4)
+	if !strings.Contains(res.ContributionsError, "row counts") || res.Base != nil {
+		t.Fatal("expected imported trees without counts to say why they are not explained, got", res.ContributionsError)
+	}
+	if len(res.Trees) != 6 || res.Trees[0].Value == nil || res.Trees[0].Vote != "" {
+		t.Fatal("trees", res.Trees)
+	}
+
+	// with counts, the contributions add up to the summed leaf values
+	for _, tree := range model.Trees {
+		var count func(t *Tree) int
+		count = func(t *Tree) int {
+			t.LeftCount, t.RightCount = 5, 5
+			if t.LeftNode != nil {
+				t.LeftCount = count(t.LeftNode)
+			}
+			if t.RightNode != nil {
+				t.RightCount = count(t.RightNode)
+			}
+			return t.LeftCount + t.RightCount
+		}
+		count(tree)
+	}
+	row := datarow{6, 0, 5, 2, 0}
+	res = explainRow(model, row, 4)
+	class := int(model.Variables[res.Label])
+	var margin float64
+	for i, tree := range model.Trees {
+		if i%3 == class {
+			margin += float64(tree.predict(row))
+		}
+	}
+	sum := *res.Base
+	for _, c := range res.Contributions {
+		sum += c.Contribution
+	}
+	if math.Abs(sum-margin-float64(model.Flat.BaseScore)) > 1e-6 {
+		t.Fatal("base plus contributions", sum, "margin", margin)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import "math"
+
+/*
+flatForest is a whole forest laid out in arrays, so predicting walks slices in
+a loop instead of chasing *Tree pointers, and the split feature is already an
+int32.
+
+Node i goes left when row[Feature[i]] < Threshold[i], the same as
+Tree.predict. A child offset that is zero or more is the next node. A
+negative child c is a terminal, with the value Leaf[-c-1].
+
+Additive forests, like imported gradient boosted trees, add up their leaf
+values instead of voting. Tree i adds to class i % Classes, starting from
+BaseScore, and Output says how the sums become probabilities.
+
+Voting forests whose trees do not all count the same, like AdaBoost's, have a
+weight for each tree in Weights.
+
+Regression forests average their leaf values instead, and keep the training
+values that reached each leaf for quantiles: leaf k has
+Values[ValueStart[k]:ValueStart[k+1]].
+
+A multi-output forest predicts several -targets, and leaf k predicts
+LeafOutputs[k*Outputs : (k+1)*Outputs]. Its Leaf is the first of them.
+
+Oblique node i splits on the sum of row[ObliqueFeature[j]] * ObliqueWeight[j]
+for j from ObliqueStart[i] up to ObliqueStart[i+1], rather than on
+row[Feature[i]]. ObliqueStart is nil when no node is oblique.
+*/
+type flatForest struct {
+	Roots     []int32 // first node of each tree
+	Feature   []int32
+	Threshold []float32
+	Left      []int32
+	Right     []int32
+	Leaf      []float32
+	Labels    int       // one more than the highest variable index in Leaf, for counting votes
+	Weights   []float32 // how much each tree's vote counts, or nil when they all count once
+
+	Averaged   bool // a regression forest, whose leaves are numbers rather than labels
+	Values     []float32
+	ValueStart []int32
+
+	Outputs     int // how many -targets, or 0 for a single predicted column
+	LeafOutputs []float32
+
+	ObliqueStart   []int32
+	ObliqueFeature []int32
+	ObliqueWeight  []float32
+
+	Additive  bool
+	Classes   int
+	BaseScore float32
+	Output    string
+}
+
+// How an additive flatForest turns its sums into probabilities
+const (
+	outputSum      = ""         // the sums are already the probabilities
+	outputSoftmax  = "softmax"  // one sum per label
+	outputLogistic = "logistic" // a single sum for the second of two labels
+)
+
+// flatten lays out the trees as a flatForest, each tree's nodes depth first.
+// Trees whose terminals kept their training values are a regression forest.
+func flatten(trees []*Tree) (f *flatForest) {
+	f = &flatForest{}
+	for _, t := range trees {
+		f.Roots = append(f.Roots, f.addNode(t))
+	}
+	if len(f.LeafOutputs) > 0 {
+		f.Outputs = len(f.LeafOutputs) / len(f.Leaf)
+	}
+	if len(f.ObliqueFeature) > 0 {
+		f.ObliqueStart = append(f.ObliqueStart, int32(len(f.ObliqueFeature)))
+	} else {
+		f.ObliqueStart = nil
+	}
+	if len(f.Values) > 0 {
+		f.Averaged = true
+		f.Labels = 1
+		f.ValueStart = append(f.ValueStart, int32(len(f.Values)))
+		return f
+	}
+	f.ValueStart = nil
+	for _, v := range f.Leaf {
+		if int(v)+1 > f.Labels {
+			f.Labels = int(v) + 1
+		}
+	}
+	return f
+}
+
+func (f *flatForest) addNode(t *Tree) (index int32) {
+	index = int32(len(f.Feature))
+	f.Feature = append(f.Feature, int32(t.VariableIndex))
+	f.Threshold = append(f.Threshold, t.ValueIndex)
+	f.Left = append(f.Left, 0)
+	f.Right = append(f.Right, 0)
+	f.ObliqueStart = append(f.ObliqueStart, int32(len(f.ObliqueFeature)))
+	f.ObliqueFeature = append(f.ObliqueFeature, t.ObliqueFeatures...)
+	f.ObliqueWeight = append(f.ObliqueWeight, t.ObliqueWeights...)
+
+	var left, right int32
+	if t.LeftNode != nil {
+		left = f.addNode(t.LeftNode)
+	} else {
+		left = f.addLeaf(t.LeftTerminal, t.LeftValues, t.LeftOutputs)
+	}
+	if t.RightNode != nil {
+		right = f.addNode(t.RightNode)
+	} else {
+		right = f.addLeaf(t.RightTerminal, t.RightValues, t.RightOutputs)
+	}
+	f.Left[index] = left
+	f.Right[index] = right
+	return index
+}
+
+func (f *flatForest) addLeaf(value float32, values []float32, outputs []float32) (child int32) {
+	f.Leaf = append(f.Leaf, value)
+	f.LeafOutputs = append(f.LeafOutputs, outputs...)
+	f.ValueStart = append(f.ValueStart, int32(len(f.Values)))
+	f.Values = append(f.Values, values...)
+	return -int32(len(f.Leaf))
+}
+
+// predictTree predicts a single variable index from the tree starting at root
+func (f *flatForest) predictTree(root int32, row datarow) float32 {
+	return f.Leaf[f.leaf(root, row)]
+}
+
+// splitValue is what node i compares with its Threshold for row
+func (f *flatForest) splitValue(i int32, row datarow) float32 {
+	if f.ObliqueStart == nil || f.ObliqueStart[i] == f.ObliqueStart[i+1] {
+		return row[f.Feature[i]]
+	}
+	start, end := f.ObliqueStart[i], f.ObliqueStart[i+1]
+	return project(row, f.ObliqueFeature[start:end], f.ObliqueWeight[start:end])
+}
+
+// leaf is the index in Leaf of the terminal that row reaches in the tree
+// starting at root. Every terminal in the forest has its own index.
+func (f *flatForest) leaf(root int32, row datarow) int32 {
+	i := root
+	for {
+		var next int32
+		if f.splitValue(i, row) < f.Threshold[i] {
+			next = f.Left[i]
+		} else {
+			next = f.Right[i]
+		}
+		if next < 0 {
+			return -next - 1
+		}
+		i = next
+	}
+}
+
+// votes adds up the weights of the trees predicting each variable index
+func (f *flatForest) votes(row datarow) (counts []float32) {
+	counts = make([]float32, f.Labels)
+	for i, root := range f.Roots {
+		counts[int(f.predictTree(root, row))] += f.treeWeight(i)
+	}
+	return counts
+}
+
+// treeWeight is how much the vote of tree i counts
+func (f *flatForest) treeWeight(i int) float32 {
+	if f.Weights == nil {
+		return 1
+	}
+	return f.Weights[i]
+}
+
+// totalWeight is what the votes of every tree add up to
+func (f *flatForest) totalWeight() (total float32) {
+	for i := range f.Roots {
+		total += f.treeWeight(i)
+	}
+	return total
+}
+
+// additiveProba sums the leaf values of each class's trees and turns them into
+// the probability of each of the Labels
+func (f *flatForest) additiveProba(row datarow) (proba []float32) {
+	return f.sumsProba(f.sums(row))
+}
+
+// sums adds up the leaf values of each class's trees, from BaseScore
+func (f *flatForest) sums(row datarow) (sums []float64) {
+	sums = make([]float64, f.Classes)
+	for i := range sums {
+		sums[i] = float64(f.BaseScore)
+	}
+	for i, root := range f.Roots {
+		sums[i%f.Classes] += float64(f.predictTree(root, row))
+	}
+	return sums
+}
+
+// sumsProba turns the sums of each class's leaf values into probabilities
+func (f *flatForest) sumsProba(sums []float64) (proba []float32) {
+	proba = make([]float32, f.Labels)
+	switch f.Output {
+	case outputLogistic:
+		p := 1 / (1 + math.Exp(-sums[0]))
+		proba[0] = float32(1 - p)
+		proba[1] = float32(p)
+	case outputSoftmax:
+		highest := sums[0]
+		for _, s := range sums {
+			highest = math.Max(highest, s)
+		}
+		var total float64
+		for i, s := range sums {
+			sums[i] = math.Exp(s - highest)
+			total += sums[i]
+		}
+		for i, s := range sums {
+			proba[i] = float32(s / total)
+		}
+	default:
+		for i, s := range sums {
+			proba[i] = float32(s)
+		}
+	}
+	return proba
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// randomTree makes a full tree of the given depth with random splits
+func randomTree(r *rand.Rand, depth int, features int, labels int) *Tree {
+	t := &Tree{
+		VariableIndex: float32(r.Intn(features)),
+		ValueIndex:    r.Float32(),
+		LeftTerminal:  float32(r.Intn(labels)),
+		RightTerminal: float32(r.Intn(labels)),
+	}
+	if depth > 1 {
+		t.LeftNode = randomTree(r, depth-1, features, labels)
+		t.RightNode = randomTree(r, depth-1, features, labels)
+	}
+	return t
+}
+
+func randomRows(r *rand.Rand, n int, features int) (rows []datarow) {
+	for i := 0; i < n; i++ {
+		row := make(datarow, features+1)
+		for j := 0; j < features; j++ {
+			row[j] = r.Float32()
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// the forest used by the benchmarks, about the size of a sonar model
+func benchForest() (trees []*Tree, rows []datarow) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		trees = append(trees, randomTree(r, 10, 60, 2))
+	}
+	return trees, randomRows(r, 1000, 60)
+}
+
+// pointerBaggingPredict is how baggingPredict worked before flatForest
+func pointerBaggingPredict(trees []*Tree, row datarow) float32 {
+	var predictions []float32
+	for _, tree := range trees {
+		predictions = append(predictions, tree.predict(row))
+	}
+	return maxCount(predictions)
+}
+
+func TestFlatForestMatchesTrees(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	var trees []*Tree
+	for i := 0; i < 20; i++ {
+		// uneven trees, so terminals show up at every depth
+		tree := randomTree(r, 1+r.Intn(8), 5, 3)
+		if r.Intn(2) == 0 {
+			tree.RightNode = nil
+		}
+		trees = append(trees, tree)
+	}
+	forest := flatten(trees)
+	if len(forest.Roots) != len(trees) {
+		t.Fatal("expected", len(trees), "roots, got", len(forest.Roots))
+	}
+
+	ties := 0
+	for _, row := range randomRows(r, 500, 5) {
+		counts := make([]int, 3)
+		for i, tree := range trees {
+			expected := tree.predict(row)
+			if got := forest.predictTree(forest.Roots[i], row); got != expected {
+				t.Fatal("tree", i, "predicted", got, "expected", expected)
+			}
+			counts[int(expected)]++
+		}
+		proba := baggingProba(forest, row, 3)
+		for label, count := range counts {
+			if proba[label] != float32(count)/float32(len(trees)) {
+				t.Fatal("proba", proba, "for votes", counts)
+			}
+		}
+		// both break ties toward the lowest label
+		got, expected := baggingPredict(forest, row), pointerBaggingPredict(trees, row)
+		if got != expected {
+			t.Fatal("predicted", got, "expected", expected, "with votes", counts)
+		}
+		for label, count := range counts {
+			if label != int(got) && count == counts[int(got)] {
+				ties++
+				break
+			}
+		}
+	}
+	if ties == 0 {
+		t.Fatal("expected some rows to tie")
+	}
+}
+
+func BenchmarkPointerBaggingPredict(b *testing.B) {
+	trees, rows := benchForest()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pointerBaggingPredict(trees, rows[i%len(rows)])
+	}
+}
+
+func BenchmarkFlatBaggingPredict(b *testing.B) {
+	trees, rows := benchForest()
+	forest := flatten(trees)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		baggingPredict(forest, rows[i%len(rows)])
+	}
+}
+
+func BenchmarkPointerTreePredict(b *testing.B) {
+	trees, rows := benchForest()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trees[i%len(trees)].predict(rows[i%len(rows)])
+	}
+}
+
+func BenchmarkFlatTreePredict(b *testing.B) {
+	trees, rows := benchForest()
+	forest := flatten(trees)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		forest.predictTree(forest.Roots[i%len(trees)], rows[i%len(rows)])
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/ruffrey/pine/tree/pinepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcPredictor serves the same models as the HTTP server, over gRPC
+type grpcPredictor struct {
+	pinepb.UnimplementedPredictorServer
+	s *predictionServer
+}
+
+func newGRPCServer(s *predictionServer) *grpc.Server {
+	server := grpc.NewServer()
+	pinepb.RegisterPredictorServer(server, &grpcPredictor{s: s})
+	return server
+}
+
+func (g *grpcPredictor) Predict(ctx context.Context, req *pinepb.PredictRequest) (*pinepb.PredictResponse, error) {
+	m := g.s.current()
+	row, err := m.toRow(req.Features)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	label, _ := m.predict(row)
+	return &pinepb.PredictResponse{Label: label, Id: req.Id}, nil
+}
+
+func (g *grpcPredictor) PredictProba(ctx context.Context, req *pinepb.PredictRequest) (*pinepb.ProbaResponse, error) {
+	res := g.proba(g.s.current(), req)
+	if res.Error != "" {
+		return nil, status.Error(codes.InvalidArgument, res.Error)
+	}
+	return res, nil
+}
+
+func (g *grpcPredictor) PredictStream(stream pinepb.Predictor_PredictStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// each row uses whichever model is current when it arrives
+		err = stream.Send(g.proba(g.s.current(), req))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (g *grpcPredictor) proba(m *servedModel, req *pinepb.PredictRequest) *pinepb.ProbaResponse {
+	row, err := m.toRow(req.Features)
+	if err != nil {
+		return &pinepb.ProbaResponse{Id: req.Id, Error: err.Error()}
+	}
+	label, proba := m.predict(row)
+	return &pinepb.ProbaResponse{Label: label, Probabilities: m.labelMap(proba), Id: req.Id}
+}
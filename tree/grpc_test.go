@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/ruffrey/pine/tree/pinepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func startTestGRPC(t *testing.T, model *saveFormat) pinepb.PredictorClient {
+	path := filepath.Join(t.TempDir(), "model.gob")
+	if err := save(path, model); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newPredictionServer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := newGRPCServer(s)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pinepb.NewPredictorClient(conn)
+}
+
+func TestGRPCPredict(t *testing.T) {
+	client := startTestGRPC(t, testModel())
+	ctx := context.Background()
+
+	res, err := client.Predict(ctx, &pinepb.PredictRequest{Features: []float32{7, 0}, Id: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Label != "big" || res.Id != "a" {
+		t.Fatal("predicted", res)
+	}
+
+	proba, err := client.PredictProba(ctx, &pinepb.PredictRequest{Features: []float32{1, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proba.Label != "small" || proba.Probabilities["small"] != 1 {
+		t.Fatal("probabilities", proba)
+	}
+
+	_, err = client.Predict(ctx, &pinepb.PredictRequest{Features: []float32{1}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatal("wrong number of features got", err)
+	}
+}
+
+func TestGRPCPredictStream(t *testing.T) {
+	client := startTestGRPC(t, testModel())
+	stream, err := client.PredictStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []*pinepb.PredictRequest{
+		{Features: []float32{1, 0}, Id: "1"},
+		{Features: []float32{1}, Id: "bad"},
+		{Features: []float32{9, 0}, Id: "2"},
+	}
+	go (func() {
+		for _, req := range requests {
+			stream.Send(req)
+		}
+		stream.CloseSend()
+	})()
+
+	expected := []struct{ id, label string }{{"1", "small"}, {"bad", ""}, {"2", "big"}}
+	for _, e := range expected {
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Id != e.id || res.Label != e.label {
+			t.Fatal("expected", e, "got", res)
+		}
+		if e.id == "bad" && res.Error == "" {
+			t.Fatal("expected an error for the bad row")
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+-import converts forests trained in Python into a pine model, so -pred, -serve
+and the rest of the tooling work on them.
+
+sklearn is a JSON object holding the tree_ arrays of each estimator:
+
+	{"classes": [...], "n_features": 4, "feature_names": [...],
+	 "estimators": [{"children_left": [...], "children_right": [...],
+	   "feature": [...], "threshold": [...], "value": [...]}]}
+
+A single DecisionTreeClassifier may put its arrays at the top level instead.
+sklearn averages the class probabilities of its trees rather than voting, so
+each estimator becomes one tree per class, whose leaves are that class's
+probability divided by the number of estimators, in an additive forest.
+
+sklearn goes left when the float32 x <= the float64 threshold, where pine goes
+left when x < threshold. Each threshold becomes the smallest float32 above every
+float32 that is <= it, so all rows go the same way as they did in sklearn.
+
+xgboost is the dump_model JSON array of trees, either on its own for
+binary:logistic, or wrapped with what the dump leaves out:
+
+	{"objective": "multi:softprob", "classes": [...], "base_score": 0.5,
+	 "feature_names": [...], "trees": [...]}
+
+XGBoost goes to "yes" when the float32 x < split_condition, the same as pine.
+pine rows have no missing values, so "missing" is ignored.
+*/
+
+// -import formats
+const (
+	importSklearn = "sklearn"
+	importXGBoost = "xgboost"
+)
+
+// sklearnLeaf is the children_left and children_right of a leaf
+const sklearnLeaf = -1
+
+type sklearnTree struct {
+	ChildrenLeft  []int         `json:"children_left"`
+	ChildrenRight []int         `json:"children_right"`
+	Feature       []int         `json:"feature"`
+	Threshold     []float64     `json:"threshold"`
+	Value         [][][]float64 `json:"value"` // node, output, class
+}
+
+type sklearnForest struct {
+	Classes      []json.RawMessage `json:"classes"`
+	NFeatures    int               `json:"n_features"`
+	FeatureNames []string          `json:"feature_names"`
+	Estimators   []sklearnTree     `json:"estimators"`
+	sklearnTree
+}
+
+type xgboostNode struct {
+	NodeID         int            `json:"nodeid"`
+	Split          string         `json:"split"`
+	SplitCondition *float64       `json:"split_condition"`
+	Yes            int            `json:"yes"`
+	No             int            `json:"no"`
+	Leaf           *float64       `json:"leaf"`
+	Children       []*xgboostNode `json:"children"`
+}
+
+type xgboostForest struct {
+	Objective    string            `json:"objective"`
+	Classes      []json.RawMessage `json:"classes"`
+	BaseScore    *float64          `json:"base_score"`
+	NFeatures    int               `json:"n_features"`
+	FeatureNames []string          `json:"feature_names"`
+	Trees        []*xgboostNode    `json:"trees"`
+}
+
+// importModel converts the -data file from the -import format and saves it
+// to -save
+func importModel() {
+	buf, err := ioutil.ReadFile(*dataFile)
+	if err != nil {
+		panic(err)
+	}
+	var model *saveFormat
+	switch *importFormat {
+	case importSklearn:
+		model, err = importSklearnForest(buf)
+	case importXGBoost:
+		model, err = importXGBoostForest(buf)
+	default:
+		err = fmt.Errorf("unknown -import format %q, expected %s or %s", *importFormat, importSklearn, importXGBoost)
+	}
+	if err != nil {
+		panic(err)
+	}
+	model.Meta.Procedure = procedureImported
+	model.Meta.Imported = *importFormat
+	model.Meta.Created = time.Now()
+	model.Meta.DataFile = *dataFile
+	err = save(*saveTo, model)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Imported", len(model.Trees), "trees and", len(model.IndexedVariables), "variables to", *saveTo)
+}
+
+// importLabels reads class labels which may be json strings or numbers
+func importLabels(classes []json.RawMessage) (indexed []string, vars map[string]float32, err error) {
+	vars = make(map[string]float32)
+	for i, raw := range classes {
+		label := string(raw)
+		if strings.HasPrefix(label, `"`) {
+			if err = json.Unmarshal(raw, &label); err != nil {
+				return nil, nil, err
+			}
+		}
+		if _, seen := vars[label]; seen {
+			return nil, nil, fmt.Errorf("class %q is listed twice", label)
+		}
+		vars[label] = float32(i)
+		indexed = append(indexed, label)
+	}
+	return indexed, vars, nil
+}
+
+// importedModel makes an additive model from the converted trees
+func importedModel(trees []*Tree, labels []string, vars map[string]float32, features int, featureNames []string) *saveFormat {
+	model := &saveFormat{
+		Trees:            trees,
+		Flat:             flatten(trees),
+		IndexedVariables: labels,
+		Variables:        vars,
+		Meta: modelMeta{
+			Task:    taskClassification,
+			Columns: features + 1,
+		},
+	}
+	model.Flat.Additive = true
+	model.Flat.Labels = len(labels)
+	if len(featureNames) == features {
+		model.Meta.ColumnNames = append(append([]string{}, featureNames...), "class")
+	}
+	return model
+}
+
+// lessThanOrEqual returns the threshold for which x < threshold exactly when
+// x <= t, for every float32 x
+func lessThanOrEqual(t float64) float32 {
+	f := float32(t)
+	if float64(f) > t {
+		f = math.Nextafter32(f, float32(math.Inf(-1)))
+	}
+	return math.Nextafter32(f, float32(math.Inf(1)))
+}
+
+func importSklearnForest(buf []byte) (model *saveFormat, err error) {
+	var forest sklearnForest
+	if err = json.Unmarshal(buf, &forest); err != nil {
+		return nil, err
+	}
+	estimators := forest.Estimators
+	if len(estimators) == 0 && len(forest.ChildrenLeft) > 0 {
+		estimators = []sklearnTree{forest.sklearnTree}
+	}
+	if len(estimators) == 0 {
+		return nil, fmt.Errorf("no estimators found")
+	}
+	if len(forest.Classes) < 2 {
+		return nil, fmt.Errorf("only classifiers can be imported, and classes should list at least two labels")
+	}
+	labels, vars, err := importLabels(forest.Classes)
+	if err != nil {
+		return nil, err
+	}
+	features := forest.NFeatures
+	if features == 0 {
+		features = len(forest.FeatureNames)
+	}
+
+	var trees []*Tree
+	scale := 1 / float64(len(estimators))
+	for e := range estimators {
+		s := &estimators[e]
+		if err = s.check(len(labels)); err != nil {
+			return nil, fmt.Errorf("estimator %d: %v", e, err)
+		}
+		for _, f := range s.Feature {
+			if f+1 > features {
+				features = f + 1
+			}
+		}
+		for class := range labels {
+			leaf := func(node int) float32 {
+				var total float64
+				for _, v := range s.Value[node][0] {
+					total += v
+				}
+				return float32(s.Value[node][0][class] / total * scale)
+			}
+			trees = append(trees, s.toTree(0, leaf))
+		}
+	}
+
+	model = importedModel(trees, labels, vars, features, forest.FeatureNames)
+	model.Flat.Classes = len(labels)
+	model.Flat.Output = outputSum
+	return model, nil
+}
+
+// check makes sure the arrays agree, and that children always come after
+// their parent so toTree ends
+func (s *sklearnTree) check(classes int) error {
+	n := len(s.ChildrenLeft)
+	if n == 0 || len(s.ChildrenRight) != n || len(s.Feature) != n || len(s.Threshold) != n || len(s.Value) != n {
+		return fmt.Errorf("children_left, children_right, feature, threshold and value should all have one entry per node")
+	}
+	for node := 0; node < n; node++ {
+		left, right := s.ChildrenLeft[node], s.ChildrenRight[node]
+		if left == sklearnLeaf {
+			if len(s.Value[node]) != 1 {
+				return fmt.Errorf("multi-output trees cannot be imported")
+			}
+			var total float64
+			for _, v := range s.Value[node][0] {
+				total += v
+			}
+			if len(s.Value[node][0]) != classes || total <= 0 {
+				return fmt.Errorf("leaf %d should have a positive value for each of the %d classes", node, classes)
+			}
+			continue
+		}
+		if left <= node || right <= node || left >= n || right >= n {
+			return fmt.Errorf("node %d has children out of order", node)
+		}
+		if s.Feature[node] < 0 {
+			return fmt.Errorf("node %d splits on feature %d", node, s.Feature[node])
+		}
+	}
+	return nil
+}
+
+func (s *sklearnTree) toTree(node int, leaf func(node int) float32) *Tree {
+	if s.ChildrenLeft[node] == sklearnLeaf {
+		// a tree that is only a leaf goes the same way for every row
+		value := leaf(node)
+		return &Tree{LeftTerminal: value, RightTerminal: value}
+	}
+	t := &Tree{
+		VariableIndex: float32(s.Feature[node]),
+		ValueIndex:    lessThanOrEqual(s.Threshold[node]),
+	}
+	if left := s.ChildrenLeft[node]; s.ChildrenLeft[left] == sklearnLeaf {
+		t.LeftTerminal = leaf(left)
+	} else {
+		t.LeftNode = s.toTree(left, leaf)
+	}
+	if right := s.ChildrenRight[node]; s.ChildrenLeft[right] == sklearnLeaf {
+		t.RightTerminal = leaf(right)
+	} else {
+		t.RightNode = s.toTree(right, leaf)
+	}
+	return t
+}
+
+func importXGBoostForest(buf []byte) (model *saveFormat, err error) {
+	var forest xgboostForest
+	if bytes.HasPrefix(bytes.TrimSpace(buf), []byte("[")) {
+		err = json.Unmarshal(buf, &forest.Trees)
+	} else {
+		err = json.Unmarshal(buf, &forest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(forest.Trees) == 0 {
+		return nil, fmt.Errorf("no trees found")
+	}
+	if forest.Objective == "" {
+		forest.Objective = "binary:logistic"
+	}
+	if len(forest.Classes) == 0 {
+		forest.Classes = []json.RawMessage{[]byte("0"), []byte("1")}
+	}
+	labels, vars, err := importLabels(forest.Classes)
+	if err != nil {
+		return nil, err
+	}
+	baseScore := 0.5
+	if forest.BaseScore != nil {
+		baseScore = *forest.BaseScore
+	}
+
+	classes := len(labels)
+	output := outputSoftmax
+	switch forest.Objective {
+	case "binary:logistic":
+		if len(labels) != 2 {
+			return nil, fmt.Errorf("binary:logistic should have two classes, not %d", len(labels))
+		}
+		// base_score is a probability, and the trees add to its log odds
+		classes = 1
+		output = outputLogistic
+		baseScore = math.Log(baseScore / (1 - baseScore))
+	case "multi:softprob", "multi:softmax":
+		if len(forest.Trees)%classes != 0 {
+			return nil, fmt.Errorf("%d trees cannot be shared evenly by %d classes", len(forest.Trees), classes)
+		}
+	default:
+		return nil, fmt.Errorf("the %s objective cannot be imported", forest.Objective)
+	}
+
+	featureIndex := make(map[string]int)
+	for i, name := range forest.FeatureNames {
+		featureIndex[name] = i
+	}
+	features := forest.NFeatures
+	if features == 0 {
+		features = len(forest.FeatureNames)
+	}
+	var trees []*Tree
+	for i, root := range forest.Trees {
+		t, err := root.toTree(featureIndex, &features)
+		if err != nil {
+			return nil, fmt.Errorf("tree %d: %v", i, err)
+		}
+		trees = append(trees, t)
+	}
+
+	model = importedModel(trees, labels, vars, features, forest.FeatureNames)
+	model.Flat.Classes = classes
+	model.Flat.Output = output
+	model.Flat.BaseScore = float32(baseScore)
+	return model, nil
+}
+
+// feature finds the column an xgboost split is on, by -feature_names or as
+// f0, f1, ... and raises features to include it
+func (n *xgboostNode) feature(featureIndex map[string]int, features *int) (int, error) {
+	f, ok := featureIndex[n.Split]
+	if !ok {
+		if !strings.HasPrefix(n.Split, "f") {
+			return 0, fmt.Errorf("unknown feature %q", n.Split)
+		}
+		var err error
+		f, err = strconv.Atoi(n.Split[1:])
+		if err != nil || f < 0 {
+			return 0, fmt.Errorf("unknown feature %q", n.Split)
+		}
+	}
+	if f+1 > *features {
+		*features = f + 1
+	}
+	return f, nil
+}
+
+func (n *xgboostNode) toTree(featureIndex map[string]int, features *int) (*Tree, error) {
+	if n.Leaf != nil {
+		value := float32(*n.Leaf)
+		return &Tree{LeftTerminal: value, RightTerminal: value}, nil
+	}
+	if n.SplitCondition == nil {
+		return nil, fmt.Errorf("node %d has no split_condition; only numeric splits can be imported", n.NodeID)
+	}
+	f, err := n.feature(featureIndex, features)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree{VariableIndex: float32(f), ValueIndex: float32(*n.SplitCondition)}
+	var yes, no *xgboostNode
+	for _, child := range n.Children {
+		switch child.NodeID {
+		case n.Yes:
+			yes = child
+		case n.No:
+			no = child
+		}
+	}
+	if yes == nil || no == nil {
+		return nil, fmt.Errorf("node %d is missing its yes or no child", n.NodeID)
+	}
+	if yes.Leaf != nil {
+		t.LeftTerminal = float32(*yes.Leaf)
+	} else if t.LeftNode, err = yes.toTree(featureIndex, features); err != nil {
+		return nil, err
+	}
+	if no.Leaf != nil {
+		t.RightTerminal = float32(*no.Leaf)
+	} else if t.RightNode, err = no.toTree(featureIndex, features); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestLessThanOrEqual(t *testing.T) {
+	for _, threshold := range []float64{2.45, 0.5, -1.75, 0, 1e-30, float64(float32(0.1)), 3.4e38} {
+		f := float32(threshold)
+		var candidates []float32
+		for _, x := range []float32{f, math.Nextafter32(f, -1e38), math.Nextafter32(f, 1e38)} {
+			candidates = append(candidates, x, math.Nextafter32(x, -1e38), math.Nextafter32(x, 1e38))
+		}
+		converted := lessThanOrEqual(threshold)
+		for _, x := range candidates {
+			if (float64(x) <= threshold) != (x < converted) {
+				t.Fatal("threshold", threshold, "x", x, "goes a different way with", converted)
+			}
+		}
+	}
+}
+
+// sklearnTestForest has thresholds which are not float32s, and rows are
+// taken right around them
+const sklearnTestForest = `{
+	"classes": ["setosa", "versicolor", "virginica"],
+	"n_features": 2,
+	"feature_names": ["petal length", "petal width"],
+	"estimators": [
+		{
+			"children_left": [1, -1, 3, -1, -1],
+			"children_right": [2, -1, 4, -1, -1],
+			"feature": [0, -2, 1, -2, -2],
+			"threshold": [2.45, -2, 1.75, -2, -2],
+			"value": [[[50, 50, 50]], [[50, 0, 0]], [[0, 50, 50]], [[0, 49, 5]], [[0, 1, 45]]]
+		},
+		{
+			"children_left": [1, -1, -1],
+			"children_right": [2, -1, -1],
+			"feature": [1, -2, -2],
+			"threshold": [0.8000000119, -2, -2],
+			"value": [[[0.3, 0.4, 0.3]], [[1, 0, 0]], [[0, 0.5, 0.5]]]
+		},
+		{
+			"children_left": [-1],
+			"children_right": [-1],
+			"feature": [-2],
+			"threshold": [-2],
+			"value": [[[1, 2, 1]]]
+		}
+	]
+}`
+
+// sklearnProba averages the leaf probabilities the way sklearn does
+func sklearnProba(forest *sklearnForest, row datarow) []float64 {
+	proba := make([]float64, len(forest.Classes))
+	for _, e := range forest.Estimators {
+		node := 0
+		for e.ChildrenLeft[node] != sklearnLeaf {
+			if float64(row[e.Feature[node]]) <= e.Threshold[node] {
+				node = e.ChildrenLeft[node]
+			} else {
+				node = e.ChildrenRight[node]
+			}
+		}
+		var total float64
+		for _, v := range e.Value[node][0] {
+			total += v
+		}
+		for i, v := range e.Value[node][0] {
+			proba[i] += v / total / float64(len(forest.Estimators))
+		}
+	}
+	return proba
+}
+
+// aroundThresholds makes rows at and next to every threshold, plus random ones
+func aroundThresholds(thresholds [][]float64, n int) (rows []datarow) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		row := make(datarow, len(thresholds)+1)
+		for f, values := range thresholds {
+			v := float32(values[r.Intn(len(values))])
+			switch r.Intn(4) {
+			case 0:
+				v = math.Nextafter32(v, -1e38)
+			case 1:
+				v = math.Nextafter32(v, 1e38)
+			case 2:
+				v = r.Float32() * 4
+			}
+			row[f] = v
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestImportSklearn(t *testing.T) {
+	model, err := importSklearnForest([]byte(sklearnTestForest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var forest sklearnForest
+	if err := json.Unmarshal([]byte(sklearnTestForest), &forest); err != nil {
+		t.Fatal(err)
+	}
+	if len(model.Trees) != 9 || model.Meta.Columns != 3 || len(model.Meta.ColumnNames) != 3 {
+		t.Fatal(len(model.Trees), "trees,", model.Meta.Columns, "columns", model.Meta.ColumnNames)
+	}
+	if model.Variables["virginica"] != 2 {
+		t.Fatal("variables", model.Variables)
+	}
+
+	rows := aroundThresholds([][]float64{{2.45}, {1.75, 0.8000000119}}, 500)
+	for _, row := range rows {
+		expected := sklearnProba(&forest, row)
+		proba := baggingProba(model.Flat, row, len(model.IndexedVariables))
+		best := 0
+		for i := range expected {
+			if math.Abs(expected[i]-float64(proba[i])) > 1e-6 {
+				t.Fatal("row", row, "proba", proba, "expected", expected)
+			}
+			if expected[i] > expected[best] {
+				best = i
+			}
+		}
+		if int(baggingPredict(model.Flat, row)) != best {
+			t.Fatal("row", row, "predicted", baggingPredict(model.Flat, row), "expected", best)
+		}
+	}
+}
+
+const xgboostTestForest = `{
+	"objective": "multi:softprob",
+	"classes": [0, 1, 2],
+	"base_score": 0.5,
+	"trees": [
+		{"nodeid": 0, "depth": 0, "split": "f2", "split_condition": 2.45000005, "yes": 1, "no": 2, "missing": 1, "children": [
+			{"nodeid": 1, "leaf": 0.430622011},
+			{"nodeid": 2, "leaf": -0.220048919}
+		]},
+		{"nodeid": 0, "depth": 0, "split": "f2", "split_condition": 2.45000005, "yes": 1, "no": 2, "missing": 1, "children": [
+			{"nodeid": 1, "leaf": -0.215311036},
+			{"nodeid": 2, "depth": 1, "split": "f3", "split_condition": 1.75, "yes": 3, "no": 4, "missing": 3, "children": [
+				{"nodeid": 3, "leaf": 0.389552265},
+				{"nodeid": 4, "leaf": -0.199395284}
+			]}
+		]},
+		{"nodeid": 0, "depth": 0, "split": "f3", "split_condition": 1.75, "yes": 1, "no": 2, "missing": 1, "children": [
+			{"nodeid": 1, "leaf": -0.21078372},
+			{"nodeid": 2, "leaf": 0.40666667}
+		]},
+		{"nodeid": 0, "leaf": 0.01},
+		{"nodeid": 0, "depth": 0, "split": "f0", "split_condition": 5.5, "yes": 1, "no": 2, "missing": 1, "children": [
+			{"nodeid": 1, "leaf": 0.05},
+			{"nodeid": 2, "leaf": -0.05}
+		]},
+		{"nodeid": 0, "leaf": 0.02}
+	]
+}`
+
+// xgboostMargin walks a dumped tree the way xgboost does
+func xgboostMargin(n *xgboostNode, row datarow) float64 {
+	for n.Leaf == nil {
+		f, _ := n.feature(nil, new(int))
+		next := n.No
+		if row[f] < float32(*n.SplitCondition) {
+			next = n.Yes
+		}
+		for _, child := range n.Children {
+			if child.NodeID == next {
+				n = child
+			}
+		}
+	}
+	return *n.Leaf
+}
+
+func TestImportXGBoost(t *testing.T) {
+	model, err := importXGBoostForest([]byte(xgboostTestForest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var forest xgboostForest
+	if err := json.Unmarshal([]byte(xgboostTestForest), &forest); err != nil {
+		t.Fatal(err)
+	}
+	if model.Meta.Columns != 5 || model.IndexedVariables[2] != "2" {
+		t.Fatal(model.Meta.Columns, "columns, labels", model.IndexedVariables)
+	}
+
+	for _, row := range aroundThresholds([][]float64{{5.5}, {0}, {2.45000005}, {1.75}}, 500) {
+		margins := make([]float64, 3)
+		for i, tree := range forest.Trees {
+			margins[i%3] += xgboostMargin(tree, row)
+		}
+		var total float64
+		best := 0
+		for i, m := range margins {
+			total += math.Exp(m)
+			if m > margins[best] {
+				best = i
+			}
+		}
+		proba := baggingProba(model.Flat, row, 3)
+		for i, m := range margins {
+			if math.Abs(math.Exp(m)/total-float64(proba[i])) > 1e-6 {
+				t.Fatal("row", row, "proba", proba)
+			}
+		}
+		if int(baggingPredict(model.Flat, row)) != best {
+			t.Fatal("row", row, "predicted", baggingPredict(model.Flat, row), "expected", best)
+		}
+	}
+}
+
+func TestImportXGBoostBinary(t *testing.T) {
+	dump := `[{"nodeid": 0, "split": "f1", "split_condition": 3, "yes": 1, "no": 2, "children": [
+		{"nodeid": 1, "leaf": -0.4},
+		{"nodeid": 2, "leaf": 0.6}
+	]}]`
+	model, err := importXGBoostForest([]byte(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proba := baggingProba(model.Flat, datarow{0, 3, 0}, 2)
+	expected := 1 / (1 + math.Exp(-0.6))
+	if math.Abs(float64(proba[1])-expected) > 1e-6 || math.Abs(float64(proba[0]+proba[1])-1) > 1e-6 {
+		t.Fatal("proba", proba, "expected", expected)
+	}
+	if baggingPredict(model.Flat, datarow{0, 2.9, 0}) != 0 {
+		t.Fatal("expected class 0 below the split")
+	}
+}
+
+func TestImportRejectsAdditiveExports(t *testing.T) {
+	model, err := importSklearnForest([]byte(sklearnTestForest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toPMML(model); err == nil {
+		t.Fatal("expected an error exporting PMML")
+	}
+	if _, err := toONNX(model); err == nil {
+		t.Fatal("expected an error exporting ONNX")
+	}
+	if _, err := generateCHeader(model, "m"); err == nil {
+		t.Fatal("expected an error exporting C")
+	}
+	if _, err := generateGo(model, "m", false); err == nil {
+		t.Fatal("expected an error generating Go")
+	}
+}
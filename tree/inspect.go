@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// -inspect and -stats formats
+const (
+	inspectText = "text"
+	inspectDot  = "dot"
+	inspectJSON = "json"
+)
+
+// treeRenderer draws trees with the model's column names and labels
+type treeRenderer struct {
+	model    *saveFormat
+	maxDepth int // splits deeper than this are summarized, 0 for no limit
+}
+
+// inspect renders trees from the -model as text or Graphviz DOT, to -save or
+// stdout
+func inspect() {
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+
+	var indexes []int
+	if *inspectTree >= 0 {
+		if *inspectTree >= len(loaded.Trees) {
+			fmt.Println("-tree", *inspectTree, "is out of range; the model has", len(loaded.Trees), "trees")
+			return
+		}
+		indexes = []int{*inspectTree}
+	} else {
+		for i := 0; i < *inspectTrees && i < len(loaded.Trees); i++ {
+			indexes = append(indexes, i)
+		}
+	}
+
+	r := &treeRenderer{model: &loaded, maxDepth: *inspectDepth}
+	var out []byte
+	switch *inspectFormat {
+	case inspectText:
+		out = r.text(indexes)
+	case inspectDot:
+		out = r.dot(indexes)
+	default:
+		fmt.Println("-format should be", inspectText, "or", inspectDot)
+		return
+	}
+	if *saveTo == "" {
+		os.Stdout.Write(out)
+		return
+	}
+	err = ioutil.WriteFile(*saveTo, out, 0644)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Wrote", len(indexes), "trees to", *saveTo)
+}
+
+func (r *treeRenderer) feature(t *Tree) string {
+	i := int(t.VariableIndex)
+	if i < len(r.model.Meta.ColumnNames)-1 {
+		return strings.TrimSpace(r.model.Meta.ColumnNames[i])
+	}
+	return "x" + strconv.Itoa(i)
+}
+
+// condition is the node's split, like "sl < 5.4", or for an oblique node like
+// "0.5*sl - 1.2*pw < 3"
+func (r *treeRenderer) condition(t *Tree) string {
+	threshold := " < " + strconv.FormatFloat(float64(t.ValueIndex), 'g', -1, 32)
+	if len(t.ObliqueFeatures) == 0 {
+		return r.feature(t) + threshold
+	}
+	var b strings.Builder
+	for i, f := range t.ObliqueFeatures {
+		w := t.ObliqueWeights[i]
+		if i > 0 && w < 0 {
+			b.WriteString(" - ")
+			w = -w
+		} else if i > 0 {
+			b.WriteString(" + ")
+		}
+		b.WriteString(strconv.FormatFloat(float64(w), 'g', 4, 32) + "*" + r.feature(&Tree{VariableIndex: float32(f)}))
+	}
+	return b.String() + threshold
+}
+
+// leaf is the label a terminal predicts, or its value when it is not a label.
+// A multi-output terminal has what it predicts for each of the -targets.
+func (r *treeRenderer) leaf(value float32, outputs []float32, count int) string {
+	var s string
+	if len(outputs) > 0 && len(outputs) == len(r.model.Targets) {
+		var predicted []string
+		for j, v := range outputs {
+			predicted = append(predicted, r.model.Targets[j].Name+"="+r.model.Targets[j].label(v))
+		}
+		s = strings.Join(predicted, ", ")
+	} else if r.model.Flat.Additive || r.model.Meta.Task == taskRegression || int(value) >= len(r.model.IndexedVariables) {
+		s = strconv.FormatFloat(float64(value), 'g', -1, 32)
+	} else {
+		s = r.model.IndexedVariables[int(value)]
+	}
+	return s + rowCount(count)
+}
+
+// rowCount describes how many training rows reached a node, when it is known
+func rowCount(count int) string {
+	if count == 0 {
+		return ""
+	}
+	if count == 1 {
+		return " (1 row)"
+	}
+	return fmt.Sprintf(" (%d rows)", count)
+}
+
+// countSplits is how many split nodes are in t
+func countSplits(t *Tree) int {
+	n := 1
+	if t.LeftNode != nil {
+		n += countSplits(t.LeftNode)
+	}
+	if t.RightNode != nil {
+		n += countSplits(t.RightNode)
+	}
+	return n
+}
+
+// cutoff summarizes a subtree which is past maxDepth
+func cutoff(t *Tree, count int) string {
+	n := countSplits(t)
+	if n == 1 {
+		return "... 1 more split" + rowCount(count)
+	}
+	return fmt.Sprintf("... %d more splits%s", n, rowCount(count))
+}
+
+// title names tree i, with how much its vote counts when the trees are weighted
+func (r *treeRenderer) title(i int) string {
+	if r.model.Flat.Weights != nil {
+		return fmt.Sprintf("tree %d (weight %.4g)", i, r.model.Flat.Weights[i])
+	}
+	return "tree " + strconv.Itoa(i)
+}
+
+// text renders each tree as indented lines, with yes for the rows going left
+func (r *treeRenderer) text(indexes []int) []byte {
+	var b bytes.Buffer
+	for n, i := range indexes {
+		if n > 0 {
+			b.WriteString("\n")
+		}
+		t := r.model.Trees[i]
+		fmt.Fprintf(&b, "%s\n", r.title(i))
+		fmt.Fprintf(&b, "%s%s\n", r.condition(t), rowCount(t.LeftCount+t.RightCount))
+		r.textChildren(&b, t, 1)
+	}
+	return b.Bytes()
+}
+
+func (r *treeRenderer) textChildren(b *bytes.Buffer, t *Tree, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, side := range []struct {
+		name     string
+		child    *Tree
+		terminal float32
+		outputs  []float32
+		count    int
+	}{{"yes", t.LeftNode, t.LeftTerminal, t.LeftOutputs, t.LeftCount}, {"no", t.RightNode, t.RightTerminal, t.RightOutputs, t.RightCount}} {
+		switch {
+		case side.child == nil:
+			fmt.Fprintf(b, "%s%s: %s\n", indent, side.name, r.leaf(side.terminal, side.outputs, side.count))
+		case r.maxDepth > 0 && depth >= r.maxDepth:
+			fmt.Fprintf(b, "%s%s: %s\n", indent, side.name, cutoff(side.child, side.count))
+		default:
+			fmt.Fprintf(b, "%s%s: %s%s\n", indent, side.name, r.condition(side.child), rowCount(side.count))
+			r.textChildren(b, side.child, depth+1)
+		}
+	}
+}
+
+// dot renders the trees as one Graphviz digraph, with a cluster per tree
+func (r *treeRenderer) dot(indexes []int) []byte {
+	var b bytes.Buffer
+	b.WriteString("digraph forest {\n\tnode [shape=box];\n")
+	for _, i := range indexes {
+		t := r.model.Trees[i]
+		fmt.Fprintf(&b, "\tsubgraph cluster_%d {\n\t\tlabel=%s;\n", i, dotString(r.title(i)))
+		next := 0
+		root := r.dotNode(&b, i, &next, r.condition(t)+rowCount(t.LeftCount+t.RightCount), false)
+		r.dotChildren(&b, i, &next, root, t, 1)
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// dotNode writes a node and returns its id
+func (r *treeRenderer) dotNode(b *bytes.Buffer, tree int, next *int, label string, leaf bool) string {
+	id := fmt.Sprintf("t%dn%d", tree, *next)
+	*next++
+	shape := ""
+	if leaf {
+		shape = ", shape=ellipse"
+	}
+	fmt.Fprintf(b, "\t\t%s [label=%s%s];\n", id, dotString(label), shape)
+	return id
+}
+
+func (r *treeRenderer) dotChildren(b *bytes.Buffer, tree int, next *int, parent string, t *Tree, depth int) {
+	for _, side := range []struct {
+		name     string
+		child    *Tree
+		terminal float32
+		outputs  []float32
+		count    int
+	}{{"yes", t.LeftNode, t.LeftTerminal, t.LeftOutputs, t.LeftCount}, {"no", t.RightNode, t.RightTerminal, t.RightOutputs, t.RightCount}} {
+		var id string
+		switch {
+		case side.child == nil:
+			id = r.dotNode(b, tree, next, r.leaf(side.terminal, side.outputs, side.count), true)
+		case r.maxDepth > 0 && depth >= r.maxDepth:
+			id = r.dotNode(b, tree, next, cutoff(side.child, side.count), true)
+		default:
+			id = r.dotNode(b, tree, next, r.condition(side.child)+rowCount(side.count), false)
+			r.dotChildren(b, tree, next, id, side.child, depth+1)
+		}
+		fmt.Fprintf(b, "\t\t%s -> %s [label=%s];\n", parent, id, side.name)
+	}
+}
+
+// dotString quotes s as a DOT string
+func dotString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
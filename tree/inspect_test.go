@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func inspectTestModel() *saveFormat {
+	tree := &Tree{
+		VariableIndex: 1, ValueIndex: 2.5, LeftCount: 30, RightCount: 20,
+		LeftTerminal: 0,
+		RightNode: &Tree{
+			VariableIndex: 0, ValueIndex: 1.25, LeftCount: 15, RightCount: 5,
+			LeftTerminal: 1,
+			RightNode: &Tree{
+				VariableIndex: 2, ValueIndex: -3, LeftCount: 4, RightCount: 1,
+				LeftTerminal: 0, RightTerminal: 1,
+			},
+		},
+	}
+	return &saveFormat{
+		Trees:            []*Tree{tree},
+		Flat:             flatten([]*Tree{tree}),
+		IndexedVariables: []string{"small", "big"},
+		Meta:             modelMeta{Columns: 4, ColumnNames: []string{"width", "height", "depth", "size"}},
+	}
+}
+
+func TestInspectText(t *testing.T) {
+	r := &treeRenderer{model: inspectTestModel()}
+	expected := `tree 0
+height < 2.5 (50 rows)
+  yes: small (30 rows)
+  no: width < 1.25 (20 rows)
+    yes: big (15 rows)
+    no: depth < -3 (5 rows)
+      yes: small (4 rows)
+      no: big (1 row)
+`
+	if got := string(r.text([]int{0})); got != expected {
+		t.Fatalf("got\n%s\nexpected\n%s", got, expected)
+	}
+
+	r.maxDepth = 2
+	if got := string(r.text([]int{0})); !strings.Contains(got, "    no: ... 1 more split (5 rows)\n") {
+		t.Fatal("expected the deepest split to be cut off, got\n" + got)
+	}
+}
+
+func TestInspectDot(t *testing.T) {
+	model := inspectTestModel()
+	model.IndexedVariables[0] = `"small"`
+	r := &treeRenderer{model: model}
+	dot := string(r.dot([]int{0}))
+	for _, want := range []string{
+		"digraph forest {",
+		"subgraph cluster_0 {",
+		`t0n0 [label="height < 2.5 (50 rows)"];`,
+		`t0n1 [label="\"small\" (30 rows)", shape=ellipse];`,
+		"t0n0 -> t0n1 [label=yes];",
+		"t0n0 -> t0n2 [label=no];",
+	} {
+		if !strings.Contains(dot, want) {
+			t.Fatalf("expected %s in\n%s", want, dot)
+		}
+	}
+	if strings.Count(dot, "->") != 6 || strings.Count(dot, "{") != strings.Count(dot, "}") {
+		t.Fatal("expected 7 nodes with 6 edges, got\n" + dot)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+const eulerGamma = 0.5772156649015329
+
+/*
+averagePathLength is c(n) from Liu et al., "Isolation Forest": the average
+depth an unsuccessful search reaches in a binary search tree of n rows. It
+estimates how much deeper a leaf holding n rows would have gone, and scales
+the path lengths into scores.
+*/
+func averagePathLength(n int) float64 {
+	switch {
+	case n <= 1:
+		return 0
+	case n == 2:
+		return 1
+	}
+	return 2*(math.Log(float64(n-1))+eulerGamma) - 2*float64(n-1)/float64(n)
+}
+
+/*
+isolationTree splits rows on a random feature at a random threshold between
+its smallest and largest values, until each row is alone, its rows are all
+the same, or the tree is limit deep. Each terminal is the path length of the
+rows reaching it: its depth plus averagePathLength of how many there are.
+*/
+func isolationTree(rows []datarow, features int, limit int) *Tree {
+	t, _ := isolationNode(rows, features, 0, limit)
+	if t == nil {
+		// nothing to split the rows on, so every row gets the same length
+		length := float32(averagePathLength(len(rows)))
+		t = &Tree{LeftTerminal: length, RightTerminal: length, RightCount: len(rows)}
+	}
+	return t
+}
+
+// isolationNode splits the rows at depth, or returns the terminal they end at
+func isolationNode(rows []datarow, features int, depth int, limit int) (t *Tree, terminal float32) {
+	terminal = float32(depth) + float32(averagePathLength(len(rows)))
+	if depth >= limit || len(rows) <= 1 {
+		return nil, terminal
+	}
+	for _, f := range rand.Perm(features) {
+		low, high := rows[0][f], rows[0][f]
+		for _, row := range rows {
+			low = float32(math.Min(float64(low), float64(row[f])))
+			high = float32(math.Max(float64(high), float64(row[f])))
+		}
+		if low == high {
+			continue
+		}
+		// above the smallest value and at most the largest, so both sides
+		// get rows
+		threshold := low + float32(1-rand.Float64())*(high-low)
+		if threshold <= low || threshold > high {
+			threshold = high
+		}
+		var left, right []datarow
+		for _, row := range rows {
+			if row[f] < threshold {
+				left = append(left, row)
+			} else {
+				right = append(right, row)
+			}
+		}
+		t = &Tree{VariableIndex: float32(f), ValueIndex: threshold, LeftCount: len(left), RightCount: len(right)}
+		t.LeftNode, t.LeftTerminal = isolationNode(left, features, depth+1, limit)
+		t.RightNode, t.RightTerminal = isolationNode(right, features, depth+1, limit)
+		return t, 0
+	}
+	return nil, terminal
+}
+
+// isolationForest grows each tree from a different random sample of rows,
+// without replacement
+func isolationForest(rows []datarow, features int, trees int, samples int) []*Tree {
+	if samples > len(rows) {
+		samples = len(rows)
+	}
+	limit := int(math.Ceil(math.Log2(float64(samples))))
+	forest := make([]*Tree, trees)
+	for i := range forest {
+		sample := make([]datarow, samples)
+		for j, k := range rand.Perm(len(rows))[:samples] {
+			sample[j] = rows[k]
+		}
+		forest[i] = isolationTree(sample, features, limit)
+	}
+	return forest
+}
+
+// anomalyScore is 2^(-mean path length / c(samples)), near 1 for rows that
+// are isolated quickly, and 0.5 or less for ordinary ones
+func anomalyScore(forest *flatForest, samples int, row datarow) float64 {
+	var total float64
+	for _, root := range forest.Roots {
+		total += float64(forest.predictTree(root, row))
+	}
+	mean := total / float64(len(forest.Roots))
+	return math.Pow(2, -mean/averagePathLength(samples))
+}
+
+// rocAUC is the chance that a random anomalous row scores higher than a
+// random normal one, counting ties as half
+func rocAUC(scores []float64, anomalous []bool) float64 {
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return scores[order[a]] < scores[order[b]] })
+	// sum the ranks of the anomalous rows, with ties sharing their mean rank
+	var rankSum float64
+	var positives int
+	for start := 0; start < len(order); {
+		end := start
+		for end < len(order) && scores[order[end]] == scores[order[start]] {
+			end++
+		}
+		rank := float64(start+end+1) / 2
+		for _, i := range order[start:end] {
+			if anomalous[i] {
+				rankSum += rank
+				positives++
+			}
+		}
+		start = end
+	}
+	negatives := len(scores) - positives
+	if positives == 0 || negatives == 0 {
+		return math.NaN()
+	}
+	return (rankSum - float64(positives*(positives+1))/2) / float64(positives*negatives)
+}
+
+/*
+trainIsolation grows an isolation forest from the -data, where every column is
+a feature. With -anomaly, the last column is a label instead, rows with that
+label are the anomalies, and the AUC of their scores is printed. The label is
+only used for that; the trees never see it.
+*/
+func trainIsolation() {
+	rand.Seed(time.Now().Unix())
+	fmt.Println("Reading data file", *dataFile)
+	buf, err := ioutil.ReadFile(*dataFile)
+	if err != nil {
+		panic(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	expected := len(strings.Split(lines[0], ","))
+	if *hasHeader {
+		columnNames = strings.Split(lines[0], ",")
+		if *anomalyLabel == "" {
+			// names line up with Columns, which counts the last column too
+			columnNames = append(columnNames, "anomaly")
+		}
+		lines = lines[1:]
+	}
+	features := expected
+	if *anomalyLabel != "" {
+		features--
+	}
+	setColumnGlobals(features + 1)
+
+	// the last column of each row is 1 for anomalies, so the rows look like
+	// any other training data
+	var rows []datarow
+	var anomalous []bool
+	for i, line := range lines {
+		cols := strings.Split(strings.TrimSpace(line), ",")
+		if len(cols) != expected {
+			fmt.Println("line", i+1, "has", len(cols), "columns, expected", expected)
+			return
+		}
+		row, err := parseFeatures(cols)
+		if err != nil {
+			fmt.Println("line", i+1, err)
+			return
+		}
+		isAnomaly := *anomalyLabel != "" && strings.TrimSpace(cols[lastColumnIndex]) == *anomalyLabel
+		if isAnomaly {
+			row[lastColumnIndex] = 1
+		}
+		rows = append(rows, row)
+		anomalous = append(anomalous, isAnomaly)
+	}
+	samples := *isolationSamples
+	if samples > len(rows) {
+		samples = len(rows)
+	}
+	fmt.Println("features:", features)
+	fmt.Println("trees:", *treesPerFold)
+	fmt.Println("rows per tree:", samples)
+	fmt.Println("training cases:", len(rows))
+	trees := isolationForest(rows, features, *treesPerFold, samples)
+
+	meta := modelMeta{
+		Procedure:        procedureFinal,
+		Task:             taskAnomaly,
+		Algorithm:        algoIsolation,
+		Created:          time.Now(),
+		DataFile:         *dataFile,
+		Columns:          columnsPerRow,
+		ColumnNames:      columnNames,
+		FinalTrees:       len(trees),
+		MaxDepth:         int(math.Ceil(math.Log2(float64(samples)))),
+		IsolationSamples: samples,
+		Anomaly:          *anomalyLabel,
+	}
+	s := &saveFormat{Trees: trees, Flat: flatten(trees), Meta: meta}
+
+	if *anomalyLabel != "" {
+		scores := make([]float64, len(rows))
+		for i, row := range rows {
+			scores[i] = anomalyScore(s.Flat, samples, row)
+		}
+		auc := rocAUC(scores, anomalous)
+		s.Meta.AUC = float32(auc)
+		count := 0
+		for _, a := range anomalous {
+			if a {
+				count++
+			}
+		}
+		fmt.Println("\nAnomalies:", count, "rows labeled", *anomalyLabel)
+		fmt.Println("  AUC:", auc)
+	}
+
+	err = save(*saveTo, s)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("\nSaved", len(trees), "isolation trees to", *saveTo)
+}
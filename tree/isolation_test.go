@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestAveragePathLength(t *testing.T) {
+	for n, expected := range map[int]float64{0: 0, 1: 0, 2: 1, 256: 10.2448} {
+		if c := averagePathLength(n); math.Abs(c-expected) > 1e-4 {
+			t.Fatal("c of", n, "is", c, "expected", expected)
+		}
+	}
+}
+
+func TestRocAUC(t *testing.T) {
+	if auc := rocAUC([]float64{0.1, 0.9, 0.4, 0.8}, []bool{false, true, false, true}); auc != 1 {
+		t.Fatal("perfectly separated", auc)
+	}
+	if auc := rocAUC([]float64{0.9, 0.1, 0.8, 0.4}, []bool{false, true, false, true}); auc != 0 {
+		t.Fatal("backwards", auc)
+	}
+	if auc := rocAUC([]float64{0.5, 0.5, 0.5, 0.2}, []bool{true, false, false, false}); math.Abs(auc-2.0/3) > 1e-9 {
+		t.Fatal("ties count half", auc)
+	}
+	if auc := rocAUC([]float64{0.5}, []bool{true}); !math.IsNaN(auc) {
+		t.Fatal("expected no AUC without both kinds of rows", auc)
+	}
+}
+
+// the iris setosa flowers are easy to tell apart, so a few of them among the
+// others should be found without any labels
+func TestIsolationForestFindsMinorityClass(t *testing.T) {
+	buf, err := ioutil.ReadFile("../test-data/iris.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setColumnGlobals(5)
+	var rows []datarow
+	var anomalous []bool
+	setosa := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+		cols := strings.Split(line, ",")
+		if cols[4] == "setosa" {
+			if setosa == 5 {
+				continue
+			}
+			setosa++
+		}
+		row, err := parseFeatures(cols)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+		anomalous = append(anomalous, cols[4] == "setosa")
+	}
+
+	rand.Seed(1)
+	trees := isolationForest(rows, 4, 100, 64)
+	limit := float32(6 + averagePathLength(64))
+	for _, tree := range trees {
+		for _, leaf := range flatten([]*Tree{tree}).Leaf {
+			if leaf <= 0 || leaf > limit {
+				t.Fatal("path length", leaf, "is past the height limit")
+			}
+		}
+	}
+	forest := flatten(trees)
+	scores := make([]float64, len(rows))
+	for i, row := range rows {
+		scores[i] = anomalyScore(forest, 64, row)
+	}
+	if auc := rocAUC(scores, anomalous); auc < 0.95 {
+		t.Fatal("AUC", auc)
+	}
+}
+
+func TestIsolationTreeOfIdenticalRows(t *testing.T) {
+	rows := []datarow{{1, 2, 0}, {1, 2, 0}, {1, 2, 0}}
+	tree := isolationTree(rows, 2, 8)
+	if tree.predict(datarow{1, 2, 0}) != float32(averagePathLength(3)) || tree.predict(datarow{9, 9, 0}) != tree.predict(datarow{1, 2, 0}) {
+		t.Fatal("expected every row to get the length of three rows", tree)
+	}
+}
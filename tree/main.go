@@ -1,18 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"go/token"
 	"io/ioutil"
 	"math"
 	"math/rand"
 	"strings"
 	"time"
 
-	"encoding/json"
-
-	"os"
-
 	"path/filepath"
 
 	"runtime"
@@ -31,6 +29,7 @@ to be able to store the last column as an index to the variable string it repres
 */
 
 var trainingData string
+var columnNames []string         // from the -header row, if any
 var indexedVariables []string    // index to character
 var variables map[string]float32 // character to index
 // first len-1 are considered predictors, last one is the letter index to be predicted
@@ -38,10 +37,20 @@ var trainingCases []datarow
 
 // maxDepth is the maximum depth of child nodes allowed from the root of a tree
 var maxDepth = 10
+
+// leafRows is how few training rows a node can have before it is a terminal
+var leafRows = 1
+
+// regressionTrees makes getSplit and split grow trees predicting a number
+var regressionTrees bool
+
+// outputs is how many predicted columns end each row, more than one with
+// -targets
+var outputs = 1
 var n_features int    // Little `m`, will get rounded down
 var columnsPerRow int // how many total columns in a row. must be the same.
 // how many inputs are fed into the network during a sample; similar to sequence length with neural networks
-var lastColumnIndex int // columnsPerRow minus 1
+var lastColumnIndex int // the first predicted column, so also how many features there are
 var sequenceLength int  // for character mode
 // how many trees to build at once (per fold)
 var parallelTrees int = 1
@@ -62,6 +71,52 @@ var prof *string
 var overrideFeatureSplitSize *int // override n_features
 var overrideSequenceLength *int   // override sequenceLength
 var maxPrint *int
+var finalForest *bool // train a final forest on all data after cross-validation
+var keepCVTrees *bool // keep the cross-validation trees alongside the final forest
+var treeDepth *int    // sets maxDepth
+var leafSize *int     // sets leafRows
+var autoTrees *bool   // grow each fold's forest until the holdout accuracy levels off
+var autoTreesMax *int
+var autoTreesWindow *int
+var autoTreesTolerance *float64
+var accuracyCurveFile *string
+var hasHeader *bool // first row of -data or -input is column names
+var batchInput *string
+var streamRows *bool // -pred from stdin to stdout
+var explainPredictions *bool
+var batchOutput *string
+var idColumns *string // input columns to pass through during batch prediction
+var codegenPkg *string
+var codegenTable *bool // generate static arrays rather than if statements
+var serveAddr *string
+var grpcAddr *string
+var reloadEvery *time.Duration // how often -serve checks the model file for changes
+var tuneGrid *string           // parameter grid for -tune
+var tuneSearch *string
+var tuneTrials *int
+var tuneTime *time.Duration
+var tuneBest *string // where to write the best config found by -tune
+var importFormat *string
+var inspectTree *int // which tree to -inspect, or -1 for the first inspectTrees
+var inspectTrees *int
+var inspectFormat *string
+var inspectDepth *int // how many levels of splits -inspect shows, 0 for all
+var pdpFeatures *string
+var pdpPoints *int
+var pdpICE *bool // -pdp also writes the curve of every row
+var proximityPairs *string
+var proximityDims *int
+var algorithm *string
+var isolationSamples *int // rows per tree for -algo=isolation
+var anomalyLabel *string
+var regression *bool // the predicted column is a number
+var boostRounds *int
+var learningRate *float64
+var boostPatience *int
+var quantileList *string // quantiles -pred estimates from a regression forest
+var quantileLevels []float64
+var targetList *string    // columns a multi-output forest predicts
+var obliqueSplits *string // sets obliqueMode
 
 // in the dataset (minus 1 fold for cross-validation), how many samples
 // should be taken from the dataset (with replacement) to train each tree?
@@ -80,6 +135,15 @@ var skipSize *int
 
 func main() {
 	trn := flag.Bool("train", false, "Train a model")
+	algorithm = flag.String("algo", algoForest, "[forest|isolation|boost|adaboost] what -train grows. isolation is an unsupervised isolation forest for anomaly scores, with no predicted column. boost is gradient boosted trees. adaboost is shallow trees with weighted votes")
+	targetList = flag.String("targets", "", "Comma separated column indexes or -header names for one -algo=forest to predict together, instead of the last column. With -regression they are all numbers")
+	obliqueSplits = flag.String("oblique", "", "[rc|lda] Let -algo=forest nodes also split on a weighted sum of their features: rc tries random weights, lda the discriminant separating each label from the rest")
+	regression = flag.Bool("regression", false, "The last -data column is a number to predict rather than a label, with -algo=forest or -algo=boost")
+	boostRounds = flag.Int("rounds", 100, "Most boosting rounds for -algo=boost or -algo=adaboost")
+	learningRate = flag.Float64("lr", 0.1, "How much of each -algo=boost tree's step to take")
+	boostPatience = flag.Int("patience", 10, "Stop -algo=boost once the validation fold's loss has not improved for this many rounds, 0 to always boost every round")
+	isolationSamples = flag.Int("samples", 256, "Rows each -algo=isolation tree is grown from")
+	anomalyLabel = flag.String("anomaly", "", "With -algo=isolation, the last -data column is a label rather than a feature, and rows with this label are anomalies for scoring the AUC")
 	dataFile = flag.String("data", "", "Training data input file")
 	saveTo = flag.String("save", "", "Where to save the model after training")
 	treesPerFold = flag.Int("trees", 1, "How many decision trees to make per fold of the dataset")
@@ -88,6 +152,13 @@ func main() {
 	pred := flag.Bool("pred", false, "Make a prediction")
 	modelFile = flag.String("model", "", "Load a pretrained model for prediction")
 	seedText = flag.String("seed", "", "Predict based on this string of data")
+	streamRows = flag.Bool("stream", false, "Predict csv or json lines from stdin, writing one prediction per line to stdout")
+	explainPredictions = flag.Bool("explain", false, "With -pred -seed or -stream, explain each prediction as json, with the path through every tree and the TreeSHAP contribution of each feature")
+	quantileList = flag.String("quantiles", "", "With -pred and a -regression forest, also estimate these comma separated quantiles of the prediction, like 0.05,0.5,0.95")
+	batchInput = flag.String("input", "", "Predict every row of this csv file instead of -seed")
+	batchOutput = flag.String("output", "", "Where to write the -input predictions, -pdp curves or -proximity scores as csv (default stdout)")
+	idColumns = flag.String("idcols", "", "Comma separated indexes of -input columns which are not features, and are copied to the -output")
+	hasHeader = flag.Bool("header", false, "The first row of the -data or -input csv is column names")
 	charMode = flag.Bool("charmode", false, "Character prediction mode rather than numeric feature mode. This will create test cases by iterating through the data `skipSize` at a time, and making the previous `sequenceLength` items have higher weights based on the closeness to the current item being predicted.s")
 	skipSize = flag.Int("skipsize", 3, "During -charmode, how many items to skip before making another training case")
 	subsetSizePercent = flag.Float64("subsetpct", 0.6, "Percent of the dataset which should be used to train a tree (always minus 1 fold for cross-validation)")
@@ -95,11 +166,62 @@ func main() {
 	overrideFeatureSplitSize = flag.Int("m", 0, "Override calculation for feature split size (little m)")
 	overrideSequenceLength = flag.Int("seqlen", 0, "Normally equal to the number of variables during -charmode, override for fewer previous look-behind-memory-variables in every input test cases")
 	maxPrint = flag.Int("max", 0, "Stop predicting after this many rounds (-pred only)")
+	finalForest = flag.Bool("final", false, "After cross-validation, train a final forest of -trees on all of the data and save that instead of the fold trees")
+	treeDepth = flag.Int("depth", maxDepth, "Maximum depth of child nodes from the root of each tree")
+	leafSize = flag.Int("leafsize", leafRows, "Nodes with this many training rows or fewer become terminals")
+	keepCVTrees = flag.Bool("keepcv", false, "With -final, also keep the cross-validation trees in the saved model")
+
+	autoTrees = flag.Bool("autotrees", false, "Instead of -trees, keep adding trees to each fold until its holdout accuracy stops improving")
+	autoTreesMax = flag.Int("maxtrees", 500, "Most trees per fold during -autotrees")
+	autoTreesWindow = flag.Int("autok", 10, "During -autotrees, how many trees back to compare the accuracy with")
+	autoTreesTolerance = flag.Float64("autotol", 0.5, "During -autotrees, stop once the accuracy improved less than this many percent over the last -autok trees")
+	accuracyCurveFile = flag.String("curve", "", "Where to write the -autotrees accuracy per number of trees, as csv")
+
+	tun := flag.Bool("tune", false, "Search for the best -trees, -m, -depth and -subsetpct for the -data, by cross-validation")
+	tuneGrid = flag.String("grid", "", "Semicolon separated values to try during -tune, like trees=1,5,10;m=2:4;depth=4:12:2;subsetpct=0.4:0.8:0.1")
+	tuneSearch = flag.String("search", "grid", "[grid|random] how -tune picks configs from -grid")
+	tuneTrials = flag.Int("trials", 0, "Maximum number of configs to try during -tune (default every grid config, or 20 for random search)")
+	tuneTime = flag.Duration("tunetime", 0, "Stop starting new -tune trials after this long, like 30m")
+	tuneBest = flag.String("best", "", "Where to write the best config found by -tune, as json")
 
 	prof = flag.String("profile", "", "[cpu|mem] enable profiling")
 
+	srv := flag.Bool("serve", false, "Serve predictions from the -model over HTTP")
+	serveAddr = flag.String("addr", ":8080", "Address for -serve to listen on for HTTP, empty for none")
+	grpcAddr = flag.String("grpcaddr", "", "Address for -serve to also listen on for gRPC")
+	reloadEvery = flag.Duration("reload", 5*time.Second, "How often -serve checks whether the -model file changed, 0 to only reload on SIGHUP")
+
+	gen := flag.Bool("codegen", false, "Generate a Go package, with a test, that predicts the same as the -model. Writes to the -save directory (default -pkg)")
+	codegenPkg = flag.String("pkg", "pinemodel", "Package name for -codegen, or the name prefix for -toc")
+	codegenTable = flag.Bool("table", false, "With -codegen, put the trees in static arrays instead of if statements")
+
+	toc := flag.Bool("toc", false, "Export a model to a C header with a predict function. Writes to -save (default -pkg.h)")
+	toonnx := flag.Bool("toonnx", false, "Convert a model to an ONNX-ML tree ensemble")
+	topmml := flag.Bool("topmml", false, "Convert a model to PMML")
+	stat := flag.Bool("stats", false, "Summarize the trees, splits and size of the -model")
+	insp := flag.Bool("inspect", false, "Render trees from the -model as text or Graphviz DOT, to -save or stdout")
+	inspectTree = flag.Int("tree", -1, "Which tree to -inspect, counting from 0 (default the first -ntrees)")
+	inspectTrees = flag.Int("ntrees", 1, "How many trees to -inspect, from the first")
+	inspectFormat = flag.String("format", inspectText, "[text|dot|json] how -inspect renders trees (text or dot), or -stats its summary (text or json)")
+	inspectDepth = flag.Int("showdepth", 0, "Summarize -inspect splits deeper than this, 0 to show them all")
+
+	pdp := flag.Bool("pdp", false, "Write the partial dependence of the -model's probabilities on -features, averaged over the -data rows, as csv to -output")
+	pdpFeatures = flag.String("features", "", "One or two comma separated feature indexes or column names for -pdp")
+	pdpPoints = flag.Int("points", 20, "How many quantiles of each -pdp feature's values to sweep")
+	pdpICE = flag.Bool("ice", false, "With -pdp, also write the curve of every row (ICE)")
+
+	prox := flag.Bool("proximity", false, "Write the outlier score of each -data row, from how often the -model's trees put it in the same leaf as the rest of its label, as csv to -output")
+	proximityPairs = flag.String("pairs", "", "Where -proximity writes the proximity of every pair of rows sharing a leaf, as csv")
+	proximityDims = flag.Int("mds", 0, "With -proximity, also embed the rows in this many dimensions by multidimensional scaling")
+
 	tojson := flag.Bool("tojson", false, "Convert a model to json")
+	fromjson := flag.Bool("fromjson", false, "Convert a json model back to gob. Any model flag can also load json directly")
+	importFormat = flag.String("import", "", "[sklearn|xgboost] Convert a json tree dump from -data into a model saved to -save")
 	flag.Parse()
+	maxDepth = *treeDepth
+	leafRows = *leafSize
+	regressionTrees = *regression
+	obliqueMode = *obliqueSplits
 
 	if *prof == "mem" {
 		defer profile.Start(profile.MemProfile).Stop()
@@ -120,23 +242,247 @@ func main() {
 			fmt.Println("-skipsize must be greater than 0")
 			return
 		}
+		switch *algorithm {
+		case algoForest:
+		case algoIsolation:
+			if *charMode {
+				fmt.Println("-algo=isolation is not supported with -charmode")
+				return
+			}
+			if *isolationSamples < 2 {
+				fmt.Println("-samples must be at least 2")
+				return
+			}
+		case algoBoost:
+			if *charMode {
+				fmt.Println("-algo=boost is not supported with -charmode")
+				return
+			}
+			if *n_folds < 2 {
+				fmt.Println("-algo=boost needs at least 2 -folds, to hold one out for stopping early")
+				return
+			}
+		case algoAdaboost:
+			if *charMode {
+				fmt.Println("-algo=adaboost is not supported with -charmode")
+				return
+			}
+			if *n_folds < 2 {
+				fmt.Println("-algo=adaboost needs at least 2 -folds, to hold one out for scoring")
+				return
+			}
+		default:
+			fmt.Println("-algo should be", algoForest, algoIsolation, algoBoost, "or", algoAdaboost)
+			return
+		}
+		if *regression && *algorithm != algoForest && *algorithm != algoBoost {
+			fmt.Println("-regression works with -algo=forest or -algo=boost")
+			return
+		}
+		if *regression && *algorithm == algoForest && (*charMode || *autoTrees) {
+			fmt.Println("-regression is not supported with -charmode or -autotrees")
+			return
+		}
+		if *targetList != "" && (*algorithm != algoForest || *charMode || *autoTrees) {
+			fmt.Println("-targets works with -algo=forest, without -charmode or -autotrees")
+			return
+		}
+		if obliqueMode != "" && obliqueMode != obliqueRC && obliqueMode != obliqueLDA {
+			fmt.Println("-oblique should be", obliqueRC, "or", obliqueLDA)
+			return
+		}
+		if obliqueMode != "" && *algorithm != algoForest {
+			fmt.Println("-oblique works with -algo=forest")
+			return
+		}
+		if *leafSize < 1 {
+			fmt.Println("-leafsize must be at least 1")
+			return
+		}
+		if *autoTrees && (*autoTreesMax < 1 || *autoTreesWindow < 1) {
+			fmt.Println("-maxtrees and -autok must be at least 1")
+			return
+		}
 		train()
 		return
 	}
 
+	if *tun {
+		if *dataFile == "" {
+			fmt.Println("-data flag is required and should be a path to input data")
+			return
+		}
+		if *algorithm != algoForest || *regression || *targetList != "" {
+			fmt.Println("-tune only searches -algo=forest classification")
+			return
+		}
+		if obliqueMode != "" && obliqueMode != obliqueRC && obliqueMode != obliqueLDA {
+			fmt.Println("-oblique should be", obliqueRC, "or", obliqueLDA)
+			return
+		}
+		if *autoTrees && (*autoTreesMax < 1 || *autoTreesWindow < 1) {
+			fmt.Println("-maxtrees and -autok must be at least 1")
+			return
+		}
+		if *tuneGrid == "" {
+			fmt.Println("-grid is required, like -grid=\"trees=1,5,10;m=2:4\"")
+			return
+		}
+		tune()
+		return
+	}
+
 	if *pred {
 		if *modelFile == "" {
 			fmt.Println("-model is required and should be a path for loading the pretrained model")
 			return
 		}
+		if *quantileList != "" {
+			if *explainPredictions {
+				fmt.Println("-quantiles is not supported with -explain")
+				return
+			}
+			qs, err := parseQuantiles(*quantileList)
+			if err != nil {
+				fmt.Println("-quantiles:", err)
+				return
+			}
+			quantileLevels = qs
+		}
+		if *streamRows {
+			streamPredict()
+			return
+		}
+		if *batchInput != "" {
+			if *explainPredictions {
+				fmt.Println("-explain works with -seed or -stream, not -input")
+				return
+			}
+			predictBatch()
+			return
+		}
 		if *seedText == "" {
 			fmt.Println("-seed text is required")
 			return
 		}
+		if *explainPredictions && *charMode {
+			fmt.Println("-explain is not supported with -charmode")
+			return
+		}
 		predict()
 		return
 	}
 
+	if *srv {
+		if *modelFile == "" {
+			fmt.Println("-model is required and should be a path for loading the pretrained model")
+			return
+		}
+		serve()
+		return
+	}
+
+	if *gen {
+		if *modelFile == "" {
+			fmt.Println("-model is required and should be a path for loading the pretrained model")
+			return
+		}
+		if !token.IsIdentifier(*codegenPkg) {
+			fmt.Println("-pkg should be a Go package name, like pinemodel")
+			return
+		}
+		codegen()
+		return
+	}
+
+	if *toc {
+		if *modelFile == "" {
+			fmt.Println("-model is required and should be a path for loading the pretrained model")
+			return
+		}
+		exportC()
+		return
+	}
+
+	if *toonnx {
+		if *modelFile == "" {
+			fmt.Println("-model is required and should be a path for loading the pretrained model")
+			return
+		}
+		exportONNX()
+		return
+	}
+
+	if *topmml {
+		if *modelFile == "" {
+			fmt.Println("-model is required and should be a path for loading the pretrained model")
+			return
+		}
+		exportPMML()
+		return
+	}
+
+	if *importFormat != "" {
+		if *dataFile == "" {
+			fmt.Println("-data flag is required and should be a path to the json tree dump")
+			return
+		}
+		if *saveTo == "" {
+			fmt.Println("-save flag is required and should be a path for saving the model")
+			return
+		}
+		importModel()
+		return
+	}
+
+	if *stat {
+		if *modelFile == "" {
+			fmt.Println("-model is required and should be a path for loading the pretrained model")
+			return
+		}
+		stats()
+		return
+	}
+
+	if *insp {
+		if *modelFile == "" {
+			fmt.Println("-model is required and should be a path for loading the pretrained model")
+			return
+		}
+		inspect()
+		return
+	}
+
+	if *pdp {
+		if *modelFile == "" {
+			fmt.Println("-model is required and should be a path for loading the pretrained model")
+			return
+		}
+		if *dataFile == "" {
+			fmt.Println("-data flag is required and should be a path to rows to average over")
+			return
+		}
+		if *pdpFeatures == "" {
+			fmt.Println("-features is required, like -features=2 or -features=2,3")
+			return
+		}
+		partialDependence()
+		return
+	}
+
+	if *prox {
+		if *modelFile == "" {
+			fmt.Println("-model is required and should be a path for loading the pretrained model")
+			return
+		}
+		if *dataFile == "" {
+			fmt.Println("-data flag is required and should be a path to the rows to compare")
+			return
+		}
+		proximity()
+		return
+	}
+
 	if *tojson {
 		if *modelFile == "" {
 			fmt.Println("-model is required and should be a path for loading the pretrained model")
@@ -146,15 +492,57 @@ func main() {
 		return
 	}
 
+	if *fromjson {
+		if *modelFile == "" {
+			fmt.Println("-model is required and should be a path for loading the json model")
+			return
+		}
+		jsonToGob()
+		return
+	}
+
 	usage()
 }
 
+// flagPassed is whether the named flag was set on the command line, rather
+// than left at its default
+func flagPassed(name string) (passed bool) {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
 func usage() {
 	fmt.Println("Train or a random decision ensemble, or make a prediction from one.\n  tree [-train|-predict] [options]\n  Options:")
 	flag.PrintDefaults()
 }
 
 func train() {
+	switch *algorithm {
+	case algoIsolation:
+		trainIsolation()
+		return
+	case algoBoost:
+		trainBoost()
+		return
+	case algoAdaboost:
+		trainAdaboost()
+		return
+	}
+	if *targetList != "" {
+		trainTargets()
+		return
+	}
+	loadTrainingData()
+	trainAndSave()
+}
+
+// loadTrainingData reads the -data file into trainingCases, and sets up the
+// variables and column globals to match it.
+func loadTrainingData() {
 	// seed the random number generator
 	rand.Seed(time.Now().Unix())
 
@@ -201,9 +589,13 @@ func train() {
 		fmt.Println("sequence length=", sequenceLength)
 		trainingCases = encodeLettersToCases(allChars)
 	} else { // NOT character prediction mode
-		rows := strings.Split(trainingData, "\n")
+		rows := strings.Split(strings.TrimSpace(trainingData), "\n")
 		col1 := strings.Split(rows[0], ",")
 		setColumnGlobals(len(col1))
+		if *hasHeader {
+			columnNames = col1
+			rows = rows[1:]
+		}
 		for rowIndex, row := range rows {
 			nextCase := parseRow(row, rowIndex)
 			trainingCases = append(trainingCases, nextCase)
@@ -219,6 +611,12 @@ func train() {
 		n_features = int(math.Sqrt(float64(columnsPerRow)))
 	}
 
+	parallelTrees = int(math.Ceil(math.Max(2, float64(runtime.NumCPU())/float64(*n_folds))))
+}
+
+// trainAndSave cross-validates a forest on the loaded trainingCases using the
+// current settings, and saves the model to -save.
+func trainAndSave() {
 	fmt.Println("features:", lastColumnIndex)
 	fmt.Println("data folds:", *n_folds)
 	fmt.Println("trees per fold:", *treesPerFold)
@@ -226,19 +624,42 @@ func train() {
 	fmt.Println("feature split size (m):", n_features)
 	fmt.Println("training cases:", len(trainingCases))
 
-	parallelTrees = int(math.Ceil(math.Max(2, float64(runtime.NumCPU())/float64(*n_folds))))
 	fmt.Println("concurrent trees:", parallelTrees, "*", *n_folds, "=", parallelTrees*(*n_folds))
 
 	// run the training testing various numbers of Trees to see how many we need
 	var trees []*Tree
 	var scores []float32
+	meta := modelMeta{
+		Procedure:        procedureCVFolds,
+		Task:             taskClassification,
+		Created:          time.Now(),
+		DataFile:         *dataFile,
+		CharMode:         *charMode,
+		SequenceLength:   sequenceLength,
+		Columns:          columnsPerRow,
+		ColumnNames:      columnNames,
+		Folds:            *n_folds,
+		TreesPerFold:     *treesPerFold,
+		FeatureSplitSize: n_features,
+		MaxDepth:         maxDepth,
+		SubsetPercent:    *subsetSizePercent,
+		Oblique:          obliqueMode,
+	}
+	if regressionTrees {
+		meta.Task = taskRegression
+	}
 	saveNow := func() {
 		s := &saveFormat{
 			Trees:            trees,
+			Flat:             flatten(trees),
 			IndexedVariables: indexedVariables,
 			Variables:        variables,
+			Meta:             meta,
+		}
+		err := save(*saveTo, s)
+		if err != nil {
+			panic(err)
 		}
-		save(*saveTo, s)
 		fmt.Println("\nSaved", len(trees), "trees and", len(indexedVariables), "variables to", *saveTo)
 	}
 	//var t *time.Ticker
@@ -254,13 +675,63 @@ func train() {
 
 	// this is the thing that begins running
 	scores, trees = evaluateAlgorithm()
+	meta.FoldScores = scores
+	if regressionTrees {
+		meta.MeanRMSE = sum(scores) / float32(len(scores))
+	} else {
+		meta.MeanAccuracy = sum(scores) / float32(len(scores))
+	}
 
 	//t.Stop() // prevent saving conflict top the save below
 
 	fmt.Println("\nComplete.")
-	fmt.Println("\nTrees per fold:", *treesPerFold)
+	if *autoTrees {
+		fmt.Println("\nTrees per fold: auto")
+	} else {
+		fmt.Println("\nTrees per fold:", *treesPerFold)
+	}
 	fmt.Println("  Fold Scores:", scores)
-	fmt.Println("  Mean Accuracy:", sum(scores)/float32(len(scores)), "%")
+	if regressionTrees {
+		fmt.Println("  Mean RMSE:", meta.MeanRMSE)
+	} else {
+		fmt.Println("  Mean Accuracy:", meta.MeanAccuracy, "%")
+	}
+
+	finalTrees := *treesPerFold
+	if *autoTrees {
+		// the final forest gets as many trees as the fold that needed the most
+		finalTrees = 0
+		for _, curve := range accuracyCurves {
+			if len(curve) > finalTrees {
+				finalTrees = len(curve)
+			}
+		}
+		meta.AutoTrees = true
+		meta.TreesPerFold = finalTrees
+		fmt.Println("  Trees per fold before leveling off:", len(trees)/len(scores), "average,", finalTrees, "most")
+		if *accuracyCurveFile != "" {
+			if err := writeAccuracyCurves(*accuracyCurveFile); err != nil {
+				panic(err)
+			}
+			fmt.Println("  Wrote accuracy curves to", *accuracyCurveFile)
+		}
+	}
+
+	// The fold trees were each trained without one fold, so the scores above
+	// describe them and not a forest trained on everything. The final forest
+	// uses all of the data and is what gets saved.
+	if *finalForest {
+		fmt.Println("\nTraining final forest of", finalTrees, "trees on all", len(trainingCases), "training cases")
+		final := trainForest("final", trainingCases, finalTrees, nil)
+		meta.FinalTrees = len(final)
+		if *keepCVTrees {
+			trees = append(final, trees...)
+			meta.Procedure = procedureFinalWithCV
+		} else {
+			trees = final
+			meta.Procedure = procedureFinal
+		}
+	}
 
 	saveNow()
 }
@@ -278,6 +749,10 @@ func predict() {
 		panic(err)
 	}
 	fmt.Println(len(loaded.Trees), "Trees loaded")
+	if quantileLevels != nil && len(loaded.Flat.ValueStart) == 0 {
+		fmt.Println(errNoLeafValues)
+		return
+	}
 
 	variables = loaded.Variables
 	sequenceLength = len(variables)
@@ -299,7 +774,7 @@ func predict() {
 		var mostFreqVar float32
 		var lastPrediction string
 		for _, irow := range inputRows {
-			mostFreqVar = baggingPredict(loaded.Trees, irow)
+			mostFreqVar = baggingPredict(loaded.Flat, irow)
 			lastPrediction = indexedVariables[int(mostFreqVar)]
 			fmt.Print(lastPrediction, " ")
 			totalPrinted++
@@ -313,7 +788,7 @@ func predict() {
 		for {
 			// make a row with only the last prediction in it
 			irow = encodeLettersToCases([]string{lastPrediction})[0]
-			mostFreqVar = baggingPredict(loaded.Trees, irow)
+			mostFreqVar = baggingPredict(loaded.Flat, irow)
 			lastPrediction = indexedVariables[int(mostFreqVar)]
 			fmt.Print(lastPrediction, " ")
 			totalPrinted++
@@ -326,13 +801,65 @@ func predict() {
 
 	// not character mode
 
-	// need to set this global first
+	// the model knows how many columns it was trained with, while the seed
+	// only has the features
 	inputRow := strings.Split(*seedText, ",")
-	setColumnGlobals(len(inputRow))
+	if loaded.Meta.Columns > 0 {
+		setColumnGlobals(loaded.Meta.Columns)
+		if len(loaded.Targets) > 0 {
+			setTargetColumns(len(loaded.Targets))
+		}
+		if len(inputRow) != lastColumnIndex {
+			fmt.Println("-seed has", len(inputRow), "features, but the model expects", lastColumnIndex)
+			return
+		}
+	} else {
+		setColumnGlobals(len(inputRow) + 1)
+	}
 
-	inputRows = []datarow{parseRow(*seedText, 0)}
+	irow, err := parseFeatures(inputRow)
+	if err != nil {
+		panic(err)
+	}
+	if len(loaded.Targets) > 0 {
+		if *explainPredictions {
+			fmt.Println("-explain is not supported for multi-output forests")
+			return
+		}
+		// each of the -targets, in order
+		fmt.Println(strings.Join(targetLabels(&loaded, irow), ","))
+		return
+	}
+	if loaded.Meta.Task == taskAnomaly {
+		if *explainPredictions {
+			fmt.Println("-explain is not supported for isolation forests")
+			return
+		}
+		fmt.Println(anomalyScore(loaded.Flat, loaded.Meta.IsolationSamples, irow))
+		return
+	}
+	if loaded.Meta.Task == taskRegression && !*explainPredictions {
+		if quantileLevels == nil {
+			fmt.Println(regressionPredict(loaded.Flat, irow))
+			return
+		}
+		// the mean, then each quantile
+		cols := formatQuantiles(append([]float32{regressionPredict(loaded.Flat, irow)},
+			loaded.Flat.quantiles(irow, quantileLevels)...))
+		fmt.Println(strings.Join(cols, ","))
+		return
+	}
+	if *explainPredictions {
+		buf, err := json.MarshalIndent(explainRow(&loaded, irow, lastColumnIndex), "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(buf))
+		return
+	}
+	inputRows = []datarow{irow}
 	for _, irow := range inputRows {
-		mostFreqVar := baggingPredict(loaded.Trees, irow)
+		mostFreqVar := baggingPredict(loaded.Flat, irow)
 		fmt.Print(indexedVariables[int(mostFreqVar)])
 	}
 
@@ -407,20 +934,40 @@ func gobToJson() {
 	}
 	fmt.Println(len(loaded.Trees), "Trees loaded")
 
-	buf, err := json.Marshal(loaded)
+	var outFile string
+	if *saveTo != "" {
+		outFile = *saveTo
+	} else {
+		base := filepath.Base(*modelFile)
+		outFile = strings.Replace(base, filepath.Ext(base), ".json", 1)
+	}
+	err = saveJSON(outFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Wrote JSON to", outFile)
+}
+
+// jsonToGob converts a json model, such as one edited after -tojson, back
+// to gob
+func jsonToGob() {
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
 	if err != nil {
 		panic(err)
 	}
+	fmt.Println(len(loaded.Trees), "Trees loaded")
+
 	var outFile string
 	if *saveTo != "" {
 		outFile = *saveTo
 	} else {
 		base := filepath.Base(*modelFile)
-		outFile = strings.Replace(base, filepath.Ext(base), ".json", 1)
+		outFile = strings.Replace(base, filepath.Ext(base), ".gob", 1)
 	}
-	err = ioutil.WriteFile(outFile, buf, os.ModePerm)
+	err = save(outFile, &loaded)
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("Wrote JSON to", outFile)
+	fmt.Println("Wrote gob to", outFile)
 }
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// targetFormat is one of the -targets of a multi-output forest, with its own
+// label dictionary like saveFormat's
+type targetFormat struct {
+	Name             string
+	Column           int      // where it is in the -data rows
+	IndexedVariables []string // index to label, or empty for regression
+	Variables        map[string]float32
+}
+
+// label is the text of what the forest predicts for the target
+func (t *targetFormat) label(value float32) string {
+	if len(t.IndexedVariables) == 0 {
+		return strconv.FormatFloat(float64(value), 'g', -1, 32)
+	}
+	return t.IndexedVariables[int(value)]
+}
+
+// setTargetColumns makes the last n columns of each row the predicted ones,
+// after setColumnGlobals
+func setTargetColumns(n int) {
+	lastColumnIndex = columnsPerRow - n
+	outputs = n
+}
+
+// splitTargets separates the -targets from the features of a row with every
+// column, in the same order as the -data rows
+func splitTargets(targets []targetFormat, cols []string) (features []string, actuals []string) {
+	isTarget := make(map[int]bool)
+	for _, t := range targets {
+		isTarget[t.Column] = true
+		actuals = append(actuals, cols[t.Column])
+	}
+	for i, col := range cols {
+		if !isTarget[i] {
+			features = append(features, col)
+		}
+	}
+	return features, actuals
+}
+
+// outputTerminals is what a terminal predicts for each of the -targets: the
+// most represented variable index, ties going to the lowest, or the mean for
+// regression
+func outputTerminals(dataSubset []datarow) (terminals []float32) {
+	terminals = make([]float32, outputs)
+	for j := range terminals {
+		col := lastColumnIndex + j
+		if regressionTrees {
+			_, mean, _ := columnError(dataSubset, col)
+			terminals[j] = float32(mean)
+			continue
+		}
+		counts, _ := countColumn(nil, dataSubset, col)
+		for varIndex, count := range counts {
+			if count > counts[int(terminals[j])] {
+				terminals[j] = float32(varIndex)
+			}
+		}
+	}
+	return terminals
+}
+
+// outputPredict is baggingPredict for each output of a multi-output forest, or
+// with average the mean of the trees' leaves
+func outputPredict(forest *flatForest, row datarow, average bool) (predicted []float32) {
+	n := forest.Outputs
+	predicted = make([]float32, n)
+	votes := make([][]float32, n)
+	for i, root := range forest.Roots {
+		leaf := int(forest.leaf(root, row))
+		w := forest.treeWeight(i)
+		for j, v := range forest.LeafOutputs[leaf*n : (leaf+1)*n] {
+			if average {
+				predicted[j] += v * w
+				continue
+			}
+			for len(votes[j]) <= int(v) {
+				votes[j] = append(votes[j], 0)
+			}
+			votes[j][int(v)] += w
+		}
+	}
+	total := forest.totalWeight()
+	for j := range predicted {
+		if average {
+			predicted[j] /= total
+			continue
+		}
+		for varIndex, count := range votes[j] {
+			if count > votes[j][int(predicted[j])] {
+				predicted[j] = float32(varIndex)
+			}
+		}
+	}
+	return predicted
+}
+
+// targetLabels predicts the text of each of the model's -targets for row
+func targetLabels(model *saveFormat, row datarow) (labels []string) {
+	predicted := outputPredict(model.Flat, row, model.Meta.Task == taskRegression)
+	for j, v := range predicted {
+		labels = append(labels, model.Targets[j].label(v))
+	}
+	return labels
+}
+
+// scoreTargets is the accuracy of each output of forest on rows, or the RMSE
+// for regression
+func scoreTargets(forest *flatForest, rows []datarow) (scores []float32) {
+	actual := make([][]float32, outputs)
+	predicted := make([][]float32, outputs)
+	for _, row := range rows {
+		for j, v := range outputPredict(forest, row, regressionTrees) {
+			actual[j] = append(actual[j], row[lastColumnIndex+j])
+			predicted[j] = append(predicted[j], v)
+		}
+	}
+	for j := range actual {
+		if regressionTrees {
+			scores = append(scores, rmseMetric(actual[j], predicted[j]))
+		} else {
+			scores = append(scores, accuracyMetric(actual[j], predicted[j]))
+		}
+	}
+	return scores
+}
+
+// parseTargetList parses comma separated column indexes or -header names
+func parseTargetList(s string, names []string, columns int) (indexes []int, err error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		ix, err := strconv.Atoi(part)
+		if err != nil {
+			ix = -1
+			for i, name := range names {
+				if name == part {
+					ix = i
+				}
+			}
+			if ix == -1 {
+				return nil, fmt.Errorf("no column named %q", part)
+			}
+		}
+		if ix < 0 || ix >= columns {
+			return nil, fmt.Errorf("column %d is not between 0 and %d", ix, columns-1)
+		}
+		if seen[ix] {
+			return nil, fmt.Errorf("column %d is in the list twice", ix)
+		}
+		seen[ix] = true
+		indexes = append(indexes, ix)
+	}
+	if len(indexes) < 2 {
+		return nil, fmt.Errorf("needs at least two columns; one is predicted from the last column without it")
+	}
+	if len(indexes) >= columns {
+		return nil, fmt.Errorf("leaves no feature columns")
+	}
+	return indexes, nil
+}
+
+/*
+loadTargetData reads the -data file into trainingCases like loadTrainingData,
+but each row is the feature columns followed by the -targets, in their order.
+Each target has its own labels, or is a number with -regression.
+*/
+func loadTargetData() (targets []targetFormat, err error) {
+	rand.Seed(time.Now().Unix())
+
+	fmt.Println("Reading data file", *dataFile)
+	buf, err := ioutil.ReadFile(*dataFile)
+	if err != nil {
+		panic(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	first := strings.Split(lines[0], ",")
+	var header []string
+	if *hasHeader {
+		header = first
+		lines = lines[1:]
+	}
+	targetColumns, err := parseTargetList(*targetList, header, len(first))
+	if err != nil {
+		return nil, err
+	}
+	isTarget := make(map[int]bool)
+	for _, ix := range targetColumns {
+		isTarget[ix] = true
+	}
+	var featureColumns []int
+	for i := range first {
+		if !isTarget[i] {
+			featureColumns = append(featureColumns, i)
+		}
+	}
+	setColumnGlobals(len(first))
+	setTargetColumns(len(targetColumns))
+
+	// the column names are saved in the order of the rows
+	columnNames = nil
+	if header != nil {
+		for _, ix := range append(append([]int(nil), featureColumns...), targetColumns...) {
+			columnNames = append(columnNames, header[ix])
+		}
+	}
+	for _, ix := range targetColumns {
+		name := "col" + strconv.Itoa(ix)
+		if header != nil {
+			name = header[ix]
+		}
+		targets = append(targets, targetFormat{Name: name, Column: ix, Variables: make(map[string]float32)})
+	}
+
+	trainingCases = nil
+	for rowIndex, line := range lines {
+		cols := strings.Split(line, ",")
+		if len(cols) != columnsPerRow {
+			return nil, fmt.Errorf("row %d has %d columns, expected %d", rowIndex, len(cols), columnsPerRow)
+		}
+		row := make(datarow, columnsPerRow)
+		for i, ix := range featureColumns {
+			nc, err := strconv.ParseFloat(strings.TrimSpace(cols[ix]), 32)
+			if err != nil {
+				return nil, fmt.Errorf("row %d column %d: %v", rowIndex, ix, err)
+			}
+			row[i] = float32(nc)
+		}
+		for j, ix := range targetColumns {
+			value := strings.TrimSpace(cols[ix])
+			if *regression {
+				nc, err := strconv.ParseFloat(value, 32)
+				if err != nil {
+					return nil, fmt.Errorf("row %d column %d: %v", rowIndex, ix, err)
+				}
+				row[lastColumnIndex+j] = float32(nc)
+				continue
+			}
+			t := &targets[j]
+			if _, existsYet := t.Variables[value]; !existsYet {
+				t.IndexedVariables = append(t.IndexedVariables, value)
+				t.Variables[value] = float32(len(t.IndexedVariables) - 1)
+			}
+			row[lastColumnIndex+j] = t.Variables[value]
+		}
+		trainingCases = append(trainingCases, row)
+	}
+
+	n_features = *overrideFeatureSplitSize
+	if n_features == 0 {
+		n_features = int(math.Max(1, math.Sqrt(float64(lastColumnIndex))))
+	}
+	parallelTrees = int(math.Max(2, float64(runtime.NumCPU())))
+	return targets, nil
+}
+
+/*
+trainTargets cross-validates one forest predicting all of the -targets, with
+each fold's trees scored on every target, and saves the model to -save. The
+trees split on the combined impurity of the targets, and their terminals
+predict each of them.
+*/
+func trainTargets() {
+	targets, err := loadTargetData()
+	if err != nil {
+		fmt.Println("-targets:", err)
+		return
+	}
+	var names []string
+	for _, t := range targets {
+		names = append(names, t.Name)
+	}
+	fmt.Println("features:", lastColumnIndex)
+	fmt.Println("targets:", strings.Join(names, ", "))
+	fmt.Println("data folds:", *n_folds)
+	fmt.Println("trees per fold:", *treesPerFold)
+	fmt.Println("feature split size (m):", n_features)
+	fmt.Println("training cases:", len(trainingCases))
+
+	folds := splitIntoParts(trainingCases)
+	var trees []*Tree
+	var foldScores []float32
+	targetScores := make([]float32, outputs)
+	for foldIx, testSet := range folds {
+		var trainSet []datarow
+		for i, fold := range folds {
+			if i != foldIx {
+				trainSet = append(trainSet, fold...)
+			}
+		}
+		foldTrees := trainForest(fmt.Sprint(foldIx), trainSet, *treesPerFold, nil)
+		trees = append(trees, foldTrees...)
+		scores := scoreTargets(flatten(foldTrees), testSet)
+		for j, score := range scores {
+			targetScores[j] += score / float32(len(folds))
+		}
+		foldScores = append(foldScores, sum(scores)/float32(len(scores)))
+	}
+
+	meta := modelMeta{
+		Procedure:        procedureCVFolds,
+		Task:             taskClassification,
+		Created:          time.Now(),
+		DataFile:         *dataFile,
+		Columns:          columnsPerRow,
+		ColumnNames:      columnNames,
+		Folds:            *n_folds,
+		TreesPerFold:     *treesPerFold,
+		FeatureSplitSize: n_features,
+		MaxDepth:         maxDepth,
+		SubsetPercent:    *subsetSizePercent,
+		Oblique:          obliqueMode,
+		FoldScores:       foldScores,
+		TargetScores:     targetScores,
+	}
+	fmt.Println("\nComplete.")
+	fmt.Println("  Fold Scores:", foldScores)
+	for j, t := range targets {
+		if regressionTrees {
+			fmt.Println("  "+t.Name, "mean RMSE:", targetScores[j])
+		} else {
+			fmt.Println("  "+t.Name, "mean accuracy:", targetScores[j], "%")
+		}
+	}
+	if regressionTrees {
+		meta.Task = taskRegression
+		meta.MeanRMSE = sum(foldScores) / float32(len(foldScores))
+	} else {
+		meta.MeanAccuracy = sum(foldScores) / float32(len(foldScores))
+	}
+
+	if *finalForest {
+		fmt.Println("\nTraining final forest of", *treesPerFold, "trees on all", len(trainingCases), "training cases")
+		final := trainForest("final", trainingCases, *treesPerFold, nil)
+		meta.FinalTrees = len(final)
+		if *keepCVTrees {
+			trees = append(final, trees...)
+			meta.Procedure = procedureFinalWithCV
+		} else {
+			trees = final
+			meta.Procedure = procedureFinal
+		}
+	}
+
+	err = save(*saveTo, &saveFormat{
+		Trees:   trees,
+		Flat:    flatten(trees),
+		Targets: targets,
+		Meta:    meta,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("\nSaved", len(trees), "trees and", len(targets), "targets to", *saveTo)
+}
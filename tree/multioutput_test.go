@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// useOutputs makes the last n of the columns in each row predicted, until the
+// returned func puts the globals back
+func useOutputs(columns int, n int) (restore func()) {
+	before := columnsPerRow
+	setColumnGlobals(columns)
+	setTargetColumns(n)
+	return func() {
+		setColumnGlobals(before)
+		outputs = 1
+	}
+}
+
+// twoOutputRows has x0 deciding the first output and x1 the second
+func twoOutputRows(r *rand.Rand, n int, scale float32) (rows []datarow) {
+	for i := 0; i < n; i++ {
+		a, b := r.Float32(), r.Float32()
+		row := datarow{a, b, 0, 0}
+		if a > 0.5 {
+			row[2] = 1 * scale
+		}
+		if b > 0.3 {
+			row[3] = 1
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func growTrees(rows []datarow, n int) (trees []*Tree) {
+	for i := 0; i < n; i++ {
+		tree := getSplit(rows)
+		tree.split(1)
+		trees = append(trees, tree)
+	}
+	return trees
+}
+
+func TestMultiOutputForest(t *testing.T) {
+	rand.Seed(1)
+	defer useStumps(2)()
+	defer useOutputs(4, 2)()
+	maxDepth = 2
+	r := rand.New(rand.NewSource(5))
+	rows := twoOutputRows(r, 200, 1)
+
+	trees := growTrees(rows, 3)
+	if trees[0].LeftNode == nil && trees[0].RightNode == nil {
+		t.Fatal("expected a second level")
+	}
+	forest := flatten(trees)
+	if forest.Outputs != 2 || len(forest.LeafOutputs) != 2*len(forest.Leaf) {
+		t.Fatal("outputs", forest.Outputs, len(forest.LeafOutputs), len(forest.Leaf))
+	}
+	for _, score := range scoreTargets(forest, rows) {
+		if score < 98 {
+			t.Fatal("expected each output to be learned, got", scoreTargets(forest, rows))
+		}
+	}
+
+	model := &saveFormat{
+		Trees: trees,
+		Flat:  forest,
+		Targets: []targetFormat{
+			{Name: "big", Column: 2, IndexedVariables: []string{"no", "yes"}},
+			{Name: "wide", Column: 0, IndexedVariables: []string{"no", "yes"}},
+		},
+	}
+	if labels := targetLabels(model, datarow{0.9, 0.1, 0, 0}); labels[0] != "yes" || labels[1] != "no" {
+		t.Fatal("labels", labels)
+	}
+
+	// json models rebuild the outputs
+	path := filepath.Join(t.TempDir(), "multi.json")
+	if err := saveJSON(path, model); err != nil {
+		t.Fatal(err)
+	}
+	var loaded saveFormat
+	if err := load(path, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Flat.Outputs != 2 || len(loaded.Targets) != 2 || loaded.Targets[1].Column != 0 {
+		t.Fatal("loaded", loaded.Flat.Outputs, loaded.Targets)
+	}
+	features, actuals := splitTargets(loaded.Targets, []string{"a", "b", "c"})
+	if len(features) != 1 || features[0] != "b" || actuals[0] != "c" || actuals[1] != "a" {
+		t.Fatal(features, actuals)
+	}
+}
+
+func TestMultiOutputRegression(t *testing.T) {
+	rand.Seed(1)
+	defer useStumps(2)()
+	defer useOutputs(4, 2)()
+	regressionTrees, maxDepth = true, 2
+	defer func() { regressionTrees = false }()
+	// the first output is on a much bigger scale, but both count the same
+	r := rand.New(rand.NewSource(6))
+	rows := twoOutputRows(r, 200, 1000)
+
+	forest := flatten(growTrees(rows, 2))
+	scores := scoreTargets(forest, rows)
+	if scores[0] > 1 || scores[1] > 0.01 {
+		t.Fatal("rmse", scores)
+	}
+	if predicted := outputPredict(forest, datarow{0.9, 0.1, 0, 0}, true); predicted[0] != 1000 || predicted[1] != 0 {
+		t.Fatal("predicted", predicted)
+	}
+
+	// x0 takes away more of the first output's squared error, but x1 takes
+	// away all of the second's, and a bigger share of what there was
+	rows = []datarow{{0, 0, 0, 0}, {0, 1, 0, 1}, {1, 0, 1000, 0}, {1, 1, 3000, 1}}
+	sc := splitCache{}
+	sc.splitOnIndex(0, 1, rows)
+	x0 := sc.outputImpurity()
+	sc.splitOnIndex(1, 1, rows)
+	x1 := sc.outputImpurity()
+	if x0 < 1.33 || x0 > 1.34 || x1 < 0.83 || x1 > 0.84 {
+		t.Fatal("impurity", x0, x1)
+	}
+}
+
+func TestParseTargetList(t *testing.T) {
+	names := []string{"a", "b", "c", "d"}
+	indexes, err := parseTargetList("d, 1", names, 4)
+	if err != nil || len(indexes) != 2 || indexes[0] != 3 || indexes[1] != 1 {
+		t.Fatal(indexes, err)
+	}
+	for _, bad := range []string{"a", "a,a", "a,e", "0,4", "a,b,c,d"} {
+		if _, err := parseTargetList(bad, names, 4); err == nil {
+			t.Fatal("expected an error for", bad)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// How -oblique finds the directions a node tries splitting along, besides
+// each of its features on its own
+const (
+	obliqueRC  = "rc"  // random combinations of the node's features, like Forest-RC
+	obliqueLDA = "lda" // the Fisher discriminant of each label against the rest
+)
+
+// obliqueMode is the -oblique option, or empty for axis-aligned trees only
+var obliqueMode string
+
+// project is the sum of row's features, each times its weight, which an
+// oblique node compares with its threshold
+func project(row datarow, features []int32, weights []float32) (value float32) {
+	for i, f := range features {
+		value += row[f] * weights[i]
+	}
+	return value
+}
+
+/*
+obliqueSplit is the best split of dataSubset along a combination of features,
+by the same impurity as getSplit, or nil when there is none. Each direction
+tried splits at the projected value of every row, like getSplit does with one
+feature.
+
+With -oblique=rc the directions are random weights between -1 and 1 for each
+feature, divided by the feature's standard deviation in the node so that no
+feature counts more for its scale alone. With -oblique=lda they separate each
+label from the rest, or the rows above the median from those below it for
+regression. A multi-output forest separates its first target.
+*/
+func obliqueSplit(sc *splitCache, dataSubset []datarow, features []int32) (t *Tree, gini float64) {
+	features = obliqueFeatures(features)
+	if len(features) < 2 {
+		return nil, 0
+	}
+	var directions [][]float32
+	if obliqueMode == obliqueLDA {
+		directions = ldaDirections(dataSubset, features)
+	} else {
+		directions = randomDirections(dataSubset, features, len(features))
+	}
+
+	gini = math.Inf(1)
+	values := make([]float32, len(dataSubset))
+	for _, direction := range directions {
+		used, weights := nonzeroWeights(features, direction)
+		if len(used) < 2 {
+			continue // no better than the feature on its own
+		}
+		for i, row := range dataSubset {
+			values[i] = project(row, used, weights)
+		}
+		for _, threshold := range values {
+			sc.splitOnValues(values, threshold, dataSubset)
+			if g := sc.splitImpurity(dataSubset); g < gini {
+				gini = g
+				t = &Tree{
+					VariableIndex:   float32(used[0]),
+					ValueIndex:      threshold,
+					ObliqueFeatures: used,
+					ObliqueWeights:  weights,
+				}
+			}
+		}
+	}
+	if t == nil {
+		return nil, 0
+	}
+	for i, row := range dataSubset {
+		values[i] = t.splitValue(row)
+	}
+	sc.splitOnValues(values, t.ValueIndex, dataSubset)
+	t.leftSamples = append([]datarow(nil), sc.left...)
+	t.rightSamples = append([]datarow(nil), sc.right...)
+	t.LeftCount = len(t.leftSamples)
+	t.RightCount = len(t.rightSamples)
+	return t, gini
+}
+
+// obliqueFeatures is the node's features, with another random one when it
+// has only one to combine
+func obliqueFeatures(features []int32) []int32 {
+	features = append([]int32(nil), features...)
+	for len(features) < 2 && len(features) < lastColumnIndex {
+		index := rand.Int31n(int32(lastColumnIndex))
+		if !includes(features, index) {
+			features = append(features, index)
+		}
+	}
+	return features
+}
+
+// nonzeroWeights leaves out the features a direction gives no weight
+func nonzeroWeights(features []int32, direction []float32) (used []int32, weights []float32) {
+	for i, w := range direction {
+		if w != 0 && !math.IsNaN(float64(w)) && !math.IsInf(float64(w), 0) {
+			used = append(used, features[i])
+			weights = append(weights, w)
+		}
+	}
+	return used, weights
+}
+
+// randomDirections is n Forest-RC directions for combining features. A
+// feature which is the same in every row gets no weight.
+func randomDirections(dataSubset []datarow, features []int32, n int) (directions [][]float32) {
+	scale := make([]float64, len(features))
+	for j, f := range features {
+		_, _, sse := columnError(dataSubset, int(f))
+		if sse > 0 {
+			scale[j] = 1 / math.Sqrt(sse/float64(len(dataSubset)))
+		}
+	}
+	for i := 0; i < n; i++ {
+		direction := make([]float32, len(features))
+		for j := range direction {
+			direction[j] = float32((rand.Float64()*2 - 1) * scale[j])
+		}
+		directions = append(directions, direction)
+	}
+	return directions
+}
+
+// ldaDirections is the Fisher discriminant of each group of rows worth
+// separating from the rest of dataSubset
+func ldaDirections(dataSubset []datarow, features []int32) (directions [][]float32) {
+	col := lastColumnIndex
+	var groups []func(row datarow) bool
+	if regressionTrees {
+		values := make([]float64, len(dataSubset))
+		for i, row := range dataSubset {
+			values[i] = float64(row[col])
+		}
+		sort.Float64s(values)
+		median := float32(values[len(values)/2])
+		groups = append(groups, func(row datarow) bool { return row[col] >= median })
+	} else {
+		counts, _ := countColumn(nil, dataSubset, col)
+		var labels []float32
+		for varIndex, count := range counts {
+			if count > 0 {
+				labels = append(labels, float32(varIndex))
+			}
+		}
+		if len(labels) == 2 {
+			labels = labels[:1] // the other one is the same direction
+		}
+		for _, label := range labels {
+			label := label
+			groups = append(groups, func(row datarow) bool { return row[col] == label })
+		}
+	}
+	for _, inGroup := range groups {
+		if direction := fisherDirection(dataSubset, features, inGroup); direction != nil {
+			directions = append(directions, direction)
+		}
+	}
+	return directions
+}
+
+/*
+fisherDirection is the direction along features which best separates the rows
+inGroup from the others compared with how spread out each of them is: the
+within group scatter, plus a little ridge so it can be solved when features
+are correlated, solved for the difference of the group means. It is nil when
+one of the groups is empty or the scatter cannot be solved.
+*/
+func fisherDirection(dataSubset []datarow, features []int32, inGroup func(row datarow) bool) []float32 {
+	m := len(features)
+	means := [2][]float64{make([]float64, m), make([]float64, m)}
+	var sizes [2]float64
+	group := func(row datarow) int {
+		if inGroup(row) {
+			return 1
+		}
+		return 0
+	}
+	for _, row := range dataSubset {
+		g := group(row)
+		sizes[g]++
+		for j, f := range features {
+			means[g][j] += float64(row[f])
+		}
+	}
+	if sizes[0] == 0 || sizes[1] == 0 {
+		return nil
+	}
+	for g := range means {
+		for j := range means[g] {
+			means[g][j] /= sizes[g]
+		}
+	}
+
+	scatter := make([][]float64, m)
+	for j := range scatter {
+		scatter[j] = make([]float64, m)
+	}
+	centered := make([]float64, m)
+	for _, row := range dataSubset {
+		g := group(row)
+		for j, f := range features {
+			centered[j] = float64(row[f]) - means[g][j]
+		}
+		for j := range scatter {
+			for k := range scatter[j] {
+				scatter[j][k] += centered[j] * centered[k]
+			}
+		}
+	}
+	var trace float64
+	for j := range scatter {
+		trace += scatter[j][j]
+	}
+	if trace == 0 {
+		return nil
+	}
+	for j := range scatter {
+		scatter[j][j] += 1e-3 * trace / float64(m)
+	}
+
+	difference := make([]float64, m)
+	for j := range difference {
+		difference[j] = means[1][j] - means[0][j]
+	}
+	solved := solveLinear(scatter, difference)
+	if solved == nil {
+		return nil
+	}
+	direction := make([]float32, m)
+	for j, w := range solved {
+		direction[j] = float32(w)
+	}
+	return direction
+}
+
+// solveLinear solves a x = b by Gaussian elimination with partial pivoting,
+// changing a and b, or is nil when a is singular
+func solveLinear(a [][]float64, b []float64) (x []float64) {
+	n := len(b)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for r := col + 1; r < n; r++ {
+			factor := a[r][col] / a[col][col]
+			for c := col; c < n; c++ {
+				a[r][c] -= factor * a[col][c]
+			}
+			b[r] -= factor * b[col]
+		}
+	}
+	x = make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		s := b[r]
+		for c := r + 1; c < n; c++ {
+			s -= a[r][c] * x[c]
+		}
+		x[r] = s / a[r][r]
+	}
+	return x
+}
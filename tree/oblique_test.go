@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// diagonalRows is labeled 1 above the line x1 = x0, which no one feature can
+// split well
+func diagonalRows(r *rand.Rand, n int) (rows []datarow) {
+	for i := 0; i < n; i++ {
+		a, b := r.Float32(), r.Float32()
+		row := datarow{a, b, 0}
+		if b > a {
+			row[2] = 1
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// separated is the share of rows on the side of t's split with their label
+func separated(t *Tree, rows []datarow) float64 {
+	var correct int
+	for _, row := range rows {
+		if t.predict(row) == row[2] {
+			correct++
+		}
+	}
+	return float64(correct) / float64(len(rows))
+}
+
+func TestObliquePredict(t *testing.T) {
+	oblique := &Tree{VariableIndex: 0, ValueIndex: 0.5, LeftTerminal: 0, RightTerminal: 1,
+		ObliqueFeatures: []int32{0, 1}, ObliqueWeights: []float32{1, -1}}
+	trees := []*Tree{
+		{VariableIndex: 1, ValueIndex: 2, LeftNode: oblique, RightTerminal: 2},
+		{VariableIndex: 0, ValueIndex: 1, LeftTerminal: 0, RightTerminal: 1},
+	}
+	forest := flatten(trees)
+	if len(forest.ObliqueStart) != len(forest.Feature)+1 || len(forest.ObliqueFeature) != 2 {
+		t.Fatal("oblique layout", forest.ObliqueStart, forest.ObliqueFeature)
+	}
+	if flatten(trees[1:]).ObliqueStart != nil {
+		t.Fatal("expected no oblique nodes")
+	}
+	for _, c := range []struct {
+		row      datarow
+		expected float32
+	}{
+		{datarow{1, 0.8, 0}, 0},
+		{datarow{1, 0.4, 0}, 1},
+		{datarow{0, 3, 0}, 2},
+	} {
+		if got := trees[0].predict(c.row); got != c.expected {
+			t.Fatal(c.row, "predicted", got, "expected", c.expected)
+		}
+		if got := forest.predictTree(forest.Roots[0], c.row); got != c.expected {
+			t.Fatal(c.row, "flat predicted", got, "expected", c.expected)
+		}
+	}
+
+	r := treeRenderer{model: &saveFormat{}}
+	if got := r.condition(oblique); got != "1*x0 - 1*x1 < 0.5" {
+		t.Fatal(got)
+	}
+	steps, _, _ := oblique.decisionPath(datarow{1, 0.8, 0}, nil)
+	if len(steps[0].Features) != 2 || math.Abs(float64(steps[0].Value-0.2)) > 1e-6 || !steps[0].Left {
+		t.Fatal("path", steps)
+	}
+	model := &saveFormat{Trees: trees, Flat: forest, IndexedVariables: []string{"a", "b", "c"}}
+	if _, _, err := forestSHAP(model, datarow{1, 0.8, 0}, 0, 2); err != errOblique {
+		t.Fatal("expected", errOblique, "got", err)
+	}
+}
+
+func TestObliqueSplit(t *testing.T) {
+	defer useStumps(2)()
+	defer func() { obliqueMode = "" }()
+	r := rand.New(rand.NewSource(3))
+	rows := diagonalRows(r, 120)
+
+	rand.Seed(1)
+	tree := getSplit(rows)
+	tree.split(1)
+	axis := separated(tree, rows)
+	if axis > 0.85 {
+		t.Fatal("expected one feature to split the diagonal poorly, got", axis)
+	}
+	for _, mode := range []string{obliqueRC, obliqueLDA} {
+		obliqueMode = mode
+		var best float64
+		for i := 0; i < 10; i++ {
+			tree = getSplit(rows)
+			if len(tree.ObliqueFeatures) > 0 && tree.LeftCount+tree.RightCount != len(rows) {
+				t.Fatal(mode, "counts", tree.LeftCount, tree.RightCount)
+			}
+			tree.split(1)
+			if s := separated(tree, rows); s > best {
+				best = s
+			}
+		}
+		if best < axis+0.1 {
+			t.Fatal(mode, "split", best, "of the rows, no better than one feature's", axis)
+		}
+	}
+	tree = getSplit(rows)
+	tree.split(1)
+	if s := separated(tree, rows); s < 0.95 {
+		t.Fatal("expected lda to find the diagonal, got", s)
+	}
+}
+
+func TestFisherDirection(t *testing.T) {
+	defer useStumps(2)()
+	// the groups are apart along x0 + x1, and spread out along x0 - x1
+	rows := []datarow{
+		{0, 0, 0}, {1, -1, 0}, {-1, 1, 0},
+		{1, 1, 1}, {2, 0, 1}, {0, 2, 1},
+	}
+	direction := fisherDirection(rows, []int32{0, 1}, func(row datarow) bool { return row[2] == 1 })
+	if direction == nil || direction[0] <= 0 || math.Abs(float64(direction[0]-direction[1])) > 1e-4 {
+		t.Fatal("expected equal positive weights, got", direction)
+	}
+	if fisherDirection(rows, []int32{0, 1}, func(datarow) bool { return true }) != nil {
+		t.Fatal("expected no direction with one group")
+	}
+
+	x := solveLinear([][]float64{{2, 1}, {1, 3}}, []float64{3, 5})
+	if math.Abs(x[0]-0.8) > 1e-9 || math.Abs(x[1]-1.4) > 1e-9 {
+		t.Fatal("solved", x)
+	}
+	if solveLinear([][]float64{{1, 2}, {2, 4}}, []float64{1, 2}) != nil {
+		t.Fatal("expected a singular system")
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+/*
+ONNX-ML export. A classification forest becomes a TreeEnsembleClassifier
+where every leaf gives 1/trees to its class, so the scores output is the share
+of trees voting for each label, the same as baggingProba. A regression forest
+becomes a TreeEnsembleRegressor which averages the trees.
+
+Splits use BRANCH_LT, matching the < in Tree.predict. The protobuf is written
+directly with protowire, using the field numbers from onnx-ml.proto, to avoid
+depending on generated ONNX code.
+*/
+
+// onnx-ml.proto field numbers
+const (
+	onnxModelIRVersion      = 1
+	onnxModelProducerName   = 2
+	onnxModelDocString      = 6
+	onnxModelGraph          = 7
+	onnxModelOpsetImport    = 8
+	onnxOpsetDomain         = 1
+	onnxOpsetVersion        = 2
+	onnxGraphNode           = 1
+	onnxGraphName           = 2
+	onnxGraphInput          = 11
+	onnxGraphOutput         = 12
+	onnxNodeInput           = 1
+	onnxNodeOutput          = 2
+	onnxNodeName            = 3
+	onnxNodeOpType          = 4
+	onnxNodeAttribute       = 5
+	onnxNodeDomain          = 7
+	onnxAttrName            = 1
+	onnxAttrS               = 4
+	onnxAttrI               = 3
+	onnxAttrFloats          = 7
+	onnxAttrInts            = 8
+	onnxAttrStrings         = 9
+	onnxAttrType            = 20
+	onnxValueInfoName       = 1
+	onnxValueInfoType       = 2
+	onnxTypeTensor          = 1
+	onnxTensorTypeElemType  = 1
+	onnxTensorTypeShape     = 2
+	onnxShapeDim            = 1
+	onnxDimValue            = 1
+	onnxDimParam            = 2
+	onnxAttributeTypeInt    = 2
+	onnxAttributeTypeString = 3
+	onnxAttributeTypeFloats = 6
+	onnxAttributeTypeInts   = 7
+	onnxAttributeTypeStrs   = 8
+	onnxElemFloat           = 1
+	onnxElemString          = 8
+)
+
+const onnxMLDomain = "ai.onnx.ml"
+
+// onnxMessage builds up an encoded protobuf message
+type onnxMessage []byte
+
+func (m onnxMessage) str(num protowire.Number, s string) onnxMessage {
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendString(m, s)
+}
+
+func (m onnxMessage) msg(num protowire.Number, sub onnxMessage) onnxMessage {
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendBytes(m, sub)
+}
+
+func (m onnxMessage) int(num protowire.Number, v int64) onnxMessage {
+	m = protowire.AppendTag(m, num, protowire.VarintType)
+	return protowire.AppendVarint(m, uint64(v))
+}
+
+func onnxIntsAttr(name string, values []int64) onnxMessage {
+	a := onnxMessage{}.str(onnxAttrName, name)
+	for _, v := range values {
+		a = a.int(onnxAttrInts, v)
+	}
+	return a.int(onnxAttrType, onnxAttributeTypeInts)
+}
+
+func onnxFloatsAttr(name string, values []float32) onnxMessage {
+	a := onnxMessage{}.str(onnxAttrName, name)
+	for _, v := range values {
+		a = protowire.AppendTag(a, onnxAttrFloats, protowire.Fixed32Type)
+		a = protowire.AppendFixed32(a, math.Float32bits(v))
+	}
+	return a.int(onnxAttrType, onnxAttributeTypeFloats)
+}
+
+func onnxStringsAttr(name string, values []string) onnxMessage {
+	a := onnxMessage{}.str(onnxAttrName, name)
+	for _, v := range values {
+		a = a.str(onnxAttrStrings, v)
+	}
+	return a.int(onnxAttrType, onnxAttributeTypeStrs)
+}
+
+func onnxStringAttr(name string, value string) onnxMessage {
+	return onnxMessage{}.str(onnxAttrName, name).str(onnxAttrS, value).int(onnxAttrType, onnxAttributeTypeString)
+}
+
+func onnxIntAttr(name string, value int64) onnxMessage {
+	return onnxMessage{}.str(onnxAttrName, name).int(onnxAttrI, value).int(onnxAttrType, onnxAttributeTypeInt)
+}
+
+// onnxTensorValue describes a graph input or output. A zero dim is a
+// variable size, named by dimParam.
+func onnxTensorValue(name string, elemType int64, dims []int64, dimParam string) onnxMessage {
+	shape := onnxMessage{}
+	for _, d := range dims {
+		dim := onnxMessage{}
+		if d == 0 {
+			dim = dim.str(onnxDimParam, dimParam)
+		} else {
+			dim = dim.int(onnxDimValue, d)
+		}
+		shape = shape.msg(onnxShapeDim, dim)
+	}
+	tensor := onnxMessage{}.int(onnxTensorTypeElemType, elemType).msg(onnxTensorTypeShape, shape)
+	typ := onnxMessage{}.msg(onnxTypeTensor, tensor)
+	return onnxMessage{}.str(onnxValueInfoName, name).msg(onnxValueInfoType, typ)
+}
+
+// onnxTreeNodes are the nodes_* attributes shared by the classifier and
+// regressor, plus where each leaf is
+type onnxTreeNodes struct {
+	treeIDs, nodeIDs, featureIDs, trueIDs, falseIDs []int64
+	values                                          []float32
+	modes                                           []string
+	leafTreeIDs, leafNodeIDs                        []int64
+	leafValues                                      []float32
+}
+
+// add numbers the nodes of a tree depth first, with the terminals as their
+// own LEAF nodes
+func (n *onnxTreeNodes) add(treeID int64, t *Tree) {
+	var next int64
+	var addNode func(t *Tree) int64
+	addLeaf := func(value float32) int64 {
+		id := next
+		next++
+		n.treeIDs = append(n.treeIDs, treeID)
+		n.nodeIDs = append(n.nodeIDs, id)
+		n.featureIDs = append(n.featureIDs, 0)
+		n.trueIDs = append(n.trueIDs, 0)
+		n.falseIDs = append(n.falseIDs, 0)
+		n.values = append(n.values, 0)
+		n.modes = append(n.modes, "LEAF")
+		n.leafTreeIDs = append(n.leafTreeIDs, treeID)
+		n.leafNodeIDs = append(n.leafNodeIDs, id)
+		n.leafValues = append(n.leafValues, value)
+		return id
+	}
+	addNode = func(t *Tree) int64 {
+		id := next
+		next++
+		i := len(n.nodeIDs)
+		n.treeIDs = append(n.treeIDs, treeID)
+		n.nodeIDs = append(n.nodeIDs, id)
+		n.featureIDs = append(n.featureIDs, int64(t.VariableIndex))
+		n.trueIDs = append(n.trueIDs, 0)
+		n.falseIDs = append(n.falseIDs, 0)
+		n.values = append(n.values, t.ValueIndex)
+		n.modes = append(n.modes, "BRANCH_LT")
+		if t.LeftNode != nil {
+			n.trueIDs[i] = addNode(t.LeftNode)
+		} else {
+			n.trueIDs[i] = addLeaf(t.LeftTerminal)
+		}
+		if t.RightNode != nil {
+			n.falseIDs[i] = addNode(t.RightNode)
+		} else {
+			n.falseIDs[i] = addLeaf(t.RightTerminal)
+		}
+		return id
+	}
+	addNode(t)
+}
+
+func (n *onnxTreeNodes) attributes() (attrs []onnxMessage) {
+	return []onnxMessage{
+		onnxIntsAttr("nodes_treeids", n.treeIDs),
+		onnxIntsAttr("nodes_nodeids", n.nodeIDs),
+		onnxIntsAttr("nodes_featureids", n.featureIDs),
+		onnxStringsAttr("nodes_modes", n.modes),
+		onnxFloatsAttr("nodes_values", n.values),
+		onnxIntsAttr("nodes_truenodeids", n.trueIDs),
+		onnxIntsAttr("nodes_falsenodeids", n.falseIDs),
+		onnxStringAttr("post_transform", "NONE"),
+	}
+}
+
+// toONNX encodes the model as an ONNX ModelProto
+func toONNX(model *saveFormat) (encoded []byte, err error) {
+	if len(model.Trees) == 0 {
+		return nil, fmt.Errorf("the model has no trees")
+	}
+	if model.Flat.Additive {
+		return nil, fmt.Errorf("cannot export an additive forest to ONNX")
+	}
+	if model.Meta.Task == taskAnomaly {
+		return nil, fmt.Errorf("cannot export an isolation forest to ONNX")
+	}
+	if model.Flat.Outputs > 0 {
+		return nil, fmt.Errorf("cannot export a multi-output forest to ONNX")
+	}
+	if model.Flat.ObliqueStart != nil {
+		return nil, fmt.Errorf("cannot export a forest with oblique splits to ONNX")
+	}
+	features, _, err := modelFieldNames(model)
+	if err != nil {
+		return nil, err
+	}
+	regression := model.Meta.Task == taskRegression
+
+	nodes := &onnxTreeNodes{}
+	for i, t := range model.Trees {
+		nodes.add(int64(i), t)
+	}
+	attrs := nodes.attributes()
+	total := model.Flat.totalWeight()
+
+	node := onnxMessage{}.str(onnxNodeInput, "X")
+	var outputs []onnxMessage
+	if regression {
+		node = node.str(onnxNodeOutput, "Y").str(onnxNodeName, "forest").
+			str(onnxNodeOpType, "TreeEnsembleRegressor")
+		targetIDs := make([]int64, len(nodes.leafValues))
+		attrs = append(attrs,
+			onnxIntAttr("n_targets", 1),
+			onnxStringAttr("aggregate_function", "AVERAGE"),
+			onnxIntsAttr("target_treeids", nodes.leafTreeIDs),
+			onnxIntsAttr("target_nodeids", nodes.leafNodeIDs),
+			onnxIntsAttr("target_ids", targetIDs),
+			onnxFloatsAttr("target_weights", nodes.leafValues),
+		)
+		outputs = append(outputs, onnxTensorValue("Y", onnxElemFloat, []int64{0, 1}, "N"))
+	} else {
+		node = node.str(onnxNodeOutput, "label").str(onnxNodeOutput, "scores").
+			str(onnxNodeName, "forest").str(onnxNodeOpType, "TreeEnsembleClassifier")
+		classIDs := make([]int64, len(nodes.leafValues))
+		weights := make([]float32, len(nodes.leafValues))
+		for i, v := range nodes.leafValues {
+			classIDs[i] = int64(v)
+			weights[i] = model.Flat.treeWeight(int(nodes.leafTreeIDs[i])) / total
+		}
+		attrs = append(attrs,
+			onnxStringsAttr("classlabels_strings", model.IndexedVariables),
+			onnxIntsAttr("class_treeids", nodes.leafTreeIDs),
+			onnxIntsAttr("class_nodeids", nodes.leafNodeIDs),
+			onnxIntsAttr("class_ids", classIDs),
+			onnxFloatsAttr("class_weights", weights),
+		)
+		outputs = append(outputs,
+			onnxTensorValue("label", onnxElemString, []int64{0}, "N"),
+			onnxTensorValue("scores", onnxElemFloat, []int64{0, int64(len(model.IndexedVariables))}, "N"),
+		)
+	}
+	for _, a := range attrs {
+		node = node.msg(onnxNodeAttribute, a)
+	}
+	node = node.str(onnxNodeDomain, onnxMLDomain)
+
+	graph := onnxMessage{}.msg(onnxGraphNode, node).str(onnxGraphName, "pine").
+		msg(onnxGraphInput, onnxTensorValue("X", onnxElemFloat, []int64{0, int64(len(features))}, "N"))
+	for _, out := range outputs {
+		graph = graph.msg(onnxGraphOutput, out)
+	}
+
+	m := onnxMessage{}.int(onnxModelIRVersion, 8).str(onnxModelProducerName, "pine").
+		str(onnxModelDocString, fmt.Sprintf("pine random decision forest of %d trees", len(model.Trees))).
+		msg(onnxModelGraph, graph).
+		msg(onnxModelOpsetImport, onnxMessage{}.str(onnxOpsetDomain, onnxMLDomain).int(onnxOpsetVersion, 3)).
+		msg(onnxModelOpsetImport, onnxMessage{}.str(onnxOpsetDomain, "").int(onnxOpsetVersion, 17))
+	return m, nil
+}
+
+// exportONNX writes the -model as ONNX next to it, or to -save
+func exportONNX() {
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(len(loaded.Trees), "Trees loaded")
+
+	buf, err := toONNX(&loaded)
+	if err != nil {
+		panic(err)
+	}
+	var outFile string
+	if *saveTo != "" {
+		outFile = *saveTo
+	} else {
+		base := filepath.Base(*modelFile)
+		outFile = strings.Replace(base, filepath.Ext(base), ".onnx", 1)
+	}
+	err = ioutil.WriteFile(outFile, buf, os.ModePerm)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Wrote ONNX to", outFile)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoFields decodes one level of a protobuf message, by field number
+type protoFields map[protowire.Number][]protoValue
+
+type protoValue struct {
+	varint uint64
+	bytes  []byte
+}
+
+func decodeProto(t *testing.T, b []byte) protoFields {
+	fields := make(protoFields)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v protoValue
+		switch typ {
+		case protowire.VarintType:
+			v.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var f uint32
+			f, n = protowire.ConsumeFixed32(b)
+			v.varint = uint64(f)
+		case protowire.BytesType:
+			v.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatal("unexpected wire type", typ)
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		fields[num] = append(fields[num], v)
+	}
+	return fields
+}
+
+func (f protoFields) str(num protowire.Number) string {
+	if len(f[num]) == 0 {
+		return ""
+	}
+	return string(f[num][0].bytes)
+}
+
+// onnxAttributes decodes a NodeProto's attributes by name
+func onnxAttributes(t *testing.T, node protoFields) map[string]protoFields {
+	attrs := make(map[string]protoFields)
+	for _, a := range node[onnxNodeAttribute] {
+		fields := decodeProto(t, a.bytes)
+		attrs[fields.str(onnxAttrName)] = fields
+	}
+	return attrs
+}
+
+func (f protoFields) ints() (values []int64) {
+	for _, v := range f[onnxAttrInts] {
+		values = append(values, int64(v.varint))
+	}
+	return values
+}
+
+func (f protoFields) floats() (values []float32) {
+	for _, v := range f[onnxAttrFloats] {
+		values = append(values, math.Float32frombits(uint32(v.varint)))
+	}
+	return values
+}
+
+func (f protoFields) strs() (values []string) {
+	for _, v := range f[onnxAttrStrings] {
+		values = append(values, string(v.bytes))
+	}
+	return values
+}
+
+// onnxEnsemble re-evaluates the decoded tree ensemble attributes the way
+// ONNX Runtime does
+type onnxEnsemble struct {
+	nodes   map[[2]int64]int // tree and node id to attribute index
+	attrs   map[string]protoFields
+	leaves  map[[2]int64][]int // tree and node id to class or target entries
+	prefix  string
+	nTrees  int
+	outputs int
+}
+
+func newONNXEnsemble(attrs map[string]protoFields, prefix string, outputs int) *onnxEnsemble {
+	e := &onnxEnsemble{nodes: make(map[[2]int64]int), attrs: attrs, leaves: make(map[[2]int64][]int), prefix: prefix, outputs: outputs}
+	treeIDs := attrs["nodes_treeids"].ints()
+	nodeIDs := attrs["nodes_nodeids"].ints()
+	for i := range treeIDs {
+		e.nodes[[2]int64{treeIDs[i], nodeIDs[i]}] = i
+		if int(treeIDs[i])+1 > e.nTrees {
+			e.nTrees = int(treeIDs[i]) + 1
+		}
+	}
+	leafTrees := attrs[prefix+"_treeids"].ints()
+	leafNodes := attrs[prefix+"_nodeids"].ints()
+	for i := range leafTrees {
+		key := [2]int64{leafTrees[i], leafNodes[i]}
+		e.leaves[key] = append(e.leaves[key], i)
+	}
+	return e
+}
+
+func (e *onnxEnsemble) scores(t *testing.T, row datarow) []float32 {
+	nodeIDs := e.attrs["nodes_nodeids"].ints()
+	modes := e.attrs["nodes_modes"].strs()
+	features := e.attrs["nodes_featureids"].ints()
+	values := e.attrs["nodes_values"].floats()
+	trueIDs := e.attrs["nodes_truenodeids"].ints()
+	falseIDs := e.attrs["nodes_falsenodeids"].ints()
+	ids := e.attrs[e.prefix+"_ids"].ints()
+	weights := e.attrs[e.prefix+"_weights"].floats()
+
+	scores := make([]float32, e.outputs)
+	for tree := 0; tree < e.nTrees; tree++ {
+		i := e.nodes[[2]int64{int64(tree), 0}]
+		for modes[i] != "LEAF" {
+			if modes[i] != "BRANCH_LT" {
+				t.Fatal("unexpected node mode", modes[i])
+			}
+			next := falseIDs[i]
+			if row[features[i]] < values[i] {
+				next = trueIDs[i]
+			}
+			i = e.nodes[[2]int64{int64(tree), next}]
+		}
+		key := [2]int64{int64(tree), nodeIDs[i]}
+		for _, entry := range e.leaves[key] {
+			scores[ids[entry]] += weights[entry]
+		}
+	}
+	return scores
+}
+
+func decodeONNXNode(t *testing.T, encoded []byte) (node protoFields, opsets map[string]int64) {
+	model := decodeProto(t, encoded)
+	if len(model[onnxModelGraph]) != 1 {
+		t.Fatal("expected one graph")
+	}
+	opsets = make(map[string]int64)
+	for _, o := range model[onnxModelOpsetImport] {
+		fields := decodeProto(t, o.bytes)
+		opsets[fields.str(onnxOpsetDomain)] = int64(fields[onnxOpsetVersion][0].varint)
+	}
+	graph := decodeProto(t, model[onnxModelGraph][0].bytes)
+	if len(graph[onnxGraphNode]) != 1 {
+		t.Fatal("expected one node in the graph, got", len(graph[onnxGraphNode]))
+	}
+	return decodeProto(t, graph[onnxGraphNode][0].bytes), opsets
+}
+
+func TestONNXClassifierMatchesForest(t *testing.T) {
+	model := testPMMLModel()
+	encoded, err := toONNX(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, opsets := decodeONNXNode(t, encoded)
+	if node.str(onnxNodeOpType) != "TreeEnsembleClassifier" || node.str(onnxNodeDomain) != onnxMLDomain {
+		t.Fatal("node", node.str(onnxNodeOpType), node.str(onnxNodeDomain))
+	}
+	if opsets[onnxMLDomain] == 0 {
+		t.Fatal("missing the", onnxMLDomain, "opset import")
+	}
+	attrs := onnxAttributes(t, node)
+	labels := attrs["classlabels_strings"].strs()
+	if len(labels) != len(model.IndexedVariables) {
+		t.Fatal("labels", labels)
+	}
+
+	e := newONNXEnsemble(attrs, "class", len(labels))
+	if e.nTrees != len(model.Trees) {
+		t.Fatal("expected", len(model.Trees), "trees, got", e.nTrees)
+	}
+	for _, row := range randomRows(rand.New(rand.NewSource(5)), 300, 3) {
+		scores := e.scores(t, row)
+		proba := baggingProba(model.Flat, row, len(labels))
+		for i := range proba {
+			if math.Abs(float64(scores[i]-proba[i])) > 1e-5 {
+				t.Fatal("scores", scores, "expected", proba)
+			}
+		}
+		// the label output is the highest score, ties going to the first
+		best := 0
+		for i, s := range scores {
+			if s > scores[best] {
+				best = i
+			}
+		}
+		if best != int(baggingPredict(model.Flat, row)) {
+			t.Fatal("label", labels[best], "expected", labels[int(baggingPredict(model.Flat, row))])
+		}
+	}
+}
+
+func TestONNXRegressor(t *testing.T) {
+	model := testPMMLModel()
+	model.Meta.Task = taskRegression
+	encoded, err := toONNX(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, _ := decodeONNXNode(t, encoded)
+	if node.str(onnxNodeOpType) != "TreeEnsembleRegressor" {
+		t.Fatal("node", node.str(onnxNodeOpType))
+	}
+	attrs := onnxAttributes(t, node)
+	if attrs["aggregate_function"].str(onnxAttrS) != "AVERAGE" {
+		t.Fatal("aggregate_function", attrs["aggregate_function"].str(onnxAttrS))
+	}
+
+	e := newONNXEnsemble(attrs, "target", 1)
+	for _, row := range randomRows(rand.New(rand.NewSource(6)), 300, 3) {
+		var sum float32
+		for _, tree := range model.Trees {
+			sum += tree.predict(row)
+		}
+		// AVERAGE divides the summed leaf values by the number of trees
+		got := e.scores(t, row)[0] / float32(e.nTrees)
+		if math.Abs(float64(got-sum/float32(len(model.Trees)))) > 1e-5 {
+			t.Fatal("predicted", got, "expected", sum/float32(len(model.Trees)))
+		}
+	}
+}
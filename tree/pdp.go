@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pdpPoint is the model's average probability of each label with the swept
+// features set to Values in every row, and each row's own probabilities for
+// ICE curves
+type pdpPoint struct {
+	Values []float32
+	Mean   []float32
+	Rows   [][]float32
+}
+
+/*
+partialDependence writes the partial dependence of the -model's probabilities,
+or a regression model's prediction, on one or two -features to -output as csv. Each feature is swept over -points
+quantiles of its values in the -data rows, while the other columns keep the
+values they have in each row. With two features, every pair of their values is
+a point.
+
+With -ice, the curve of every row follows the average, which has "mean" in the
+row column.
+*/
+func partialDependence() {
+	if *charMode {
+		fmt.Println("-pdp is not supported with -charmode")
+		return
+	}
+	if *pdpPoints < 2 {
+		fmt.Println("-points should be at least 2")
+		return
+	}
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+	if loaded.Meta.Task == taskAnomaly {
+		fmt.Println("-pdp is not supported for isolation forests")
+		return
+	}
+	if len(loaded.Targets) > 0 {
+		fmt.Println("-pdp is not supported for multi-output forests")
+		return
+	}
+	if loaded.Meta.Columns == 0 {
+		fmt.Println("-pdp needs a model that saved its column count; train it again")
+		return
+	}
+	setColumnGlobals(loaded.Meta.Columns)
+
+	header, rows, _, err := readFeatureRows(*dataFile)
+	if err != nil {
+		fmt.Println("-data:", err)
+		return
+	}
+	names := loaded.Meta.ColumnNames
+	if names == nil {
+		names = header
+	}
+
+	features, err := parseFeatureList(*pdpFeatures, names, lastColumnIndex)
+	if err != nil {
+		fmt.Println("-features:", err)
+		return
+	}
+	if len(features) > 2 {
+		fmt.Println("-features should be one or two feature indexes or column names")
+		return
+	}
+	var grids [][]float32
+	for _, f := range features {
+		values := make([]float32, len(rows))
+		for i, row := range rows {
+			values[i] = row[f]
+		}
+		grids = append(grids, quantileGrid(values, *pdpPoints))
+	}
+
+	var out io.Writer = os.Stdout
+	if *batchOutput != "" {
+		f, err := os.Create(*batchOutput)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	// a regression model's one output is its prediction
+	outputs := []string{"prediction"}
+	if loaded.Meta.Task != taskRegression {
+		outputs = nil
+		for _, label := range loaded.IndexedVariables {
+			outputs = append(outputs, "p_"+label)
+		}
+	}
+	points := sweep(loaded.Flat, len(outputs), rows, features, grids, *pdpICE)
+	err = writePDP(out, points, features, names, outputs, *pdpICE)
+	if err != nil {
+		panic(err)
+	}
+	if *batchOutput != "" {
+		fmt.Println("Wrote", len(points), "points over", len(rows), "rows to", *batchOutput)
+	}
+}
+
+// parseFeatureList parses comma separated feature indexes or column names
+func parseFeatureList(s string, names []string, features int) (indexes []int, err error) {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		ix, err := strconv.Atoi(part)
+		if err != nil {
+			ix = -1
+			for i, name := range names {
+				if i < features && name == part {
+					ix = i
+				}
+			}
+			if ix == -1 {
+				return nil, fmt.Errorf("no feature column named %q", part)
+			}
+		}
+		if ix < 0 || ix >= features {
+			return nil, fmt.Errorf("feature %d is not between 0 and %d", ix, features-1)
+		}
+		indexes = append(indexes, ix)
+	}
+	return indexes, nil
+}
+
+// quantileGrid is up to points evenly spaced quantiles of values, from the
+// smallest to the largest, without repeats
+func quantileGrid(values []float32, points int) (grid []float32) {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float32(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i := 0; i < points; i++ {
+		v := sorted[i*(len(sorted)-1)/(points-1)]
+		if len(grid) == 0 || v != grid[len(grid)-1] {
+			grid = append(grid, v)
+		}
+	}
+	return grid
+}
+
+// sweep predicts every row with the features set to each combination of
+// their grid values, in parallel
+func sweep(forest *flatForest, labels int, rows []datarow, features []int, grids [][]float32, ice bool) (points []*pdpPoint) {
+	combos := [][]float32{nil}
+	for _, grid := range grids {
+		var next [][]float32
+		for _, combo := range combos {
+			for _, v := range grid {
+				next = append(next, append(append([]float32(nil), combo...), v))
+			}
+		}
+		combos = next
+	}
+
+	points = make([]*pdpPoint, len(combos))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go (func() {
+			defer wg.Done()
+			row := make(datarow, columnsPerRow)
+			for i := range jobs {
+				p := &pdpPoint{Values: combos[i], Mean: make([]float32, labels)}
+				for _, original := range rows {
+					copy(row, original)
+					for j, f := range features {
+						row[f] = p.Values[j]
+					}
+					proba := baggingProba(forest, row, labels)
+					for label, pr := range proba {
+						p.Mean[label] += pr / float32(len(rows))
+					}
+					if ice {
+						p.Rows = append(p.Rows, proba)
+					}
+				}
+				points[i] = p
+			}
+		})()
+	}
+	for i := range combos {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return points
+}
+
+// writePDP writes the points as csv, with a column for each swept feature
+// and each of the outputs
+func writePDP(out io.Writer, points []*pdpPoint, features []int, names []string, outputs []string, ice bool) error {
+	writer := csv.NewWriter(out)
+	var header []string
+	if ice {
+		header = append(header, "row")
+	}
+	for _, f := range features {
+		name := "x" + strconv.Itoa(f)
+		if f < len(names) {
+			name = names[f]
+		}
+		header = append(header, name)
+	}
+	header = append(header, outputs...)
+	writer.Write(header)
+
+	line := func(row string, values []float32, proba []float32) {
+		var record []string
+		if ice {
+			record = append(record, row)
+		}
+		for _, v := range values {
+			record = append(record, strconv.FormatFloat(float64(v), 'g', -1, 32))
+		}
+		for _, p := range proba {
+			record = append(record, strconv.FormatFloat(float64(p), 'g', 4, 32))
+		}
+		writer.Write(record)
+	}
+	for _, p := range points {
+		line("mean", p.Values, p.Mean)
+	}
+	if ice && len(points) > 0 {
+		for r := range points[0].Rows {
+			for _, p := range points {
+				line(strconv.Itoa(r), p.Values, p.Rows[r])
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQuantileGrid(t *testing.T) {
+	grid := quantileGrid([]float32{5, 1, 3, 2, 4}, 3)
+	if !reflect.DeepEqual(grid, []float32{1, 3, 5}) {
+		t.Fatal("grid", grid)
+	}
+	grid = quantileGrid([]float32{2, 2, 2, 7}, 10)
+	if !reflect.DeepEqual(grid, []float32{2, 7}) {
+		t.Fatal("expected repeats to be dropped, got", grid)
+	}
+}
+
+func TestParseFeatureList(t *testing.T) {
+	names := []string{"width", "height", "depth", "size"}
+	features, err := parseFeatureList("2, height", names, 3)
+	if err != nil || !reflect.DeepEqual(features, []int{2, 1}) {
+		t.Fatal(features, err)
+	}
+	if _, err := parseFeatureList("size", names, 3); err == nil {
+		t.Fatal("expected the predicted column to not be a feature")
+	}
+	if _, err := parseFeatureList("3", nil, 3); err == nil {
+		t.Fatal("expected an out of range feature to fail")
+	}
+}
+
+func TestPartialDependence(t *testing.T) {
+	model := inspectTestModel()
+	setColumnGlobals(model.Meta.Columns)
+	rows := []datarow{{0, 1, 0, 0}, {2, 3, -4, 0}, {2, 3, 0, 0}}
+
+	// height < 2.5 is small, no matter the other features
+	points := sweep(model.Flat, 2, rows, []int{1}, [][]float32{{1, 3}}, true)
+	if !reflect.DeepEqual(points[0].Mean, []float32{1, 0}) {
+		t.Fatal("low height", points[0].Mean)
+	}
+	if len(points[1].Rows) != 3 || !reflect.DeepEqual(points[1].Rows[0], []float32{0, 1}) {
+		t.Fatal("ICE rows", points[1].Rows)
+	}
+	if math.Abs(float64(points[1].Mean[0])-1.0/3) > 1e-6 {
+		t.Fatal("high height", points[1].Mean)
+	}
+
+	// every pair of the two features' values is a point
+	points = sweep(model.Flat, 2, rows, []int{1, 0}, [][]float32{{1, 3}, {0, 2, 4}}, false)
+	if len(points) != 6 || !reflect.DeepEqual(points[5].Values, []float32{3, 4}) || points[5].Rows != nil {
+		t.Fatal("grid points", len(points), points[5])
+	}
+
+	var buf bytes.Buffer
+	points = sweep(model.Flat, 2, rows, []int{1}, [][]float32{{1, 3}}, true)
+	err := writePDP(&buf, points, []int{1}, model.Meta.ColumnNames, []string{"p_small", "p_big"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1+2+3*2 || lines[0] != "row,height,p_small,p_big" || lines[1] != "mean,1,1,0" || lines[3] != "0,1,1,0" {
+		t.Fatal("csv\n" + buf.String())
+	}
+}
@@ -0,0 +1,276 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: pinepb/pine.proto
+
+package pinepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PredictRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Features      []float32              `protobuf:"fixed32,1,rep,packed,name=features,proto3" json:"features,omitempty"`
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PredictRequest) Reset() {
+	*x = PredictRequest{}
+	mi := &file_pinepb_pine_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PredictRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PredictRequest) ProtoMessage() {}
+
+func (x *PredictRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pinepb_pine_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PredictRequest.ProtoReflect.Descriptor instead.
+func (*PredictRequest) Descriptor() ([]byte, []int) {
+	return file_pinepb_pine_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PredictRequest) GetFeatures() []float32 {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+func (x *PredictRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type PredictResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Label         string                 `protobuf:"bytes,1,opt,name=label,proto3" json:"label,omitempty"`
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PredictResponse) Reset() {
+	*x = PredictResponse{}
+	mi := &file_pinepb_pine_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PredictResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PredictResponse) ProtoMessage() {}
+
+func (x *PredictResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pinepb_pine_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PredictResponse.ProtoReflect.Descriptor instead.
+func (*PredictResponse) Descriptor() ([]byte, []int) {
+	return file_pinepb_pine_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PredictResponse) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *PredictResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type ProbaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Label         string                 `protobuf:"bytes,1,opt,name=label,proto3" json:"label,omitempty"`
+	Probabilities map[string]float32     `protobuf:"bytes,2,rep,name=probabilities,proto3" json:"probabilities,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed32,2,opt,name=value"`
+	Id            string                 `protobuf:"bytes,3,opt,name=id,proto3" json:"id,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProbaResponse) Reset() {
+	*x = ProbaResponse{}
+	mi := &file_pinepb_pine_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProbaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProbaResponse) ProtoMessage() {}
+
+func (x *ProbaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pinepb_pine_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProbaResponse.ProtoReflect.Descriptor instead.
+func (*ProbaResponse) Descriptor() ([]byte, []int) {
+	return file_pinepb_pine_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ProbaResponse) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *ProbaResponse) GetProbabilities() map[string]float32 {
+	if x != nil {
+		return x.Probabilities
+	}
+	return nil
+}
+
+func (x *ProbaResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ProbaResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_pinepb_pine_proto protoreflect.FileDescriptor
+
+const file_pinepb_pine_proto_rawDesc = "" +
+	"\n" +
+	"\x11pinepb/pine.proto\x12\x04pine\"<\n" +
+	"\x0ePredictRequest\x12\x1a\n" +
+	"\bfeatures\x18\x01 \x03(\x02R\bfeatures\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\"7\n" +
+	"\x0fPredictResponse\x12\x14\n" +
+	"\x05label\x18\x01 \x01(\tR\x05label\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\"\xdb\x01\n" +
+	"\rProbaResponse\x12\x14\n" +
+	"\x05label\x18\x01 \x01(\tR\x05label\x12L\n" +
+	"\rprobabilities\x18\x02 \x03(\v2&.pine.ProbaResponse.ProbabilitiesEntryR\rprobabilities\x12\x0e\n" +
+	"\x02id\x18\x03 \x01(\tR\x02id\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x1a@\n" +
+	"\x12ProbabilitiesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x02R\x05value:\x028\x012\xbe\x01\n" +
+	"\tPredictor\x126\n" +
+	"\aPredict\x12\x14.pine.PredictRequest\x1a\x15.pine.PredictResponse\x129\n" +
+	"\fPredictProba\x12\x14.pine.PredictRequest\x1a\x13.pine.ProbaResponse\x12>\n" +
+	"\rPredictStream\x12\x14.pine.PredictRequest\x1a\x13.pine.ProbaResponse(\x010\x01B%Z#github.com/ruffrey/pine/tree/pinepbb\x06proto3"
+
+var (
+	file_pinepb_pine_proto_rawDescOnce sync.Once
+	file_pinepb_pine_proto_rawDescData []byte
+)
+
+func file_pinepb_pine_proto_rawDescGZIP() []byte {
+	file_pinepb_pine_proto_rawDescOnce.Do(func() {
+		file_pinepb_pine_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pinepb_pine_proto_rawDesc), len(file_pinepb_pine_proto_rawDesc)))
+	})
+	return file_pinepb_pine_proto_rawDescData
+}
+
+var file_pinepb_pine_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_pinepb_pine_proto_goTypes = []any{
+	(*PredictRequest)(nil),  // 0: pine.PredictRequest
+	(*PredictResponse)(nil), // 1: pine.PredictResponse
+	(*ProbaResponse)(nil),   // 2: pine.ProbaResponse
+	nil,                     // 3: pine.ProbaResponse.ProbabilitiesEntry
+}
+var file_pinepb_pine_proto_depIdxs = []int32{
+	3, // 0: pine.ProbaResponse.probabilities:type_name -> pine.ProbaResponse.ProbabilitiesEntry
+	0, // 1: pine.Predictor.Predict:input_type -> pine.PredictRequest
+	0, // 2: pine.Predictor.PredictProba:input_type -> pine.PredictRequest
+	0, // 3: pine.Predictor.PredictStream:input_type -> pine.PredictRequest
+	1, // 4: pine.Predictor.Predict:output_type -> pine.PredictResponse
+	2, // 5: pine.Predictor.PredictProba:output_type -> pine.ProbaResponse
+	2, // 6: pine.Predictor.PredictStream:output_type -> pine.ProbaResponse
+	4, // [4:7] is the sub-list for method output_type
+	1, // [1:4] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_pinepb_pine_proto_init() }
+func file_pinepb_pine_proto_init() {
+	if File_pinepb_pine_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pinepb_pine_proto_rawDesc), len(file_pinepb_pine_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pinepb_pine_proto_goTypes,
+		DependencyIndexes: file_pinepb_pine_proto_depIdxs,
+		MessageInfos:      file_pinepb_pine_proto_msgTypes,
+	}.Build()
+	File_pinepb_pine_proto = out.File
+	file_pinepb_pine_proto_goTypes = nil
+	file_pinepb_pine_proto_depIdxs = nil
+}
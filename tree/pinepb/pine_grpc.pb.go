@@ -0,0 +1,192 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: pinepb/pine.proto
+
+package pinepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Predictor_Predict_FullMethodName       = "/pine.Predictor/Predict"
+	Predictor_PredictProba_FullMethodName  = "/pine.Predictor/PredictProba"
+	Predictor_PredictStream_FullMethodName = "/pine.Predictor/PredictStream"
+)
+
+// PredictorClient is the client API for Predictor service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PredictorClient interface {
+	Predict(ctx context.Context, in *PredictRequest, opts ...grpc.CallOption) (*PredictResponse, error)
+	PredictProba(ctx context.Context, in *PredictRequest, opts ...grpc.CallOption) (*ProbaResponse, error)
+	PredictStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[PredictRequest, ProbaResponse], error)
+}
+
+type predictorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPredictorClient(cc grpc.ClientConnInterface) PredictorClient {
+	return &predictorClient{cc}
+}
+
+func (c *predictorClient) Predict(ctx context.Context, in *PredictRequest, opts ...grpc.CallOption) (*PredictResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PredictResponse)
+	err := c.cc.Invoke(ctx, Predictor_Predict_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *predictorClient) PredictProba(ctx context.Context, in *PredictRequest, opts ...grpc.CallOption) (*ProbaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProbaResponse)
+	err := c.cc.Invoke(ctx, Predictor_PredictProba_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *predictorClient) PredictStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[PredictRequest, ProbaResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Predictor_ServiceDesc.Streams[0], Predictor_PredictStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PredictRequest, ProbaResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Predictor_PredictStreamClient = grpc.BidiStreamingClient[PredictRequest, ProbaResponse]
+
+// PredictorServer is the server API for Predictor service.
+// All implementations must embed UnimplementedPredictorServer
+// for forward compatibility.
+type PredictorServer interface {
+	Predict(context.Context, *PredictRequest) (*PredictResponse, error)
+	PredictProba(context.Context, *PredictRequest) (*ProbaResponse, error)
+	PredictStream(grpc.BidiStreamingServer[PredictRequest, ProbaResponse]) error
+	mustEmbedUnimplementedPredictorServer()
+}
+
+// UnimplementedPredictorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPredictorServer struct{}
+
+func (UnimplementedPredictorServer) Predict(context.Context, *PredictRequest) (*PredictResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Predict not implemented")
+}
+func (UnimplementedPredictorServer) PredictProba(context.Context, *PredictRequest) (*ProbaResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method PredictProba not implemented")
+}
+func (UnimplementedPredictorServer) PredictStream(grpc.BidiStreamingServer[PredictRequest, ProbaResponse]) error {
+	return status.Error(codes.Unimplemented, "method PredictStream not implemented")
+}
+func (UnimplementedPredictorServer) mustEmbedUnimplementedPredictorServer() {}
+func (UnimplementedPredictorServer) testEmbeddedByValue()                   {}
+
+// UnsafePredictorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PredictorServer will
+// result in compilation errors.
+type UnsafePredictorServer interface {
+	mustEmbedUnimplementedPredictorServer()
+}
+
+func RegisterPredictorServer(s grpc.ServiceRegistrar, srv PredictorServer) {
+	// If the following call panics, it indicates UnimplementedPredictorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Predictor_ServiceDesc, srv)
+}
+
+func _Predictor_Predict_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PredictRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PredictorServer).Predict(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Predictor_Predict_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PredictorServer).Predict(ctx, req.(*PredictRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Predictor_PredictProba_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PredictRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PredictorServer).PredictProba(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Predictor_PredictProba_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PredictorServer).PredictProba(ctx, req.(*PredictRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Predictor_PredictStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PredictorServer).PredictStream(&grpc.GenericServerStream[PredictRequest, ProbaResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Predictor_PredictStreamServer = grpc.BidiStreamingServer[PredictRequest, ProbaResponse]
+
+// Predictor_ServiceDesc is the grpc.ServiceDesc for Predictor service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Predictor_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pine.Predictor",
+	HandlerType: (*PredictorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Predict",
+			Handler:    _Predictor_Predict_Handler,
+		},
+		{
+			MethodName: "PredictProba",
+			Handler:    _Predictor_PredictProba_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PredictStream",
+			Handler:       _Predictor_PredictStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "pinepb/pine.proto",
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+PMML 4.4 export, as a MiningModel whose Segmentation holds one TreeModel per
+tree. Classification forests vote with majorityVote, or weightedMajorityVote
+when their trees have weights, and regression forests average.
+
+Split values are written as the float64 of the float32, so a consumer
+comparing in either precision goes the same way as Tree.predict.
+*/
+
+const pmmlNamespace = "http://www.dmg.org/PMML-4_4"
+
+type pmmlDocument struct {
+	XMLName        xml.Name           `xml:"PMML"`
+	Namespace      string             `xml:"xmlns,attr"`
+	Version        string             `xml:"version,attr"`
+	Header         pmmlHeader         `xml:"Header"`
+	DataDictionary pmmlDataDictionary `xml:"DataDictionary"`
+	MiningModel    pmmlMiningModel    `xml:"MiningModel"`
+}
+
+type pmmlHeader struct {
+	Description string          `xml:"description,attr"`
+	Application pmmlApplication `xml:"Application"`
+	Timestamp   string          `xml:"Timestamp,omitempty"`
+}
+
+type pmmlApplication struct {
+	Name string `xml:"name,attr"`
+}
+
+type pmmlDataDictionary struct {
+	NumberOfFields int             `xml:"numberOfFields,attr"`
+	DataFields     []pmmlDataField `xml:"DataField"`
+}
+
+type pmmlDataField struct {
+	Name     string      `xml:"name,attr"`
+	Optype   string      `xml:"optype,attr"`
+	DataType string      `xml:"dataType,attr"`
+	Values   []pmmlValue `xml:"Value"`
+}
+
+type pmmlValue struct {
+	Value string `xml:"value,attr"`
+}
+
+type pmmlMiningSchema struct {
+	Fields []pmmlMiningField `xml:"MiningField"`
+}
+
+type pmmlMiningField struct {
+	Name      string `xml:"name,attr"`
+	UsageType string `xml:"usageType,attr,omitempty"`
+}
+
+type pmmlMiningModel struct {
+	FunctionName string           `xml:"functionName,attr"`
+	MiningSchema pmmlMiningSchema `xml:"MiningSchema"`
+	Segmentation pmmlSegmentation `xml:"Segmentation"`
+}
+
+type pmmlSegmentation struct {
+	MultipleModelMethod string        `xml:"multipleModelMethod,attr"`
+	Segments            []pmmlSegment `xml:"Segment"`
+}
+
+type pmmlSegment struct {
+	ID        string        `xml:"id,attr"`
+	Weight    string        `xml:"weight,attr,omitempty"`
+	True      *pmmlTrue     `xml:"True"`
+	TreeModel pmmlTreeModel `xml:"TreeModel"`
+}
+
+type pmmlTreeModel struct {
+	FunctionName        string           `xml:"functionName,attr"`
+	SplitCharacteristic string           `xml:"splitCharacteristic,attr"`
+	MiningSchema        pmmlMiningSchema `xml:"MiningSchema"`
+	Node                *pmmlNode        `xml:"Node"`
+}
+
+type pmmlTrue struct{}
+
+type pmmlNode struct {
+	ID        string               `xml:"id,attr,omitempty"`
+	Score     string               `xml:"score,attr,omitempty"`
+	True      *pmmlTrue            `xml:"True"`
+	Predicate *pmmlSimplePredicate `xml:"SimplePredicate"`
+	Nodes     []*pmmlNode          `xml:"Node"`
+}
+
+type pmmlSimplePredicate struct {
+	Field    string `xml:"field,attr"`
+	Operator string `xml:"operator,attr"`
+	Value    string `xml:"value,attr"`
+}
+
+// exportPMML writes the -model as PMML next to it, or to -save
+func exportPMML() {
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(len(loaded.Trees), "Trees loaded")
+
+	doc, err := toPMML(&loaded)
+	if err != nil {
+		panic(err)
+	}
+	buf, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	var outFile string
+	if *saveTo != "" {
+		outFile = *saveTo
+	} else {
+		base := filepath.Base(*modelFile)
+		outFile = strings.Replace(base, filepath.Ext(base), ".pmml", 1)
+	}
+	err = ioutil.WriteFile(outFile, append([]byte(xml.Header), buf...), os.ModePerm)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Wrote PMML to", outFile)
+}
+
+// modelFieldNames returns the feature column names and the predicted column
+// name, from the -header row when the model was trained with one.
+func modelFieldNames(model *saveFormat) (features []string, target string, err error) {
+	n := model.Meta.Columns - 1
+	if n < 0 {
+		// no saved column count, so go by the highest feature used
+		for _, f := range model.Flat.Feature {
+			if int(f) >= n {
+				n = int(f) + 1
+			}
+		}
+	}
+	names := model.Meta.ColumnNames
+	if len(names) != n+1 {
+		names = nil
+	}
+	seen := make(map[string]bool)
+	for i := 0; i <= n; i++ {
+		name := "x" + strconv.Itoa(i)
+		if i == n {
+			name = "y"
+		}
+		if names != nil {
+			name = strings.TrimSpace(names[i])
+		}
+		if seen[name] {
+			return nil, "", fmt.Errorf("column name %q is used twice", name)
+		}
+		seen[name] = true
+		if i < n {
+			features = append(features, name)
+		} else {
+			target = name
+		}
+	}
+	return features, target, nil
+}
+
+func toPMML(model *saveFormat) (doc *pmmlDocument, err error) {
+	if model.Flat.Additive {
+		return nil, fmt.Errorf("cannot export an additive forest to PMML")
+	}
+	if model.Meta.Task == taskAnomaly {
+		return nil, fmt.Errorf("cannot export an isolation forest to PMML")
+	}
+	if model.Flat.Outputs > 0 {
+		return nil, fmt.Errorf("cannot export a multi-output forest to PMML")
+	}
+	if model.Flat.ObliqueStart != nil {
+		return nil, fmt.Errorf("cannot export a forest with oblique splits to PMML")
+	}
+	features, target, err := modelFieldNames(model)
+	if err != nil {
+		return nil, err
+	}
+	regression := model.Meta.Task == taskRegression
+
+	doc = &pmmlDocument{
+		Namespace: pmmlNamespace,
+		Version:   "4.4",
+		Header: pmmlHeader{
+			Description: fmt.Sprintf("pine random decision forest of %d trees", len(model.Trees)),
+			Application: pmmlApplication{Name: "pine"},
+		},
+	}
+	if !model.Meta.Created.IsZero() {
+		doc.Header.Timestamp = model.Meta.Created.Format(time.RFC3339)
+	}
+
+	schema := pmmlMiningSchema{}
+	for _, name := range features {
+		doc.DataDictionary.DataFields = append(doc.DataDictionary.DataFields, pmmlDataField{
+			Name: name, Optype: "continuous", DataType: "float",
+		})
+		schema.Fields = append(schema.Fields, pmmlMiningField{Name: name})
+	}
+	targetField := pmmlDataField{Name: target, Optype: "categorical", DataType: "string"}
+	if regression {
+		targetField.Optype = "continuous"
+		targetField.DataType = "double"
+	} else {
+		for _, label := range model.IndexedVariables {
+			targetField.Values = append(targetField.Values, pmmlValue{Value: label})
+		}
+	}
+	doc.DataDictionary.DataFields = append(doc.DataDictionary.DataFields, targetField)
+	doc.DataDictionary.NumberOfFields = len(doc.DataDictionary.DataFields)
+	schema.Fields = append(schema.Fields, pmmlMiningField{Name: target, UsageType: "target"})
+
+	functionName := "classification"
+	method := "majorityVote"
+	if model.Flat.Weights != nil {
+		method = "weightedMajorityVote"
+	}
+	if regression {
+		functionName = "regression"
+		method = "average"
+	}
+	doc.MiningModel = pmmlMiningModel{
+		FunctionName: functionName,
+		MiningSchema: schema,
+		Segmentation: pmmlSegmentation{MultipleModelMethod: method},
+	}
+
+	score := func(terminal float32) string {
+		if regression {
+			return strconv.FormatFloat(float64(terminal), 'g', -1, 64)
+		}
+		return model.IndexedVariables[int(terminal)]
+	}
+	for i, t := range model.Trees {
+		nodeID := 0
+		var toNode func(t *Tree) *pmmlNode
+		// the root is always true, and each split is a pair of children
+		// with opposite predicates
+		toNode = func(t *Tree) *pmmlNode {
+			field := features[int(t.VariableIndex)]
+			value := strconv.FormatFloat(float64(t.ValueIndex), 'g', -1, 64)
+			left := &pmmlNode{Predicate: &pmmlSimplePredicate{Field: field, Operator: "lessThan", Value: value}}
+			right := &pmmlNode{Predicate: &pmmlSimplePredicate{Field: field, Operator: "greaterOrEqual", Value: value}}
+			for _, side := range []struct {
+				node     *pmmlNode
+				child    *Tree
+				terminal float32
+			}{{left, t.LeftNode, t.LeftTerminal}, {right, t.RightNode, t.RightTerminal}} {
+				nodeID++
+				side.node.ID = strconv.Itoa(nodeID)
+				if side.child == nil {
+					side.node.Score = score(side.terminal)
+				} else {
+					side.node.Nodes = toNode(side.child).Nodes
+				}
+			}
+			return &pmmlNode{Nodes: []*pmmlNode{left, right}}
+		}
+		root := toNode(t)
+		root.ID = "0"
+		root.True = &pmmlTrue{}
+
+		segment := pmmlSegment{
+			ID:   strconv.Itoa(i + 1),
+			True: &pmmlTrue{},
+			TreeModel: pmmlTreeModel{
+				FunctionName:        functionName,
+				SplitCharacteristic: "binarySplit",
+				MiningSchema:        schema,
+				Node:                root,
+			},
+		}
+		if model.Flat.Weights != nil {
+			segment.Weight = strconv.FormatFloat(float64(model.Flat.Weights[i]), 'g', -1, 32)
+		}
+		doc.MiningModel.Segmentation.Segments = append(doc.MiningModel.Segmentation.Segments, segment)
+	}
+	return doc, nil
+}
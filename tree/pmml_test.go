@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+const (
+	// a handwritten schema for the elements pine writes, not the official one
+	pmmlSubsetXSD = "testdata/pmml-subset.xsd"
+	// the official schema, when `make pmml-xsd` has downloaded it
+	pmmlXSD = "testdata/pmml-4-4.xsd"
+)
+
+func testPMMLModel() *saveFormat {
+	r := rand.New(rand.NewSource(3))
+	var trees []*Tree
+	for i := 0; i < 10; i++ {
+		trees = append(trees, randomTree(r, 1+r.Intn(5), 3, 3))
+	}
+	return &saveFormat{
+		Trees:            trees,
+		Flat:             flatten(trees),
+		IndexedVariables: []string{"a", "b", "c"},
+		Meta:             modelMeta{Columns: 4, ColumnNames: []string{"f0", "f1", "f2", "label"}},
+	}
+}
+
+// pmmlPredictTree scores a row with a TreeModel the way a PMML consumer would
+func pmmlPredictTree(t *testing.T, node *pmmlNode, fields map[string]int, row datarow) string {
+	for len(node.Nodes) > 0 {
+		var next *pmmlNode
+		for _, child := range node.Nodes {
+			p := child.Predicate
+			value, err := strconv.ParseFloat(p.Value, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			x := float64(row[fields[p.Field]])
+			if (p.Operator == "lessThan" && x < value) || (p.Operator == "greaterOrEqual" && x >= value) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			t.Fatal("no true child for node", node.ID)
+		}
+		node = next
+	}
+	return node.Score
+}
+
+func TestPMMLMatchesTrees(t *testing.T) {
+	model := testPMMLModel()
+	doc, err := toPMML(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := xml.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded pmmlDocument
+	if err := xml.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	mm := decoded.MiningModel
+	if mm.FunctionName != "classification" || mm.Segmentation.MultipleModelMethod != "majorityVote" {
+		t.Fatal("mining model", mm.FunctionName, mm.Segmentation.MultipleModelMethod)
+	}
+	if len(mm.Segmentation.Segments) != len(model.Trees) {
+		t.Fatal("expected a segment per tree, got", len(mm.Segmentation.Segments))
+	}
+	fields := make(map[string]int)
+	for i, f := range decoded.DataDictionary.DataFields {
+		fields[f.Name] = i
+	}
+	if len(fields) != 4 || len(decoded.DataDictionary.DataFields[3].Values) != 3 {
+		t.Fatal("data dictionary", decoded.DataDictionary)
+	}
+
+	for _, row := range randomRows(rand.New(rand.NewSource(4)), 200, 3) {
+		for i, tree := range model.Trees {
+			expected := model.IndexedVariables[int(tree.predict(row))]
+			got := pmmlPredictTree(t, mm.Segmentation.Segments[i].TreeModel.Node, fields, row)
+			if got != expected {
+				t.Fatal("tree", i, "scored", got, "expected", expected)
+			}
+		}
+	}
+}
+
+func TestPMMLRegression(t *testing.T) {
+	model := testPMMLModel()
+	model.Meta.Task = taskRegression
+	model.Trees[0].LeftNode = nil
+	model.Trees[0].LeftTerminal = 1.25
+	doc, err := toPMML(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm := doc.MiningModel
+	if mm.FunctionName != "regression" || mm.Segmentation.MultipleModelMethod != "average" {
+		t.Fatal("mining model", mm.FunctionName, mm.Segmentation.MultipleModelMethod)
+	}
+	if score := mm.Segmentation.Segments[0].TreeModel.Node.Nodes[0].Score; score != "1.25" {
+		t.Fatal("regression leaf scored", score)
+	}
+	target := doc.DataDictionary.DataFields[3]
+	if target.Optype != "continuous" || len(target.Values) != 0 {
+		t.Fatal("regression target", target)
+	}
+}
+
+// pmmlValidator checks documents against an xsd with xmllint, skipping the
+// test when it is not installed
+func pmmlValidator(t *testing.T, xsd string) func(name string, doc *pmmlDocument) error {
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		t.Skip("xmllint is not installed")
+	}
+	return func(name string, doc *pmmlDocument) error {
+		buf, err := xml.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), name+".pmml")
+		if err := os.WriteFile(path, append([]byte(xml.Header), buf...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(xmllint, "--noout", "--schema", xsd, path).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, out)
+		}
+		return nil
+	}
+}
+
+func validatePMMLTasks(t *testing.T, validate func(name string, doc *pmmlDocument) error) {
+	for _, task := range []string{taskClassification, taskRegression} {
+		model := testPMMLModel()
+		model.Meta.Task = task
+		doc, err := toPMML(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validate(task, doc); err != nil {
+			t.Fatal(task, "PMML is not valid:", err)
+		}
+	}
+}
+
+// the schema subset only covers what pine writes, so this does not show the
+// output is valid PMML; TestPMMLValidatesAgainstXSD does, when it can
+func TestPMMLMatchesSchemaSubset(t *testing.T) {
+	validate := pmmlValidator(t, pmmlSubsetXSD)
+	validatePMMLTasks(t, validate)
+
+	// and the subset is strict enough to notice a mistake
+	doc, err := toPMML(testPMMLModel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.MiningModel.Segmentation.Segments[0].TreeModel.Node.Nodes[0].Predicate.Operator = "below"
+	if validate("invalid", doc) == nil {
+		t.Fatal("expected an unknown operator to be invalid")
+	}
+}
+
+func TestPMMLValidatesAgainstXSD(t *testing.T) {
+	if _, err := os.Stat(pmmlXSD); err != nil {
+		t.Skip("the full PMML schema is not downloaded; run make pmml-xsd")
+	}
+	validatePMMLTasks(t, pmmlValidator(t, pmmlXSD))
+}
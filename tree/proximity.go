@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+)
+
+/*
+proximities counts how many trees put each pair of rows in the same leaf, as in
+Breiman's random forests. Only pairs that share a leaf are kept, so it stays
+small for large datasets when the leaves are small. The proximity of a pair is
+its count over the number of trees, and of a row with itself 1.
+*/
+type proximities struct {
+	rows   int
+	trees  int
+	counts []map[int]int32 // counts[i][j] for i < j
+}
+
+// forestProximities runs every row through every tree, and counts the rows
+// landing in the same leaves
+func forestProximities(forest *flatForest, rows []datarow) *proximities {
+	p := &proximities{rows: len(rows), trees: len(forest.Roots), counts: make([]map[int]int32, len(rows))}
+	for i := range p.counts {
+		p.counts[i] = make(map[int]int32)
+	}
+	leaves := make(map[int32][]int)
+	for _, root := range forest.Roots {
+		for leaf := range leaves {
+			delete(leaves, leaf)
+		}
+		for i, row := range rows {
+			leaf := forest.leaf(root, row)
+			leaves[leaf] = append(leaves[leaf], i)
+		}
+		for _, same := range leaves {
+			for a, i := range same {
+				for _, j := range same[a+1:] {
+					p.counts[i][j]++
+				}
+			}
+		}
+	}
+	return p
+}
+
+// each calls fn with every pair of different rows that share a leaf, once
+func (p *proximities) each(fn func(i, j int, proximity float64)) {
+	for i, counts := range p.counts {
+		for j, count := range counts {
+			fn(i, j, float64(count)/float64(p.trees))
+		}
+	}
+}
+
+/*
+outlierScores is Breiman's outlier measure for each row: the number of rows
+over the sum of its squared proximities to the other rows of its class, then
+centered on the class's median and divided by the median absolute deviation.
+Rows that are far from the rest of their class get large scores.
+*/
+func (p *proximities) outlierScores(classes []int) (scores []float64) {
+	squares := make([]float64, p.rows)
+	p.each(func(i, j int, proximity float64) {
+		if classes[i] == classes[j] {
+			squares[i] += proximity * proximity
+			squares[j] += proximity * proximity
+		}
+	})
+	scores = make([]float64, p.rows)
+	members := make(map[int][]int)
+	for i, s := range squares {
+		scores[i] = float64(p.rows) / s // +Inf when no row of its class shares a leaf
+		members[classes[i]] = append(members[classes[i]], i)
+	}
+	for _, rows := range members {
+		raw := make([]float64, len(rows))
+		for k, i := range rows {
+			raw[k] = scores[i]
+		}
+		center := median(raw)
+		for k := range raw {
+			raw[k] = math.Abs(raw[k] - center)
+		}
+		spread := median(raw)
+		for _, i := range rows {
+			if math.IsInf(scores[i], 1) {
+				continue
+			}
+			scores[i] -= center
+			if spread > 0 {
+				scores[i] /= spread
+			}
+		}
+	}
+	return scores
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+/*
+mds embeds the rows in dims dimensions by classical multidimensional scaling of
+the distances 1 - proximity. The squared distances are all ones minus
+2*proximity - proximity^2, and double centering cancels the ones, so the
+eigenvectors are found by power iteration on the sparse part alone.
+*/
+func (p *proximities) mds(dims int, r *rand.Rand) (coords [][]float64) {
+	n := p.rows
+	center := func(v []float64) {
+		var mean float64
+		for _, x := range v {
+			mean += x / float64(n)
+		}
+		for i := range v {
+			v[i] -= mean
+		}
+	}
+	// multiply is B v, where B = J S J / 2 and J centers
+	multiply := func(v []float64) []float64 {
+		w := append([]float64(nil), v...)
+		center(w)
+		out := append([]float64(nil), w...) // S is 1 on the diagonal
+		p.each(func(i, j int, proximity float64) {
+			s := 2*proximity - proximity*proximity
+			out[i] += s * w[j]
+			out[j] += s * w[i]
+		})
+		center(out)
+		for i := range out {
+			out[i] /= 2
+		}
+		return out
+	}
+	normalize := func(v []float64) float64 {
+		var norm float64
+		for _, x := range v {
+			norm += x * x
+		}
+		norm = math.Sqrt(norm)
+		if norm > 0 {
+			for i := range v {
+				v[i] /= norm
+			}
+		}
+		return norm
+	}
+
+	coords = make([][]float64, n)
+	for i := range coords {
+		coords[i] = make([]float64, dims)
+	}
+	var found [][]float64
+	for d := 0; d < dims; d++ {
+		v := make([]float64, n)
+		for i := range v {
+			v[i] = r.Float64() - 0.5
+		}
+		var eigenvalue float64
+		for iteration := 0; iteration < 1000; iteration++ {
+			// stay away from the eigenvectors already found
+			for _, e := range found {
+				var dot float64
+				for i := range v {
+					dot += v[i] * e[i]
+				}
+				for i := range v {
+					v[i] -= dot * e[i]
+				}
+			}
+			normalize(v)
+			next := multiply(v)
+			previous := eigenvalue
+			eigenvalue = 0
+			for i := range v {
+				eigenvalue += v[i] * next[i]
+			}
+			v = next
+			if normalize(v) == 0 || math.Abs(eigenvalue-previous) < 1e-12 {
+				break
+			}
+		}
+		found = append(found, v)
+		if eigenvalue > 0 {
+			for i := range v {
+				coords[i][d] = v[i] * math.Sqrt(eigenvalue)
+			}
+		}
+	}
+	return coords
+}
+
+/*
+proximity writes a csv to -output with each -data row's label and outlier
+score, and its -mds coordinates. With -pairs, the proximity of every pair of
+rows sharing a leaf is written there too.
+*/
+func proximity() {
+	if *charMode {
+		fmt.Println("-proximity is not supported with -charmode")
+		return
+	}
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+	if loaded.Flat.Additive {
+		fmt.Println("-proximity needs a forest of voting trees, not an additive one")
+		return
+	}
+	if len(loaded.Targets) > 0 {
+		fmt.Println("-proximity is not supported for multi-output forests")
+		return
+	}
+	if loaded.Meta.Columns == 0 {
+		fmt.Println("-proximity needs a model that saved its column count; train it again")
+		return
+	}
+	setColumnGlobals(loaded.Meta.Columns)
+	_, rows, labels, err := readFeatureRows(*dataFile)
+	if err != nil {
+		fmt.Println("-data:", err)
+		return
+	}
+
+	// rows without the predicted column are all one class
+	classes := make([]int, len(rows))
+	classIndex := make(map[string]int)
+	for i, label := range labels {
+		if _, ok := classIndex[label]; !ok {
+			classIndex[label] = len(classIndex)
+		}
+		classes[i] = classIndex[label]
+	}
+
+	p := forestProximities(loaded.Flat, rows)
+	scores := p.outlierScores(classes)
+	var coords [][]float64
+	if *proximityDims > 0 {
+		coords = p.mds(*proximityDims, rand.New(rand.NewSource(1)))
+	}
+
+	if *proximityPairs != "" {
+		f, err := os.Create(*proximityPairs)
+		if err != nil {
+			panic(err)
+		}
+		err = writeProximityPairs(f, p)
+		f.Close()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if *batchOutput != "" {
+		f, err := os.Create(*batchOutput)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	writer := csv.NewWriter(out)
+	header := []string{"row", "label", "outlier"}
+	for d := 0; d < *proximityDims; d++ {
+		header = append(header, "mds"+strconv.Itoa(d+1))
+	}
+	writer.Write(header)
+	for i := range rows {
+		record := []string{strconv.Itoa(i), labels[i], strconv.FormatFloat(scores[i], 'g', 4, 64)}
+		if coords != nil {
+			for _, c := range coords[i] {
+				record = append(record, strconv.FormatFloat(c, 'g', 4, 64))
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		panic(err)
+	}
+}
+
+// writeProximityPairs writes the pairs of different rows that share a leaf in
+// any tree, as csv with the first row, the second, and their proximity
+func writeProximityPairs(out io.Writer, p *proximities) error {
+	writer := csv.NewWriter(out)
+	writer.Write([]string{"row", "other", "proximity"})
+	for i, counts := range p.counts {
+		others := make([]int, 0, len(counts))
+		for j := range counts {
+			others = append(others, j)
+		}
+		sort.Ints(others)
+		for _, j := range others {
+			proximity := float64(counts[j]) / float64(p.trees)
+			writer.Write([]string{strconv.Itoa(i), strconv.Itoa(j), strconv.FormatFloat(proximity, 'g', 4, 64)})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestForestProximities(t *testing.T) {
+	trees := []*Tree{
+		{VariableIndex: 0, ValueIndex: 5}, // rows below 5 go left
+		{VariableIndex: 1, ValueIndex: 5},
+	}
+	rows := []datarow{{1, 1, 0}, {2, 9, 0}, {8, 9, 0}, {9, 1, 0}}
+	p := forestProximities(flatten(trees), rows)
+	expected := map[[2]int]float64{{0, 1}: 0.5, {1, 2}: 0.5, {2, 3}: 0.5, {0, 3}: 0.5}
+	pairs := 0
+	p.each(func(i, j int, proximity float64) {
+		pairs++
+		if i >= j || expected[[2]int{i, j}] != proximity {
+			t.Fatal("pair", i, j, proximity)
+		}
+	})
+	if pairs != len(expected) {
+		t.Fatal("expected", len(expected), "pairs, got", pairs)
+	}
+
+	var buf bytes.Buffer
+	if err := writeProximityPairs(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "row,other,proximity\n0,1,0.5\n0,3,0.5\n") {
+		t.Fatal("pairs csv\n" + buf.String())
+	}
+}
+
+// twoClusters has rows 0-4 and 5-9 always sharing leaves within their cluster,
+// except for row 4 which only shares half of the trees with its cluster
+func twoClusters() *proximities {
+	p := &proximities{rows: 10, trees: 10, counts: make([]map[int]int32, 10)}
+	for i := range p.counts {
+		p.counts[i] = make(map[int]int32)
+	}
+	for _, cluster := range [][]int{{0, 1, 2, 3, 4}, {5, 6, 7, 8, 9}} {
+		for a, i := range cluster {
+			for _, j := range cluster[a+1:] {
+				p.counts[i][j] = 10
+			}
+		}
+	}
+	for i := 0; i < 4; i++ {
+		p.counts[i][4] = 5
+	}
+	return p
+}
+
+func TestOutlierScores(t *testing.T) {
+	p := twoClusters()
+	scores := p.outlierScores([]int{0, 0, 0, 0, 0, 1, 1, 1, 1, 1})
+	for i, s := range scores {
+		if i != 4 && s > 0 {
+			t.Fatal("row", i, "is not an outlier, but scored", s)
+		}
+	}
+	if scores[4] <= 1 {
+		t.Fatal("expected row 4 to stand out, got", scores)
+	}
+
+	// alone in its class, a row has nothing near it
+	scores = p.outlierScores([]int{0, 0, 0, 0, 2, 1, 1, 1, 1, 1})
+	if !math.IsInf(scores[4], 1) {
+		t.Fatal("expected row 4 to have no proximity to its class, got", scores[4])
+	}
+}
+
+func TestProximityMDS(t *testing.T) {
+	coords := twoClusters().mds(2, rand.New(rand.NewSource(1)))
+	distance := func(i, j int) float64 {
+		return math.Hypot(coords[i][0]-coords[j][0], coords[i][1]-coords[j][1])
+	}
+	if distance(0, 1) > 1e-6 || distance(5, 9) > 1e-6 {
+		t.Fatal("rows which always share leaves should be in the same place", coords)
+	}
+	if distance(0, 5) < 0.5 || distance(0, 4) < 1e-3 || distance(0, 4) > distance(0, 5) {
+		t.Fatal("expected the clusters apart, with row 4 nearer its own", coords)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errNoLeafValues = errors.New("-quantiles needs a forest trained with -regression, which keeps its leaf values")
+
+// meanTerminal is the regression version of toTerminal: the mean of the
+// predicted column
+func meanTerminal(dataSubset []datarow) float32 {
+	if len(dataSubset) == 0 {
+		return 0
+	}
+	var total float64
+	for _, row := range dataSubset {
+		total += float64(row[lastColumnIndex])
+	}
+	return float32(total / float64(len(dataSubset)))
+}
+
+// sumSquaredError is how far values are from their mean, which regression
+// trees split to make as small as possible
+func sumSquaredError(values []float32) (sse float64) {
+	if len(values) == 0 {
+		return 0
+	}
+	var total float64
+	for _, v := range values {
+		total += float64(v)
+	}
+	mean := total / float64(len(values))
+	for _, v := range values {
+		sse += (float64(v) - mean) * (float64(v) - mean)
+	}
+	return sse
+}
+
+// rmseMetric is the root mean squared error of predicted
+func rmseMetric(actual []float32, predicted []float32) float32 {
+	var sq float64
+	for i := range actual {
+		sq += (float64(actual[i]) - float64(predicted[i])) * (float64(actual[i]) - float64(predicted[i]))
+	}
+	return float32(math.Sqrt(sq / float64(len(actual))))
+}
+
+// leafValues is the sorted predicted column, which a regression terminal keeps
+// for quantiles
+func leafValues(dataSubset []datarow) (values []float32) {
+	values = lastColumn(dataSubset)
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+/*
+quantiles estimates each of qs of the value to predict for row, by
+Meinshausen's quantile regression forests. Each tree gives an equal share of
+weight to the training values in the leaf that row reaches, and the quantiles
+are read off the weighted distribution of all of them.
+*/
+func (f *flatForest) quantiles(row datarow, qs []float64) (estimates []float32) {
+	type weightedValue struct {
+		value  float32
+		weight float64
+	}
+	var all []weightedValue
+	var total float64
+	for _, root := range f.Roots {
+		leaf := f.leaf(root, row)
+		values := f.Values[f.ValueStart[leaf]:f.ValueStart[leaf+1]]
+		if len(values) == 0 {
+			continue // no training rows went this way
+		}
+		for _, v := range values {
+			all = append(all, weightedValue{v, 1 / float64(len(values))})
+		}
+		total++
+	}
+	if len(all) == 0 {
+		return make([]float32, len(qs))
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+	for _, q := range qs {
+		// the smallest value with at least q of the weight at or below it
+		target := q * total
+		var cumulative float64
+		i := 0
+		for ; i < len(all)-1; i++ {
+			cumulative += all[i].weight
+			if cumulative >= target-1e-9 {
+				break
+			}
+		}
+		estimates = append(estimates, all[i].value)
+	}
+	return estimates
+}
+
+// interval is the estimates of the lowest and highest of qs
+func interval(qs []float64, estimates []float32) (low float32, high float32) {
+	lowest, highest := 0, 0
+	for i, q := range qs {
+		if q < qs[lowest] {
+			lowest = i
+		}
+		if q > qs[highest] {
+			highest = i
+		}
+	}
+	return estimates[lowest], estimates[highest]
+}
+
+// parseQuantiles parses comma separated quantiles between 0 and 1
+func parseQuantiles(s string) (qs []float64, err error) {
+	for _, part := range strings.Split(s, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+		if q < 0 || q > 1 {
+			return nil, fmt.Errorf("%v is not between 0 and 1", q)
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+// quantileNames are the csv columns and json keys for qs, like q0.05
+func quantileNames(qs []float64) (names []string) {
+	for _, q := range qs {
+		names = append(names, "q"+strconv.FormatFloat(q, 'g', -1, 64))
+	}
+	return names
+}
+
+// formatQuantiles formats each estimate for csv
+func formatQuantiles(estimates []float32) (cols []string) {
+	for _, v := range estimates {
+		cols = append(cols, strconv.FormatFloat(float64(v), 'g', -1, 32))
+	}
+	return cols
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestQuantiles(t *testing.T) {
+	trees := []*Tree{
+		{VariableIndex: 0, ValueIndex: 5, LeftTerminal: 2.5, RightTerminal: 10,
+			LeftValues: []float32{1, 2, 3, 4}, RightValues: []float32{10}},
+		{VariableIndex: 0, ValueIndex: 3, LeftTerminal: 2, RightTerminal: 25,
+			LeftValues: []float32{2}, RightValues: []float32{20, 30}},
+	}
+	forest := flatten(trees)
+	if !forest.Averaged || forest.Labels != 1 || len(forest.ValueStart) != 5 || len(forest.Values) != 8 {
+		t.Fatal("expected a regression forest, got", forest.Averaged, forest.Labels, forest.ValueStart, forest.Values)
+	}
+	if flatten(trees[:0]).ValueStart != nil {
+		t.Fatal("expected no leaf values")
+	}
+
+	row := datarow{1, 0}
+	if mean := regressionPredict(forest, row); mean != 2.25 {
+		t.Fatal("mean", mean)
+	}
+	if proba := baggingProba(forest, row, 0); len(proba) != 1 || proba[0] != 2.25 {
+		t.Fatal("proba", proba)
+	}
+	// the first tree's four values each count a quarter as much as the
+	// second tree's one
+	got := forest.quantiles(row, []float64{0, 0.2, 0.5, 0.9, 1})
+	expected := []float32{1, 2, 2, 4, 4}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatal("quantiles", got, "expected", expected)
+		}
+	}
+	got = forest.quantiles(datarow{4, 0}, []float64{0.1, 0.5, 0.9})
+	if got[0] != 1 || got[1] != 4 || got[2] != 30 {
+		t.Fatal("quantiles", got)
+	}
+	if low, high := interval([]float64{0.9, 0.1}, []float32{30, 1}); low != 1 || high != 30 {
+		t.Fatal("interval", low, high)
+	}
+}
+
+func TestParseQuantiles(t *testing.T) {
+	qs, err := parseQuantiles("0.05, 0.5,0.95")
+	if err != nil || len(qs) != 3 || qs[1] != 0.5 {
+		t.Fatal(qs, err)
+	}
+	if names := quantileNames(qs); names[0] != "q0.05" || names[2] != "q0.95" {
+		t.Fatal(names)
+	}
+	for _, bad := range []string{"", "x", "1.5", "-0.1"} {
+		if _, err := parseQuantiles(bad); err == nil {
+			t.Fatal("expected an error for", bad)
+		}
+	}
+}
+
+func TestRegressionForest(t *testing.T) {
+	rand.Seed(1)
+	defer useStumps(1)()
+	regressionTrees, maxDepth, leafRows = true, 8, 5
+	defer func() { regressionTrees, leafRows = false, 1 }()
+
+	// the value is x, with noise that grows with x
+	r := rand.New(rand.NewSource(4))
+	var rows []datarow
+	for i := 0; i < 600; i++ {
+		x := r.Float32() * 10
+		rows = append(rows, datarow{x, x + float32(r.NormFloat64())*(0.1+0.3*x)})
+	}
+	var trees []*Tree
+	for i := 0; i < 20; i++ {
+		sample := make([]datarow, len(rows))
+		for j := range sample {
+			sample[j] = rows[r.Intn(len(rows))]
+		}
+		tree := getSplit(sample)
+		tree.split(1)
+		trees = append(trees, tree)
+	}
+	forest := flatten(trees)
+	if !forest.Averaged {
+		t.Fatal("expected leaf values")
+	}
+	qs := []float64{0.05, 0.5, 0.95}
+	narrow := forest.quantiles(datarow{1, 0}, qs)
+	wide := forest.quantiles(datarow{9, 0}, qs)
+	if narrow[1] < 0.5 || narrow[1] > 1.5 || wide[1] < 7 || wide[1] > 11.5 {
+		t.Fatal("medians", narrow[1], wide[1])
+	}
+	if !(narrow[0] < narrow[1] && narrow[1] < narrow[2]) {
+		t.Fatal("quantiles out of order", narrow)
+	}
+	if narrow[2]-narrow[0] > (wide[2]-wide[0])/2 {
+		t.Fatal("expected a wider interval where the noise is bigger, got", narrow, wide)
+	}
+	if mean := regressionPredict(forest, datarow{5, 0}); mean < 4.5 || mean > 5.5 {
+		t.Fatal("mean", mean)
+	}
+}
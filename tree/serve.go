@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+/*
+predictionServer serves predictions from a model file, over HTTP and gRPC.
+
+The model is swapped out whole when it is reloaded, and each request holds on
+to the model it started with, so reloading never drops or mixes up requests.
+*/
+type predictionServer struct {
+	path    string
+	lock    sync.RWMutex
+	model   *servedModel
+	modTime time.Time
+}
+
+// servedModel is a loaded model, and what is needed to predict with it without
+// touching the globals used for training
+type servedModel struct {
+	saveFormat
+	features    int // how many feature columns a row should have, or 0 when unknown
+	minFeatures int // the fewest a row can have when features is unknown, from the splits
+	loadedAt    time.Time
+	quantiles   []float64 // to estimate along with each prediction, for -stream
+}
+
+type predictRequest struct {
+	Row  []float32   `json:"row"`
+	Rows [][]float32 `json:"rows"`
+}
+
+type predictResponse struct {
+	Label         string             `json:"label"`
+	Probabilities map[string]float32 `json:"probabilities,omitempty"`
+}
+
+type batchPredictResponse struct {
+	Labels []string `json:"labels"`
+}
+
+type modelInfoResponse struct {
+	Path     string    `json:"path"`
+	LoadedAt time.Time `json:"loadedAt"`
+	Trees    int       `json:"trees"`
+	Features int       `json:"features"`
+	Labels   []string  `json:"labels"`
+	Meta     modelMeta `json:"meta"`
+}
+
+func newPredictionServer(path string) (s *predictionServer, err error) {
+	s = &predictionServer{path: path}
+	err = s.reload()
+	return s, err
+}
+
+// reload loads the model file and starts serving it
+func (s *predictionServer) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	m := &servedModel{loadedAt: time.Now()}
+	err = load(s.path, &m.saveFormat)
+	if err != nil {
+		return err
+	}
+	if len(m.Trees) == 0 {
+		return fmt.Errorf("%s has no trees", s.path)
+	}
+	if m.Meta.CharMode {
+		return fmt.Errorf("%s is a -charmode model, which cannot be served", s.path)
+	}
+	if m.Meta.Task == taskAnomaly {
+		return fmt.Errorf("%s is an isolation forest, which cannot be served", s.path)
+	}
+	if len(m.Targets) > 0 {
+		return fmt.Errorf("%s is a multi-output forest, which cannot be served; use -pred -stream", s.path)
+	}
+	m.features = m.Meta.Columns - 1
+	m.minFeatures = splitFeatures(m.Flat)
+
+	s.lock.Lock()
+	s.model = m
+	s.modTime = info.ModTime()
+	s.lock.Unlock()
+	return nil
+}
+
+// reloadIfChanged reloads the model when the file was modified since it was
+// last loaded
+func (s *predictionServer) reloadIfChanged() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.lock.RLock()
+	changed := !info.ModTime().Equal(s.modTime)
+	s.lock.RUnlock()
+	if !changed {
+		return nil
+	}
+	return s.reload()
+}
+
+func (s *predictionServer) current() *servedModel {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.model
+}
+
+func (s *predictionServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if s.current() == nil {
+			http.Error(w, "no model loaded", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/model", s.handleModel)
+	mux.HandleFunc("/predict", s.handlePredict)
+	mux.HandleFunc("/predict/batch", s.handlePredictBatch)
+	mux.HandleFunc("/proba", s.handleProba)
+	mux.HandleFunc("/explain", s.handleExplain)
+	return mux
+}
+
+func (s *predictionServer) handleModel(w http.ResponseWriter, r *http.Request) {
+	m := s.current()
+	writeJSON(w, modelInfoResponse{
+		Path:     s.path,
+		LoadedAt: m.loadedAt,
+		Trees:    len(m.Trees),
+		Features: m.features,
+		Labels:   m.IndexedVariables,
+		Meta:     m.Meta,
+	})
+}
+
+func (s *predictionServer) handlePredict(w http.ResponseWriter, r *http.Request) {
+	m, rows, ok := s.readRows(w, r, false)
+	if !ok {
+		return
+	}
+	label, _ := m.predict(rows[0])
+	writeJSON(w, predictResponse{Label: label})
+}
+
+func (s *predictionServer) handlePredictBatch(w http.ResponseWriter, r *http.Request) {
+	m, rows, ok := s.readRows(w, r, true)
+	if !ok {
+		return
+	}
+	res := batchPredictResponse{Labels: make([]string, len(rows))}
+	for i, row := range rows {
+		res.Labels[i], _ = m.predict(row)
+	}
+	writeJSON(w, res)
+}
+
+func (s *predictionServer) handleProba(w http.ResponseWriter, r *http.Request) {
+	m, rows, ok := s.readRows(w, r, false)
+	if !ok {
+		return
+	}
+	label, proba := m.predict(rows[0])
+	writeJSON(w, predictResponse{Label: label, Probabilities: m.labelMap(proba)})
+}
+
+func (s *predictionServer) handleExplain(w http.ResponseWriter, r *http.Request) {
+	m, rows, ok := s.readRows(w, r, false)
+	if !ok {
+		return
+	}
+	res := explainRow(&m.saveFormat, rows[0], len(rows[0])-1)
+	writeJSON(w, res)
+}
+
+// readRows decodes the request body into datarows for the current model.
+// When it returns !ok, an error response was already written.
+func (s *predictionServer) readRows(w http.ResponseWriter, r *http.Request, batch bool) (m *servedModel, rows []datarow, ok bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST a json body", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+	var req predictRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	input := req.Rows
+	if !batch {
+		if req.Row == nil {
+			http.Error(w, "row is required", http.StatusBadRequest)
+			return nil, nil, false
+		}
+		input = [][]float32{req.Row}
+	}
+
+	m = s.current()
+	for i, features := range input {
+		row, err := m.toRow(features)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("row %d: %v", i, err), http.StatusBadRequest)
+			return nil, nil, false
+		}
+		rows = append(rows, row)
+	}
+	return m, rows, true
+}
+
+// toRow makes a datarow from the features, with room for the predicted column
+func (m *servedModel) toRow(features []float32) (row datarow, err error) {
+	if m.features > 0 && len(features) != m.features {
+		return nil, fmt.Errorf("has %d features, expected %d", len(features), m.features)
+	}
+	if m.features <= 0 && len(features) < m.minFeatures {
+		return nil, fmt.Errorf("has %d features, expected at least %d", len(features), m.minFeatures)
+	}
+	row = make(datarow, len(features)+1)
+	copy(row, features)
+	return row, nil
+}
+
+// splitFeatures is one more than the highest feature index any node of the
+// forest splits on, so the fewest columns a row needs
+func splitFeatures(f *flatForest) (n int) {
+	for _, feature := range append(append([]int32(nil), f.Feature...), f.ObliqueFeature...) {
+		if int(feature)+1 > n {
+			n = int(feature) + 1
+		}
+	}
+	return n
+}
+
+// predict returns the most likely label and the probability of each, or for
+// regression the predicted number as the label and no probabilities. A
+// multi-output forest's label is each of its -targets, comma separated.
+func (m *servedModel) predict(row datarow) (label string, proba []float32) {
+	if len(m.Targets) > 0 {
+		return strings.Join(targetLabels(&m.saveFormat, row), ","), nil
+	}
+	if m.Meta.Task == taskRegression {
+		return strconv.FormatFloat(float64(regressionPredict(m.Flat, row)), 'g', -1, 32), nil
+	}
+	proba = baggingProba(m.Flat, row, len(m.IndexedVariables))
+	best := 0
+	for i, p := range proba {
+		if p > proba[best] {
+			best = i
+		}
+	}
+	return m.IndexedVariables[best], proba
+}
+
+// quantileMap estimates each of the model's quantiles for row, by name, or is
+// nil without any
+func (m *servedModel) quantileMap(row datarow) map[string]float32 {
+	if m.quantiles == nil {
+		return nil
+	}
+	named := make(map[string]float32)
+	names := quantileNames(m.quantiles)
+	for i, v := range m.Flat.quantiles(row, m.quantiles) {
+		named[names[i]] = v
+	}
+	return named
+}
+
+// targetMap predicts each of a multi-output forest's -targets for row, by
+// name, or is nil for any other forest
+func (m *servedModel) targetMap(row datarow) map[string]string {
+	if len(m.Targets) == 0 {
+		return nil
+	}
+	named := make(map[string]string)
+	for j, label := range targetLabels(&m.saveFormat, row) {
+		named[m.Targets[j].Name] = label
+	}
+	return named
+}
+
+func (m *servedModel) labelMap(proba []float32) map[string]float32 {
+	named := make(map[string]float32)
+	for i, p := range proba {
+		named[m.IndexedVariables[i]] = p
+	}
+	return named
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println("Failed writing response:", err)
+	}
+}
+
+// serve runs the HTTP and gRPC prediction servers until interrupted
+func serve() {
+	s, err := newPredictionServer(*modelFile)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(len(s.current().Trees), "Trees loaded")
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if *reloadEvery > 0 {
+		tick = time.NewTicker(*reloadEvery).C
+	}
+	go (func() {
+		for {
+			var err error
+			select {
+			case <-hup:
+				log.Println("SIGHUP, reloading", s.path)
+				err = s.reload()
+			case <-tick:
+				err = s.reloadIfChanged()
+			}
+			// keep serving the old model if the new one is broken
+			if err != nil {
+				log.Println("Failed reloading model:", err)
+			}
+		}
+	})()
+
+	var grpcServer *grpc.Server
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			panic(err)
+		}
+		grpcServer = newGRPCServer(s)
+		go (func() {
+			fmt.Println("Serving gRPC predictions on", *grpcAddr)
+			if err := grpcServer.Serve(lis); err != nil {
+				panic(err)
+			}
+		})()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	if *serveAddr == "" {
+		if grpcServer == nil {
+			fmt.Println("-addr or -grpcaddr is required")
+			return
+		}
+		<-stop
+		log.Println("Shutting down")
+		grpcServer.GracefulStop()
+		return
+	}
+
+	server := &http.Server{Addr: *serveAddr, Handler: s.handler()}
+	go (func() {
+		<-stop
+		log.Println("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+	})()
+
+	fmt.Println("Serving predictions on", *serveAddr)
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		panic(err)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testModel is a single split on the first of two features: below 5 is
+// "small", otherwise "big"
+func testModel() *saveFormat {
+	return &saveFormat{
+		Trees: []*Tree{
+			{VariableIndex: 0, ValueIndex: 5, LeftTerminal: 0, RightTerminal: 1},
+		},
+		IndexedVariables: []string{"small", "big"},
+		Variables:        map[string]float32{"small": 0, "big": 1},
+		Meta:             modelMeta{Procedure: procedureFinal, Columns: 3, ColumnNames: []string{"width", "height", "size"}},
+	}
+}
+
+func startTestServer(t *testing.T, model *saveFormat) (s *predictionServer, ts *httptest.Server) {
+	path := filepath.Join(t.TempDir(), "model.gob")
+	if err := save(path, model); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newPredictionServer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts = httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func postJSON(t *testing.T, url string, body string, into interface{}) *http.Response {
+	res, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if into != nil && res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(into); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res
+}
+
+func TestServePredict(t *testing.T) {
+	_, ts := startTestServer(t, testModel())
+
+	var small, big predictResponse
+	postJSON(t, ts.URL+"/predict", `{"row":[1,9]}`, &small)
+	postJSON(t, ts.URL+"/predict", `{"row":[7,0]}`, &big)
+	if small.Label != "small" || big.Label != "big" {
+		t.Fatal("predicted", small.Label, big.Label)
+	}
+
+	var batch batchPredictResponse
+	postJSON(t, ts.URL+"/predict/batch", `{"rows":[[1,1],[5,1],[4.9,1]]}`, &batch)
+	if strings.Join(batch.Labels, ",") != "small,big,small" {
+		t.Fatal("batch predicted", batch.Labels)
+	}
+
+	var proba predictResponse
+	postJSON(t, ts.URL+"/proba", `{"row":[1,9]}`, &proba)
+	if proba.Probabilities["small"] != 1 || proba.Probabilities["big"] != 0 {
+		t.Fatal("probabilities", proba.Probabilities)
+	}
+}
+
+func TestServeExplain(t *testing.T) {
+	_, ts := startTestServer(t, testModel())
+
+	var res explainResponse
+	postJSON(t, ts.URL+"/explain", `{"row":[7,0]}`, &res)
+	if len(res.Trees) != 1 || len(res.Trees[0].Steps) != 1 {
+		t.Fatal("explanation", res)
+	}
+	step := res.Trees[0].Steps[0]
+	if step.Name != "width" || step.Left || step.Value != 7 || res.Trees[0].Vote != "big" {
+		t.Fatal("step", step)
+	}
+}
+
+func TestServeBadRequests(t *testing.T) {
+	_, ts := startTestServer(t, testModel())
+
+	if res := postJSON(t, ts.URL+"/predict", `{"row":[1]}`, nil); res.StatusCode != http.StatusBadRequest {
+		t.Fatal("wrong number of features got", res.Status)
+	}
+	if res := postJSON(t, ts.URL+"/predict", `{"rows":[[1,1]]}`, nil); res.StatusCode != http.StatusBadRequest {
+		t.Fatal("missing row got", res.Status)
+	}
+	if res := postJSON(t, ts.URL+"/predict", `not json`, nil); res.StatusCode != http.StatusBadRequest {
+		t.Fatal("bad json got", res.Status)
+	}
+	res, err := http.Get(ts.URL + "/predict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal("GET got", res.Status)
+	}
+}
+
+// models from before the column count was saved, and imported ones, still
+// reject rows too short for their splits rather than dropping the connection
+func TestServeUnknownColumns(t *testing.T) {
+	model := testModel()
+	model.Meta.Columns = 0
+	model.Trees[0].RightNode = &Tree{VariableIndex: 1, ValueIndex: 0, LeftTerminal: 0, RightTerminal: 1,
+		ObliqueFeatures: []int32{1, 3}, ObliqueWeights: []float32{1, 1}}
+	_, ts := startTestServer(t, model)
+
+	for _, c := range []struct{ path, body string }{
+		{"/predict", `{"row":[7,1]}`},
+		{"/predict", `{"row":[]}`},
+		{"/proba", `{"row":[7,1,2]}`},
+		{"/explain", `{"row":[7]}`},
+		{"/predict/batch", `{"rows":[[1,1,1,1],[7,1]]}`},
+	} {
+		if res := postJSON(t, ts.URL+c.path, c.body, nil); res.StatusCode != http.StatusBadRequest {
+			t.Fatal(c.path, c.body, "got", res.Status)
+		}
+	}
+	var big predictResponse
+	if res := postJSON(t, ts.URL+"/predict", `{"row":[7,1,0,1]}`, &big); res.StatusCode != http.StatusOK || big.Label != "big" {
+		t.Fatal("four features got", res.Status, big)
+	}
+	// more columns than the splits use are fine, since the count is unknown
+	if res := postJSON(t, ts.URL+"/predict", `{"row":[1,0,0,0,0]}`, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("five features got", res.Status)
+	}
+}
+
+func TestServeHealthAndModelInfo(t *testing.T) {
+	_, ts := startTestServer(t, testModel())
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatal(path, res.Status)
+		}
+	}
+
+	res, err := http.Get(ts.URL + "/model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var info modelInfoResponse
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Trees != 1 || info.Features != 2 || info.Meta.Procedure != procedureFinal {
+		t.Fatal("model info", info)
+	}
+}
+
+func TestServeReload(t *testing.T) {
+	s, ts := startTestServer(t, testModel())
+
+	flipped := testModel()
+	flipped.Trees[0].LeftTerminal, flipped.Trees[0].RightTerminal = 1, 0
+	if err := save(s.path, flipped); err != nil {
+		t.Fatal(err)
+	}
+	// file times can be too coarse to notice a quick rewrite
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(s.path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.reloadIfChanged(); err != nil {
+		t.Fatal(err)
+	}
+
+	var res predictResponse
+	postJSON(t, ts.URL+"/predict", `{"row":[1,9]}`, &res)
+	if res.Label != "big" {
+		t.Fatal("still serving the old model, predicted", res.Label)
+	}
+
+	// a broken file keeps the last good model
+	if err := os.WriteFile(s.path, []byte("nope"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.reload(); err == nil {
+		t.Fatal("expected an error loading a broken model")
+	}
+	postJSON(t, ts.URL+"/predict", `{"row":[1,9]}`, &res)
+	if res.Label != "big" {
+		t.Fatal("lost the model after a failed reload, predicted", res.Label)
+	}
+}
@@ -9,6 +9,12 @@ type splitCache struct {
 	right         []datarow
 	leftLastCols  []float32
 	rightLastCols []float32
+
+	// only used by weighted splits
+	leftWeights  []float64
+	rightWeights []float64
+	leftTotals   []float64
+	rightTotals  []float64
 }
 
 /*
@@ -41,3 +47,148 @@ func (sc *splitCache) splitOnIndex(index int32, value float32, dataSubset []data
 		}
 	}
 }
+
+// splitOnValues is splitOnIndex for rows whose values are already known, as
+// for an oblique split: row i goes left when values[i] is less than value
+func (sc *splitCache) splitOnValues(values []float32, value float32, dataSubset []datarow) {
+	sc.left = sc.left[:0]
+	sc.right = sc.right[:0]
+	sc.leftLastCols = sc.leftLastCols[:0]
+	sc.rightLastCols = sc.rightLastCols[:0]
+	for i, row := range dataSubset {
+		if values[i] < value {
+			sc.left = append(sc.left, row)
+			sc.leftLastCols = append(sc.leftLastCols, row[lastColumnIndex])
+		} else {
+			sc.right = append(sc.right, row)
+			sc.rightLastCols = append(sc.rightLastCols, row[lastColumnIndex])
+		}
+	}
+}
+
+// splitImpurity is the error of the last split of dataSubset, when every row
+// counts the same
+func (sc *splitCache) splitImpurity(dataSubset []datarow) float64 {
+	if outputs > 1 {
+		return sc.outputImpurity()
+	}
+	if regressionTrees {
+		return sumSquaredError(sc.leftLastCols) + sumSquaredError(sc.rightLastCols)
+	}
+	return float64(calcGiniOnSplit(sc.leftLastCols, sc.rightLastCols, lastColumn(dataSubset)))
+}
+
+// splitWeights splits the weights of dataSubset the same way splitOnIndex
+// splits its rows
+func (sc *splitCache) splitWeights(index int32, value float32, dataSubset []datarow, weights []float64) {
+	sc.leftWeights = sc.leftWeights[:0]
+	sc.rightWeights = sc.rightWeights[:0]
+	for i, row := range dataSubset {
+		if row[index] < value {
+			sc.leftWeights = append(sc.leftWeights, weights[i])
+		} else {
+			sc.rightWeights = append(sc.rightWeights, weights[i])
+		}
+	}
+}
+
+/*
+weightedGini is the gini impurity of each side of the last split, where each
+row counts as much as its weight, times the weight of that side. Unlike
+calcGiniOnSplit, a side only counts for as much as it holds, so a shallow tree
+does not split off a few rows to make one small, pure side.
+*/
+func (sc *splitCache) weightedGini() (gini float64) {
+	var size float64
+	sc.leftTotals, size = addClassWeights(sc.leftTotals, sc.leftLastCols, sc.leftWeights)
+	gini += impurity(sc.leftTotals, size)
+	sc.rightTotals, size = addClassWeights(sc.rightTotals, sc.rightLastCols, sc.rightWeights)
+	gini += impurity(sc.rightTotals, size)
+	return gini
+}
+
+// impurity is the gini impurity of a group with the weight totals of each
+// variable index, times the group's weight
+func impurity(totals []float64, size float64) (gini float64) {
+	if size == 0 {
+		return 0
+	}
+	for _, total := range totals {
+		proportion := total / size
+		gini += total * (1 - proportion)
+	}
+	return gini
+}
+
+// addClassWeights totals the weights of each variable index in lastCols,
+// reusing totals, and the weights of them all
+func addClassWeights(totals []float64, lastCols []float32, weights []float64) ([]float64, float64) {
+	totals = totals[:0]
+	var sum float64
+	for i, variableIndex := range lastCols {
+		for len(totals) <= int(variableIndex) {
+			totals = append(totals, 0)
+		}
+		totals[int(variableIndex)] += weights[i]
+		sum += weights[i]
+	}
+	return totals, sum
+}
+
+/*
+outputImpurity is the impurity of the last split for every one of the -targets
+at the end of each row, added together. Each output counts the same: for
+labels its gini impurity on each side times the side's share of the rows, and
+for regression the squared error of both sides as a share of what it was
+before the split.
+*/
+func (sc *splitCache) outputImpurity() (combined float64) {
+	size := float64(len(sc.left) + len(sc.right))
+	for col := lastColumnIndex; col < lastColumnIndex+outputs; col++ {
+		if regressionTrees {
+			leftSize, leftMean, leftSSE := columnError(sc.left, col)
+			rightSize, rightMean, rightSSE := columnError(sc.right, col)
+			before := leftSSE + rightSSE + leftSize*rightSize/size*(leftMean-rightMean)*(leftMean-rightMean)
+			if before > 0 {
+				combined += (leftSSE + rightSSE) / before
+			}
+			continue
+		}
+		var n float64
+		sc.leftTotals, n = countColumn(sc.leftTotals, sc.left, col)
+		combined += impurity(sc.leftTotals, n) / size
+		sc.rightTotals, n = countColumn(sc.rightTotals, sc.right, col)
+		combined += impurity(sc.rightTotals, n) / size
+	}
+	return combined
+}
+
+// columnError is how many rows there are, the mean of their col, and its
+// squared error
+func columnError(rows []datarow, col int) (size float64, mean float64, sse float64) {
+	if len(rows) == 0 {
+		return 0, 0, 0
+	}
+	for _, row := range rows {
+		mean += float64(row[col])
+	}
+	size = float64(len(rows))
+	mean /= size
+	for _, row := range rows {
+		sse += (float64(row[col]) - mean) * (float64(row[col]) - mean)
+	}
+	return size, mean, sse
+}
+
+// countColumn counts the rows with each variable index in col, reusing
+// totals, and how many rows there are
+func countColumn(totals []float64, rows []datarow, col int) ([]float64, float64) {
+	totals = totals[:0]
+	for _, row := range rows {
+		for len(totals) <= int(row[col]) {
+			totals = append(totals, 0)
+		}
+		totals[int(row[col])]++
+	}
+	return totals, float64(len(rows))
+}
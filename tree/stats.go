@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// modelStats summarizes the shape of a forest, for tracking models over time
+type modelStats struct {
+	Trees      int            `json:"trees"`
+	Splits     int            `json:"splits"`
+	Leaves     int            `json:"leaves"`
+	Depth      depthStats     `json:"depth"`
+	Features   []featureStats `json:"features"`
+	LeafLabels map[string]int `json:"leafLabels,omitempty"` // leaves predicting each label
+	LeafValues *valueRange    `json:"leafValues,omitempty"` // when leaves are not labels
+	FileBytes  int64          `json:"fileBytes"`
+	// MemoryBytes is an estimate of the trees, their flattened copy, and the
+	// labels once loaded
+	MemoryBytes int64 `json:"memoryBytes"`
+}
+
+type depthStats struct {
+	Min   int         `json:"min"`
+	Max   int         `json:"max"`
+	Mean  float64     `json:"mean"`
+	Trees map[int]int `json:"trees"` // how many trees have each depth
+}
+
+// featureStats is how often a feature is split on, and where
+type featureStats struct {
+	Feature    int         `json:"feature"`
+	Name       string      `json:"name"`
+	Splits     int         `json:"splits"`
+	Thresholds *valueRange `json:"thresholds,omitempty"`
+}
+
+type valueRange struct {
+	Min float32 `json:"min"`
+	Max float32 `json:"max"`
+}
+
+func (r *valueRange) add(v float32) *valueRange {
+	if r == nil {
+		return &valueRange{Min: v, Max: v}
+	}
+	r.Min = float32(math.Min(float64(r.Min), float64(v)))
+	r.Max = float32(math.Max(float64(r.Max), float64(v)))
+	return r
+}
+
+// stats prints a summary of the -model as text, or json with -format=json
+func stats() {
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+	s := summarize(&loaded)
+	info, err := os.Stat(*modelFile)
+	if err != nil {
+		panic(err)
+	}
+	s.FileBytes = info.Size()
+
+	switch *inspectFormat {
+	case inspectText:
+		os.Stdout.Write(s.text())
+	case inspectJSON:
+		buf, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(buf))
+	default:
+		fmt.Println("-format should be", inspectText, "or", inspectJSON, "for -stats")
+	}
+}
+
+// summarize walks every tree in the model
+func summarize(model *saveFormat) *modelStats {
+	s := &modelStats{Trees: len(model.Trees), Depth: depthStats{Trees: make(map[int]int)}}
+	labels := !model.Flat.Additive && model.Meta.Task != taskRegression && model.Meta.Task != taskAnomaly && len(model.Targets) == 0
+	if labels {
+		s.LeafLabels = make(map[string]int)
+	}
+	features := make(map[int]*featureStats)
+	names := treeRenderer{model: model}
+
+	leaf := func(v float32) {
+		s.Leaves++
+		if labels && int(v) < len(model.IndexedVariables) {
+			s.LeafLabels[model.IndexedVariables[int(v)]]++
+		} else {
+			s.LeafValues = s.LeafValues.add(v)
+		}
+	}
+	var walk func(t *Tree) (depth int)
+	walk = func(t *Tree) (depth int) {
+		s.Splits++
+		// an oblique split counts for each feature it combines, but its
+		// threshold is not a value of any one of them
+		combined := t.ObliqueFeatures
+		if len(combined) == 0 {
+			combined = []int32{int32(t.VariableIndex)}
+		}
+		for _, ix := range combined {
+			f := features[int(ix)]
+			if f == nil {
+				f = &featureStats{Feature: int(ix), Name: names.feature(&Tree{VariableIndex: float32(ix)})}
+				features[f.Feature] = f
+			}
+			f.Splits++
+			if len(t.ObliqueFeatures) == 0 {
+				f.Thresholds = f.Thresholds.add(t.ValueIndex)
+			}
+		}
+
+		var left, right int
+		if t.LeftNode != nil {
+			left = walk(t.LeftNode)
+		} else {
+			leaf(t.LeftTerminal)
+		}
+		if t.RightNode != nil {
+			right = walk(t.RightNode)
+		} else {
+			leaf(t.RightTerminal)
+		}
+		if left > right {
+			return left + 1
+		}
+		return right + 1
+	}
+
+	var totalDepth int
+	for i, t := range model.Trees {
+		depth := walk(t)
+		s.Depth.Trees[depth]++
+		totalDepth += depth
+		if i == 0 || depth < s.Depth.Min {
+			s.Depth.Min = depth
+		}
+		if depth > s.Depth.Max {
+			s.Depth.Max = depth
+		}
+	}
+	if len(model.Trees) > 0 {
+		s.Depth.Mean = float64(totalDepth) / float64(len(model.Trees))
+	}
+
+	// every feature is listed, so unused ones show up too
+	n := model.Meta.Columns - 1
+	for f := range features {
+		if f+1 > n {
+			n = f + 1
+		}
+	}
+	for i := 0; i < n; i++ {
+		f := features[i]
+		if f == nil {
+			f = &featureStats{Feature: i, Name: names.feature(&Tree{VariableIndex: float32(i)})}
+		}
+		s.Features = append(s.Features, *f)
+	}
+
+	s.MemoryBytes = int64(s.Splits) * int64(unsafe.Sizeof(Tree{}))
+	if model.Flat != nil {
+		f := model.Flat
+		s.MemoryBytes += int64(4 * (len(f.Roots) + len(f.Feature) + len(f.Threshold) + len(f.Left) + len(f.Right) + len(f.Leaf)))
+		// leaf values and outputs are in both the trees and the copy
+		s.MemoryBytes += int64(4 * (2*len(f.Values) + len(f.ValueStart) + 2*len(f.LeafOutputs)))
+	}
+	for _, label := range model.IndexedVariables {
+		s.MemoryBytes += int64(len(label)) + int64(unsafe.Sizeof(label))
+	}
+	return s
+}
+
+// bar is a histogram bar of width up to 40 for count out of most
+func bar(count, most int) string {
+	if most == 0 {
+		return ""
+	}
+	return strings.Repeat("#", int(math.Ceil(40*float64(count)/float64(most))))
+}
+
+// byteSize formats n bytes for people
+func byteSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+func (s *modelStats) text() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "trees: %d\nsplits: %d\nleaves: %d\n", s.Trees, s.Splits, s.Leaves)
+
+	fmt.Fprintf(&b, "\ndepth: min %d, max %d, mean %.1f\n", s.Depth.Min, s.Depth.Max, s.Depth.Mean)
+	var mostTrees int
+	for _, n := range s.Depth.Trees {
+		if n > mostTrees {
+			mostTrees = n
+		}
+	}
+	for depth := s.Depth.Min; depth <= s.Depth.Max; depth++ {
+		fmt.Fprintf(&b, "  %3d %6d %s\n", depth, s.Depth.Trees[depth], bar(s.Depth.Trees[depth], mostTrees))
+	}
+
+	b.WriteString("\nsplits per feature:\n")
+	var mostSplits, widest int
+	for _, f := range s.Features {
+		if f.Splits > mostSplits {
+			mostSplits = f.Splits
+		}
+		if len(f.Name) > widest {
+			widest = len(f.Name)
+		}
+	}
+	for _, f := range s.Features {
+		fmt.Fprintf(&b, "  %-*s %6d %s", widest, f.Name, f.Splits, bar(f.Splits, mostSplits))
+		if f.Thresholds != nil {
+			fmt.Fprintf(&b, "  (%g to %g)", f.Thresholds.Min, f.Thresholds.Max)
+		}
+		b.WriteString("\n")
+	}
+
+	if s.LeafLabels != nil {
+		b.WriteString("\nleaves per label:\n")
+		var labels []string
+		var mostLeaves int
+		widest = 0
+		for label, n := range s.LeafLabels {
+			labels = append(labels, label)
+			if n > mostLeaves {
+				mostLeaves = n
+			}
+			if len(label) > widest {
+				widest = len(label)
+			}
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			fmt.Fprintf(&b, "  %-*s %6d %s\n", widest, label, s.LeafLabels[label], bar(s.LeafLabels[label], mostLeaves))
+		}
+	}
+	if s.LeafValues != nil {
+		fmt.Fprintf(&b, "\nleaf values: %g to %g\n", s.LeafValues.Min, s.LeafValues.Max)
+	}
+
+	fmt.Fprintf(&b, "\nsize: %s on disk, about %s in memory\n", byteSize(s.FileBytes), byteSize(s.MemoryBytes))
+	return b.Bytes()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	model := inspectTestModel()
+	stump := &Tree{VariableIndex: 1, ValueIndex: 7, LeftTerminal: 1, RightTerminal: 1}
+	model.Trees = append(model.Trees, stump)
+	model.Flat = flatten(model.Trees)
+
+	s := summarize(model)
+	if s.Trees != 2 || s.Splits != 4 || s.Leaves != 6 {
+		t.Fatal("trees", s.Trees, "splits", s.Splits, "leaves", s.Leaves)
+	}
+	if s.Depth.Min != 1 || s.Depth.Max != 3 || s.Depth.Mean != 2 || !reflect.DeepEqual(s.Depth.Trees, map[int]int{1: 1, 3: 1}) {
+		t.Fatalf("depth %+v", s.Depth)
+	}
+	expected := []featureStats{
+		{Feature: 0, Name: "width", Splits: 1, Thresholds: &valueRange{1.25, 1.25}},
+		{Feature: 1, Name: "height", Splits: 2, Thresholds: &valueRange{2.5, 7}},
+		{Feature: 2, Name: "depth", Splits: 1, Thresholds: &valueRange{-3, -3}},
+	}
+	if !reflect.DeepEqual(s.Features, expected) {
+		t.Fatalf("features %+v", s.Features)
+	}
+	if !reflect.DeepEqual(s.LeafLabels, map[string]int{"small": 2, "big": 4}) || s.LeafValues != nil {
+		t.Fatal("leaf labels", s.LeafLabels)
+	}
+	if s.MemoryBytes <= 0 {
+		t.Fatal("memory", s.MemoryBytes)
+	}
+
+	var decoded modelStats
+	buf, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf, &decoded); err != nil || !reflect.DeepEqual(&decoded, s) {
+		t.Fatal("json did not round trip", err, string(buf))
+	}
+	if text := string(s.text()); !strings.Contains(text, "height      2 ") {
+		t.Fatal("expected the height splits in\n" + text)
+	}
+}
+
+func TestSummarizeUnusedFeatureAndValues(t *testing.T) {
+	model := inspectTestModel()
+	model.Meta.Columns = 5
+	model.Meta.ColumnNames = nil
+	model.Meta.Task = taskRegression
+
+	s := summarize(model)
+	if len(s.Features) != 4 || s.Features[3].Name != "x3" || s.Features[3].Splits != 0 || s.Features[3].Thresholds != nil {
+		t.Fatalf("features %+v", s.Features)
+	}
+	if s.LeafLabels != nil || *s.LeafValues != (valueRange{0, 1}) {
+		t.Fatal("leaf values", s.LeafValues)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// streamRequest is a json line for -stream, as an alternative to a bare array
+type streamRequest struct {
+	Row []float32   `json:"row"`
+	ID  interface{} `json:"id,omitempty"`
+}
+
+type streamResponse struct {
+	Label         string             `json:"label,omitempty"`
+	Probabilities map[string]float32 `json:"probabilities,omitempty"`
+	Quantiles     map[string]float32 `json:"quantiles,omitempty"`
+	Targets       map[string]string  `json:"targets,omitempty"`
+	ID            interface{}        `json:"id,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	Explanation   *explainResponse   `json:"explanation,omitempty"`
+}
+
+/*
+predictStream reads feature rows from in, one per line, and writes one
+prediction line to out for each, flushing as it goes so it can be used as a
+coprocess.
+
+A line is either csv features, which gets back just the label, or json - an
+array of features or {"row":[...],"id":...} - which gets back a json object
+with the label and probabilities. A regression forest given quantiles
+also answers with each of them, after the label on a csv line. A
+multi-output forest answers with each of its -targets, comma separated on a
+csv line or by name in json. A line that cannot be predicted still gets
+an answer: an empty line for csv, or an object with an error for json.
+
+With explain, every line gets back a json object with an explanation.
+*/
+func predictStream(m *servedModel, in io.Reader, out io.Writer, errOut io.Writer, explain bool) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if line[0] == '[' || line[0] == '{' || explain {
+			var req streamRequest
+			var err error
+			if line[0] == '[' {
+				err = json.Unmarshal([]byte(line), &req.Row)
+			} else if line[0] == '{' {
+				err = json.Unmarshal([]byte(line), &req)
+			} else {
+				req.Row, err = parseCSVFeatures(line)
+			}
+			res := streamResponse{ID: req.ID}
+			var row datarow
+			if err == nil {
+				row, err = m.toRow(req.Row)
+			}
+			if err != nil {
+				res.Error = err.Error()
+			} else {
+				var proba []float32
+				res.Label, proba = m.predict(row)
+				res.Probabilities = m.labelMap(proba)
+				res.Quantiles = m.quantileMap(row)
+				res.Targets = m.targetMap(row)
+				if explain {
+					explanation := explainRow(&m.saveFormat, row, len(req.Row))
+					res.Explanation = &explanation
+				}
+			}
+			encoder.Encode(res) // adds the newline
+		} else {
+			label, err := streamPredictCSV(m, line)
+			if err != nil {
+				fmt.Fprintln(errOut, "line", lineNumber, err)
+			}
+			fmt.Fprintln(w, label)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// parseCSVFeatures reads a line of comma separated features
+func parseCSVFeatures(line string) (features []float32, err error) {
+	cols := strings.Split(line, ",")
+	features = make([]float32, len(cols))
+	for i, col := range cols {
+		nc, err := strconv.ParseFloat(strings.TrimSpace(col), 32)
+		if err != nil {
+			return nil, err
+		}
+		features[i] = float32(nc)
+	}
+	return features, nil
+}
+
+func streamPredictCSV(m *servedModel, line string) (label string, err error) {
+	features, err := parseCSVFeatures(line)
+	if err != nil {
+		return "", err
+	}
+	row, err := m.toRow(features)
+	if err != nil {
+		return "", err
+	}
+	label, _ = m.predict(row)
+	if m.quantiles != nil {
+		cols := formatQuantiles(m.Flat.quantiles(row, m.quantiles))
+		label = strings.Join(append([]string{label}, cols...), ",")
+	}
+	return label, nil
+}
+
+// newStreamModel is the model for predictStream, expecting rows of only its
+// features
+func newStreamModel(model saveFormat) *servedModel {
+	m := &servedModel{saveFormat: model}
+	m.features = m.Meta.Columns - 1
+	if len(m.Targets) > 0 {
+		m.features = m.Meta.Columns - len(m.Targets)
+	}
+	m.minFeatures = splitFeatures(m.Flat)
+	return m
+}
+
+// streamPredict runs predictStream over stdin and stdout for -pred -stream
+func streamPredict() {
+	if *charMode {
+		fmt.Fprintln(os.Stderr, "-stream is not supported with -charmode")
+		return
+	}
+	var loaded saveFormat
+	err := load(*modelFile, &loaded)
+	if err != nil {
+		panic(err)
+	}
+	if loaded.Meta.Task == taskAnomaly {
+		fmt.Fprintln(os.Stderr, "-stream is not supported for isolation forests; use -input")
+		return
+	}
+	if len(loaded.Targets) > 0 && *explainPredictions {
+		fmt.Fprintln(os.Stderr, "-explain is not supported for multi-output forests")
+		return
+	}
+	m := newStreamModel(loaded)
+	if quantileLevels != nil && len(m.Flat.ValueStart) == 0 {
+		fmt.Fprintln(os.Stderr, errNoLeafValues)
+		return
+	}
+	m.quantiles = quantileLevels
+	fmt.Fprintln(os.Stderr, len(m.Trees), "Trees loaded")
+
+	err = predictStream(m, os.Stdin, os.Stdout, os.Stderr, *explainPredictions)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStreamModel is testModel as -stream loads it
+func testStreamModel() *servedModel {
+	model := testModel()
+	model.Flat = flatten(model.Trees)
+	return newStreamModel(*model)
+}
+
+func TestPredictStream(t *testing.T) {
+	input := strings.Join([]string{
+		"1,0",
+		"[7,0]",
+		`{"row":[1,0],"id":"a"}`,
+		"1,x",
+		"",
+		"[1]",
+		"9, 0",
+	}, "\n")
+	var out, errOut bytes.Buffer
+	if err := predictStream(testStreamModel(), strings.NewReader(input), &out, &errOut, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	// one line back for every line but the empty one
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 lines, got %q", lines)
+	}
+	if lines[0] != "small" || lines[3] != "" || lines[5] != "big" {
+		t.Fatalf("csv lines %q", lines)
+	}
+
+	var res streamResponse
+	if err := json.Unmarshal([]byte(lines[1]), &res); err != nil || res.Label != "big" || res.Probabilities["big"] != 1 {
+		t.Fatal("array", lines[1], err)
+	}
+	res = streamResponse{}
+	if err := json.Unmarshal([]byte(lines[2]), &res); err != nil || res.Label != "small" || res.ID != "a" {
+		t.Fatal("object", lines[2], err)
+	}
+	res = streamResponse{}
+	if err := json.Unmarshal([]byte(lines[4]), &res); err != nil || res.Error != "has 1 features, expected 2" || res.Label != "" {
+		t.Fatal("short row", lines[4], err)
+	}
+
+	// only the bad csv line is reported, by its line in the input
+	if !strings.HasPrefix(errOut.String(), "line 4 ") || strings.Count(errOut.String(), "\n") != 1 {
+		t.Fatalf("errors %q", errOut.String())
+	}
+}
+
+// a coprocess waits for each answer before writing the next line
+func TestPredictStreamFlushesEachLine(t *testing.T) {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- predictStream(testStreamModel(), inReader, outWriter, io.Discard, false)
+		outWriter.Close()
+	}()
+
+	answers := bufio.NewReader(outReader)
+	for _, c := range []struct{ line, expected string }{
+		{"1,0", "small"},
+		{"7,0", "big"},
+		{"[1,0]", `{"label":"small","probabilities":{"big":0,"small":1}}`},
+	} {
+		if _, err := io.WriteString(inWriter, c.line+"\n"); err != nil {
+			t.Fatal(err)
+		}
+		answer := make(chan string, 1)
+		go func() {
+			line, _ := answers.ReadString('\n')
+			answer <- line
+		}()
+		select {
+		case line := <-answer:
+			if line != c.expected+"\n" {
+				t.Fatalf("%s got %q, expected %q", c.line, line, c.expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no answer to", c.line)
+		}
+	}
+	inWriter.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPredictStreamExplain(t *testing.T) {
+	var out, errOut bytes.Buffer
+	err := predictStream(testStreamModel(), strings.NewReader("1,0\n[7,0]\n1,x\n"), &out, &errOut, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", lines)
+	}
+	// csv lines get json too, and errors go in it rather than to errOut
+	for i, label := range []string{"small", "big"} {
+		var res streamResponse
+		if err := json.Unmarshal([]byte(lines[i]), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Label != label || res.Explanation == nil || res.Explanation.Label != label || len(res.Explanation.Trees) != 1 {
+			t.Fatal("line", i+1, lines[i])
+		}
+	}
+	var res streamResponse
+	if err := json.Unmarshal([]byte(lines[2]), &res); err != nil || res.Error == "" || res.Explanation != nil {
+		t.Fatal("bad line", lines[2], err)
+	}
+	if errOut.Len() != 0 {
+		t.Fatalf("errors %q", errOut.String())
+	}
+}
+
+// a model without its column count answers rows too short for its splits
+// with an error instead of panicking
+func TestPredictStreamUnknownColumns(t *testing.T) {
+	model := testModel()
+	model.Meta.Columns = 0
+	model.Trees[0].RightNode = &Tree{VariableIndex: 1, ValueIndex: 0, LeftTerminal: 0, RightTerminal: 1,
+		ObliqueFeatures: []int32{1, 3}, ObliqueWeights: []float32{1, 1}}
+	model.Flat = flatten(model.Trees)
+
+	var out, errOut bytes.Buffer
+	err := predictStream(newStreamModel(*model), strings.NewReader("7,1\n[7,1,0]\n7,1,0,1\n"), &out, &errOut, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 || lines[0] != "" || !strings.Contains(lines[1], `"error":"has 3 features, expected at least 4"`) || lines[2] != "big" {
+		t.Fatalf("got %q", lines)
+	}
+	if errOut.String() != "line 1 has 2 features, expected at least 4\n" {
+		t.Fatalf("errors %q", errOut.String())
+	}
+}
@@ -6,16 +6,30 @@ Tree, for left and right, either has a Node or a terminal value
 When evaluating for an input row, take the input row and get the value
 at the VariableIndex in the input row. If it is less than the ValueIndex,
 go left (which might terminate). Otherwise, go right (which also might
-terminate).
+terminate). An oblique node compares the sum of each of its ObliqueFeatures
+times its weight instead.
 */
 type Tree struct {
 	VariableIndex float32 // the variable that this tree splits on (?) (Index)
 	ValueIndex    float32 // the split value of this node
 	LeftNode      *Tree
 	RightNode     *Tree
-	LeftTerminal  float32 // index of a variable that this predicts
-	RightTerminal float32 // index of a variable that this predicts
+	LeftTerminal  float32   // index of a variable that this predicts
+	RightTerminal float32   // index of a variable that this predicts
+	LeftCount     int       // training rows that went left, if known
+	RightCount    int       // training rows that went right, if known
+	LeftClasses   []int     // training rows with each variable index, at a left terminal
+	RightClasses  []int     // training rows with each variable index, at a right terminal
+	LeftValues    []float32 // sorted training values at a left terminal, in a regression forest
+	RightValues   []float32 // sorted training values at a right terminal, in a regression forest
+	LeftOutputs   []float32 // what a left terminal predicts for each of the -targets
+	RightOutputs  []float32 // what a right terminal predicts for each of the -targets
+
+	ObliqueFeatures []int32   // the features an oblique node combines, the first being VariableIndex
+	ObliqueWeights  []float32 // how much each of ObliqueFeatures counts
 
 	leftSamples  []datarow // temp test cases for left group
 	rightSamples []datarow // temp test cases for right group
+	leftWeights  []float64 // temp weights of leftSamples, when boosting
+	rightWeights []float64 // temp weights of rightSamples, when boosting
 }
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Hyperparameter search.
+
+The -grid flag lists the values to try for each tunable parameter, separated
+by semicolons. Each parameter takes either a comma separated list of values,
+or a range as lo:hi or lo:hi:step.
+
+	trees=1,5,10;m=2:4;depth=4:12:2;subsetpct=0.4:0.8:0.1
+
+A grid search tries every combination. A random search picks a value for each
+parameter on every trial - uniformly inside ranges, or one of the listed
+values. Parameters not in the grid keep the value from their own flag.
+Invalid combinations, like m above the number of features, are skipped by a
+grid search and drawn again by a random search.
+*/
+
+// tuneConfig is one set of hyperparameters and how it scored
+type tuneConfig struct {
+	Trees         int
+	M             int
+	Depth         int
+	SubsetPercent float64
+	FoldScores    []float32
+	MeanAccuracy  float32
+}
+
+// tuneParam is the parsed grid entry for one parameter
+type tuneParam struct {
+	name    string
+	values  []float64 // explicit list, or a range expanded by step
+	isRange bool
+	lo, hi  float64
+	isInt   bool
+}
+
+// how many times a random search draws for one trial before giving up on
+// finding a valid config
+const tuneMaxDraws = 100
+
+// the tunable parameters, and whether they take whole numbers
+var tuneParamIsInt = map[string]bool{"trees": true, "m": true, "depth": true, "subsetpct": false}
+
+func parseTuneGrid(grid string) (params []tuneParam, err error) {
+	for _, part := range strings.Split(grid, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("grid entry %q should look like name=values", part)
+		}
+		isInt, known := tuneParamIsInt[kv[0]]
+		if !known {
+			return nil, fmt.Errorf("unknown grid parameter %q", kv[0])
+		}
+		p := tuneParam{name: kv[0], isInt: isInt}
+		if strings.Contains(kv[1], ":") {
+			bounds := strings.Split(kv[1], ":")
+			if len(bounds) < 2 || len(bounds) > 3 {
+				return nil, fmt.Errorf("range %q should be lo:hi or lo:hi:step", kv[1])
+			}
+			var nums []float64
+			for _, b := range bounds {
+				n, err := strconv.ParseFloat(b, 64)
+				if err != nil {
+					return nil, err
+				}
+				nums = append(nums, n)
+			}
+			step := 1.0
+			if !isInt {
+				step = 0.1
+			}
+			if len(nums) == 3 {
+				step = nums[2]
+			}
+			if step <= 0 || nums[1] < nums[0] {
+				return nil, fmt.Errorf("range %q is empty", kv[1])
+			}
+			p.isRange = true
+			p.lo, p.hi = nums[0], nums[1]
+			// each value is counted from lo rather than added up, so float
+			// error does not build up, and the small epsilon keeps hi in
+			for i := 0; p.lo+float64(i)*step <= p.hi+step/1e6; i++ {
+				p.values = append(p.values, p.lo+float64(i)*step)
+			}
+		} else {
+			for _, v := range strings.Split(kv[1], ",") {
+				n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if err != nil {
+					return nil, err
+				}
+				p.values = append(p.values, n)
+			}
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+// gridConfigs expands every combination of the params over base
+func gridConfigs(base tuneConfig, params []tuneParam) (configs []tuneConfig) {
+	configs = []tuneConfig{base}
+	for _, p := range params {
+		var next []tuneConfig
+		for _, c := range configs {
+			for _, v := range p.values {
+				next = append(next, c.with(p.name, v))
+			}
+		}
+		configs = next
+	}
+	return configs
+}
+
+// randomConfig picks one value for each of the params
+func randomConfig(base tuneConfig, params []tuneParam) tuneConfig {
+	c := base
+	for _, p := range params {
+		var v float64
+		if p.isRange {
+			v = p.lo + rand.Float64()*(p.hi-p.lo)
+			if p.isInt {
+				v = math.Floor(p.lo + rand.Float64()*(p.hi-p.lo+1))
+			}
+		} else {
+			v = p.values[rand.Intn(len(p.values))]
+		}
+		c = c.with(p.name, v)
+	}
+	return c
+}
+
+// randomValidConfig draws until it finds a valid config, or reports false
+// after tuneMaxDraws tries
+func randomValidConfig(base tuneConfig, params []tuneParam) (tuneConfig, bool) {
+	for i := 0; i < tuneMaxDraws; i++ {
+		if c := randomConfig(base, params); c.valid() {
+			return c, true
+		}
+	}
+	return tuneConfig{}, false
+}
+
+func (c tuneConfig) with(name string, v float64) tuneConfig {
+	switch name {
+	case "trees":
+		c.Trees = int(v)
+	case "m":
+		c.M = int(v)
+	case "depth":
+		c.Depth = int(v)
+	case "subsetpct":
+		c.SubsetPercent = v
+	}
+	return c
+}
+
+// apply sets the globals evaluateAlgorithm trains with
+func (c tuneConfig) apply() {
+	*treesPerFold = c.Trees
+	n_features = c.M
+	maxDepth = c.Depth
+	*subsetSizePercent = c.SubsetPercent
+}
+
+// valid is whether the config can train a forest on rows of columnsPerRow
+func (c tuneConfig) valid() bool {
+	return c.Trees >= 1 && c.M >= 1 && c.M < columnsPerRow && c.Depth >= 1 && c.SubsetPercent > 0
+}
+
+func (c tuneConfig) String() string {
+	return fmt.Sprintf("trees=%d m=%d depth=%d subsetpct=%.2f", c.Trees, c.M, c.Depth, c.SubsetPercent)
+}
+
+func tune() {
+	params, err := parseTuneGrid(*tuneGrid)
+	if err != nil {
+		fmt.Println("-grid:", err)
+		return
+	}
+	loadTrainingData()
+
+	base := tuneConfig{
+		Trees:         *treesPerFold,
+		M:             n_features,
+		Depth:         maxDepth,
+		SubsetPercent: *subsetSizePercent,
+	}
+	var configs []tuneConfig
+	maxTrials := *tuneTrials
+	if *tuneSearch == "grid" {
+		configs = gridConfigs(base, params)
+		if maxTrials == 0 || maxTrials > len(configs) {
+			maxTrials = len(configs)
+		}
+	} else if *tuneSearch == "random" {
+		if maxTrials == 0 {
+			maxTrials = 20
+		}
+	} else {
+		fmt.Println("-search must be grid or random")
+		return
+	}
+
+	var deadline time.Time
+	if *tuneTime > 0 {
+		deadline = time.Now().Add(*tuneTime)
+	}
+
+	var leaderboard []tuneConfig
+	for trial := 0; trial < maxTrials; trial++ {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			fmt.Println("\nStopping after", trial, "trials, -tunetime reached")
+			break
+		}
+		var c tuneConfig
+		if *tuneSearch == "grid" {
+			c = configs[trial]
+			if !c.valid() {
+				fmt.Println("Skipping invalid config", c)
+				continue
+			}
+		} else {
+			var ok bool
+			if c, ok = randomValidConfig(base, params); !ok {
+				fmt.Println("\nStopping after", trial, "trials, no valid config in", tuneMaxDraws, "draws")
+				break
+			}
+		}
+		c.apply()
+		fmt.Println("\nTrial", trial+1, "/", maxTrials, c)
+		c.FoldScores, _ = evaluateAlgorithm()
+		c.MeanAccuracy = sum(c.FoldScores) / float32(len(c.FoldScores))
+		fmt.Println("Trial", trial+1, "mean accuracy:", c.MeanAccuracy, "%")
+		leaderboard = append(leaderboard, c)
+	}
+	if len(leaderboard) == 0 {
+		fmt.Println("No trials were run")
+		return
+	}
+
+	sort.SliceStable(leaderboard, func(i, j int) bool {
+		return leaderboard[i].MeanAccuracy > leaderboard[j].MeanAccuracy
+	})
+	fmt.Println("\nLeaderboard:")
+	fmt.Printf("%5s %7s %5s %6s %10s %10s\n", "rank", "trees", "m", "depth", "subsetpct", "accuracy")
+	for i, c := range leaderboard {
+		fmt.Printf("%5d %7d %5d %6d %10.2f %9.2f%%\n", i+1, c.Trees, c.M, c.Depth, c.SubsetPercent, c.MeanAccuracy)
+	}
+
+	best := leaderboard[0]
+	if *tuneBest != "" {
+		buf, err := json.MarshalIndent(best, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		err = ioutil.WriteFile(*tuneBest, buf, os.ModePerm)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("\nWrote best config to", *tuneBest)
+	}
+
+	if *saveTo != "" {
+		fmt.Println("\nTraining best config", best)
+		best.apply()
+		trainAndSave()
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestParseTuneGrid(t *testing.T) {
+	for _, c := range []struct {
+		grid   string
+		values map[string][]float64
+	}{
+		{"trees=1,5,10", map[string][]float64{"trees": {1, 5, 10}}},
+		{"m=2:4", map[string][]float64{"m": {2, 3, 4}}},
+		{"depth=4:12:3", map[string][]float64{"depth": {4, 7, 10}}},
+		{"subsetpct=0.4:0.6", map[string][]float64{"subsetpct": {0.4, 0.5, 0.6}}},
+		// adding up 0.1 eight times would miss 0.9
+		{"subsetpct=0.1:0.9:0.1", map[string][]float64{"subsetpct": {0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}}},
+		{"trees=1;m=2, 3;;", map[string][]float64{"trees": {1}, "m": {2, 3}}},
+		{"m=3:3", map[string][]float64{"m": {3}}},
+		{"", map[string][]float64{}},
+	} {
+		params, err := parseTuneGrid(c.grid)
+		if c.values == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error", c.grid)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.grid, err)
+			continue
+		}
+		if len(params) != len(c.values) {
+			t.Errorf("%q: parsed %d parameters, expected %d", c.grid, len(params), len(c.values))
+			continue
+		}
+		for _, p := range params {
+			expected := c.values[p.name]
+			if len(p.values) != len(expected) {
+				t.Errorf("%q: %s is %v, expected %v", c.grid, p.name, p.values, expected)
+				continue
+			}
+			for i := range expected {
+				if math.Abs(p.values[i]-expected[i]) > 1e-9 {
+					t.Errorf("%q: %s is %v, expected %v", c.grid, p.name, p.values, expected)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestParseTuneGridErrors(t *testing.T) {
+	for _, grid := range []string{
+		"trees",
+		"leaves=1,2",
+		"trees=1,x",
+		"m=1:2:3:4",
+		"m=4:2",
+		"m=1:4:0",
+		"m=1:4:-1",
+		"depth=a:4",
+	} {
+		if _, err := parseTuneGrid(grid); err == nil {
+			t.Errorf("%q: expected an error", grid)
+		}
+	}
+}
+
+func TestGridConfigs(t *testing.T) {
+	base := tuneConfig{Trees: 7, M: 2, Depth: 5, SubsetPercent: 0.6}
+	params, err := parseTuneGrid("trees=1,5;m=1:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := gridConfigs(base, params)
+	if len(configs) != 6 {
+		t.Fatal("expected every combination, got", configs)
+	}
+	seen := make(map[[2]int]bool)
+	for _, c := range configs {
+		if c.Depth != 5 || c.SubsetPercent != 0.6 {
+			t.Fatal("parameters outside the grid changed", c)
+		}
+		seen[[2]int{c.Trees, c.M}] = true
+	}
+	for _, trees := range []int{1, 5} {
+		for m := 1; m <= 3; m++ {
+			if !seen[[2]int{trees, m}] {
+				t.Fatal("missing trees", trees, "m", m, "in", configs)
+			}
+		}
+	}
+	if configs := gridConfigs(base, nil); len(configs) != 1 || configs[0].Trees != 7 {
+		t.Fatal("an empty grid should try the flags once, got", configs)
+	}
+}
+
+func TestRandomConfig(t *testing.T) {
+	rand.Seed(1)
+	base := tuneConfig{Trees: 7, M: 2, Depth: 5, SubsetPercent: 0.6}
+	params, err := parseTuneGrid("trees=10,20;depth=2:4;subsetpct=0.3:0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	depths := make(map[int]bool)
+	for i := 0; i < 200; i++ {
+		c := randomConfig(base, params)
+		if c.Trees != 10 && c.Trees != 20 {
+			t.Fatal("trees should be a listed value", c)
+		}
+		if c.Depth < 2 || c.Depth > 4 || c.SubsetPercent < 0.3 || c.SubsetPercent > 0.5 || c.M != 2 {
+			t.Fatal("out of range", c)
+		}
+		depths[c.Depth] = true
+	}
+	if len(depths) != 3 {
+		t.Fatal("expected every whole number in the depth range, got", depths)
+	}
+}
+
+func TestRandomValidConfig(t *testing.T) {
+	rand.Seed(1)
+	before := columnsPerRow
+	defer setColumnGlobals(before)
+	setColumnGlobals(5)
+	base := tuneConfig{Trees: 7, M: 2, Depth: 5, SubsetPercent: 0.6}
+	params, err := parseTuneGrid("m=1:20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		c, ok := randomValidConfig(base, params)
+		if !ok || !c.valid() {
+			t.Fatal("expected a valid config, got", c, ok)
+		}
+	}
+
+	params, err = parseTuneGrid("m=5:20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := randomValidConfig(base, params); ok {
+		t.Fatal("m is never below the 4 features, got", c)
+	}
+}
+
+func TestTuneConfigValid(t *testing.T) {
+	before := columnsPerRow
+	defer setColumnGlobals(before)
+	setColumnGlobals(5)
+	for _, c := range []struct {
+		config tuneConfig
+		valid  bool
+	}{
+		{tuneConfig{Trees: 1, M: 1, Depth: 1, SubsetPercent: 0.1}, true},
+		{tuneConfig{Trees: 10, M: 4, Depth: 8, SubsetPercent: 1}, true},
+		{tuneConfig{Trees: 0, M: 2, Depth: 4, SubsetPercent: 0.5}, false},
+		{tuneConfig{Trees: 5, M: 0, Depth: 4, SubsetPercent: 0.5}, false},
+		{tuneConfig{Trees: 5, M: 5, Depth: 4, SubsetPercent: 0.5}, false},
+		{tuneConfig{Trees: 5, M: 2, Depth: 0, SubsetPercent: 0.5}, false},
+		{tuneConfig{Trees: 5, M: 2, Depth: 4, SubsetPercent: 0}, false},
+	} {
+		if c.config.valid() != c.valid {
+			t.Errorf("%v: expected valid to be %v", c.config, c.valid)
+		}
+	}
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/gob"
+	"encoding/json"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"strings"
+	"time"
 )
 
 func lastColumn(dataSubset []datarow) (lastColList []float32) {
 	for _, row := range dataSubset {
-		lastColList = append(lastColList, row[4])
+		lastColList = append(lastColList, row[lastColumnIndex])
 	}
 	return lastColList
 }
 
-// maxCount returns whichever item in the list is most frequent
+// maxCount returns whichever item in the list is most frequent, the lowest
+// of them when there is a tie, like baggingPredict
 func maxCount(list []float32) (highestFreqIndex float32) {
 	seen := make(map[float32]float32)
 	for _, variableIndex := range list {
@@ -24,7 +31,7 @@ func maxCount(list []float32) (highestFreqIndex float32) {
 	}
 	var highestSeen float32
 	for variableIndex, count := range seen {
-		if count > highestSeen {
+		if count > highestSeen || (count == highestSeen && variableIndex < highestFreqIndex) {
 			highestSeen = count
 			highestFreqIndex = variableIndex
 		}
@@ -87,28 +94,152 @@ func splitIntoParts(dataset []datarow) (datasetSplit [][]datarow) {
 
 type saveFormat struct {
 	Trees            []*Tree
+	Flat             *flatForest // the Trees, laid out for fast prediction
 	IndexedVariables []string
 	Variables        map[string]float32
+	Targets          []targetFormat // each of the -targets of a multi-output forest, in order
+	Meta             modelMeta
 }
 
-// Encode via Gob to file
+// Training procedures recorded in modelMeta.Procedure
+const (
+	// the trees from every cross-validation fold, voting together
+	procedureCVFolds = "cv-folds"
+	// a forest trained on all of the data after cross-validation scoring
+	procedureFinal = "final"
+	// the final forest plus the cross-validation trees
+	procedureFinalWithCV = "final+cv"
+	// trees converted from another library by -import
+	procedureImported = "import"
+	// boosted on all but one fold, which chose when to stop
+	procedureHoldout = "holdout"
+)
+
+// What a model predicts, in modelMeta.Task. Empty is classification.
+const (
+	taskClassification = "classification"
+	taskRegression     = "regression"
+	taskAnomaly        = "anomaly" // leaves are isolation path lengths
+)
+
+// How the trees were grown, in modelMeta.Algorithm. Empty is algoForest.
+const (
+	algoForest    = "forest"    // bagged trees voting for a label
+	algoIsolation = "isolation" // random splits isolating each row, for anomaly scores
+	algoBoost     = "boost"     // gradient boosted trees, adding up to the prediction
+	algoAdaboost  = "adaboost"  // shallow trees reweighting the rows, with weighted votes
+)
+
+// modelMeta describes how a saved model was produced. Models saved before it
+// existed will have the zero value.
+type modelMeta struct {
+	Procedure        string
+	Imported         string // the -import format, like sklearn
+	Task             string
+	Algorithm        string
+	Created          time.Time
+	DataFile         string
+	CharMode         bool
+	SequenceLength   int
+	Columns          int // columns per row, including the predicted last column
+	ColumnNames      []string
+	Folds            int
+	TreesPerFold     int  // the most any fold used, with AutoTrees
+	AutoTrees        bool // trees were added until the holdout accuracy leveled off
+	FinalTrees       int  // trees trained on all of the data, when Procedure is not cv-folds
+	FeatureSplitSize int
+	MaxDepth         int
+	SubsetPercent    float64
+	Oblique          string    // the -oblique splits the trees could use
+	FoldScores       []float32 // cross-validation accuracy per fold, or RMSE for regression
+	MeanAccuracy     float32
+	MeanRMSE         float32   // of a regression forest, instead of MeanAccuracy
+	TargetScores     []float32 // mean fold score of each of the Targets
+	Rounds           int       // boosting rounds kept
+	LearningRate     float64   // boosting shrinkage
+	ValidationLoss   float64   // of the boosted model on its holdout fold
+	IsolationSamples int       // rows each isolation tree was grown from
+	Anomaly          string    // the -anomaly label, when the data had one
+	AUC              float32
+}
+
+// Encode via Gob to file, gzipped when the path ends in .gz
 func save(path string, object *saveFormat) error {
-	file, err := os.Create(path)
-	if err == nil {
-		encoder := gob.NewEncoder(file)
-		encoder.Encode(object)
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(object)
+	if err != nil {
+		return err
+	}
+	return writeModelFile(path, buf.Bytes())
+}
+
+// saveJSON is like save, but encodes the model as json
+func saveJSON(path string, object *saveFormat) error {
+	buf, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return writeModelFile(path, buf)
+}
+
+func writeModelFile(path string, buf []byte) error {
+	if strings.HasSuffix(path, ".gz") {
+		var zipped bytes.Buffer
+		w := gzip.NewWriter(&zipped)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		buf = zipped.Bytes()
 	}
-	file.Close()
-	return err
+	return ioutil.WriteFile(path, buf, os.ModePerm)
 }
 
-// Decode Gob file
+// Decode a model file. It may be gob or json, and either may be gzipped.
 func load(path string, object *saveFormat) error {
-	file, err := os.Open(path)
-	if err == nil {
-		decoder := gob.NewDecoder(file)
-		err = decoder.Decode(object)
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(buf, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		buf, err = ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+	}
+	isJSON := bytes.HasPrefix(bytes.TrimSpace(buf), []byte("{")) && json.Valid(buf)
+	if isJSON {
+		err = json.Unmarshal(buf, object)
+	} else {
+		err = gob.NewDecoder(bytes.NewReader(buf)).Decode(object)
+	}
+	if err != nil {
+		return err
+	}
+	if isJSON {
+		// json models may have had their Trees edited by hand, so the flat
+		// copy is rebuilt from them, keeping how the trees are combined
+		flat := flatten(object.Trees)
+		if object.Flat != nil && object.Flat.Additive {
+			flat.Labels = object.Flat.Labels
+			flat.Additive = true
+			flat.Classes = object.Flat.Classes
+			flat.BaseScore = object.Flat.BaseScore
+			flat.Output = object.Flat.Output
+		}
+		if object.Flat != nil && len(object.Flat.Weights) == len(flat.Roots) {
+			flat.Weights = object.Flat.Weights
+		}
+		object.Flat = flat
+	} else if object.Flat == nil {
+		// models saved before flattening existed
+		object.Flat = flatten(object.Trees)
 	}
-	file.Close()
-	return err
+	return nil
 }
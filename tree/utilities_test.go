@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func roundTripModels(t *testing.T) map[string]*saveFormat {
+	voting := testPMMLModel()
+	voting.Variables = map[string]float32{"a": 0, "b": 1, "c": 2}
+	voting.Meta.Procedure = procedureFinal
+	voting.Meta.Created = time.Date(2020, 5, 4, 3, 2, 1, 0, time.UTC)
+	voting.Meta.FoldScores = []float32{90.5, 93.25}
+
+	additive, err := importSklearnForest([]byte(sklearnTestForest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*saveFormat{"voting": voting, "additive": additive}
+}
+
+func TestGobJSONGobRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	for name, model := range roundTripModels(t) {
+		gobPath := filepath.Join(dir, name+".gob")
+		if err := save(gobPath, model); err != nil {
+			t.Fatal(err)
+		}
+		var original saveFormat
+		if err := load(gobPath, &original); err != nil {
+			t.Fatal(err)
+		}
+
+		jsonPath := filepath.Join(dir, name+".json")
+		if err := saveJSON(jsonPath, &original); err != nil {
+			t.Fatal(err)
+		}
+		var fromJSON saveFormat
+		if err := load(jsonPath, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		againPath := filepath.Join(dir, name+".again.gob")
+		if err := save(againPath, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		var again saveFormat
+		if err := load(againPath, &again); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(original, again) {
+			t.Fatalf("%s changed after gob, json and gob:\n%+v\n%+v", name, original, again)
+		}
+	}
+}
+
+func TestLoadGzip(t *testing.T) {
+	dir := t.TempDir()
+	model := roundTripModels(t)["voting"]
+	var loaded [2]saveFormat
+	for i, path := range []string{filepath.Join(dir, "model.gob.gz"), filepath.Join(dir, "model.json.gz")} {
+		var err error
+		if strings.Contains(path, "json") {
+			err = saveJSON(path, model)
+		} else {
+			err = save(path, model)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := load(path, &loaded[i]); err != nil {
+			t.Fatal(path, err)
+		}
+	}
+	if !reflect.DeepEqual(loaded[0].Flat, model.Flat) || !reflect.DeepEqual(loaded[0].Flat, loaded[1].Flat) {
+		t.Fatal("gzipped models did not load the same trees")
+	}
+}
+
+func TestLoadJSONRebuildsFlat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edited.json")
+	// hand edited, with no Flat and the split moved
+	edited := `{
+		"Trees": [{"VariableIndex": 0, "ValueIndex": 7, "LeftTerminal": 0, "RightTerminal": 1}],
+		"Flat": {"Roots": [0], "Feature": [0], "Threshold": [3], "Left": [-1], "Right": [-2], "Leaf": [0, 1], "Labels": 2},
+		"IndexedVariables": ["low", "high"]
+	}`
+	if err := ioutil.WriteFile(path, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var loaded saveFormat
+	if err := load(path, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if baggingPredict(loaded.Flat, datarow{5, 0}) != 0 {
+		t.Fatal("expected the edited split to be used, got threshold", loaded.Flat.Threshold)
+	}
+}
+
+// useTrainFlags points the flags train reads at values of their own, as
+// flag.Parse would, and restores them afterwards
+func useTrainFlags(folds, trees int, final, keepCV bool, data, path string) (restore func()) {
+	folds0, trees0, final0, keepCV0 := n_folds, treesPerFold, finalForest, keepCVTrees
+	pct0, m0, char0, data0, save0 := subsetSizePercent, overrideFeatureSplitSize, charMode, dataFile, saveTo
+	depth0, auto0, header0, algo0, reg0, targets0 := maxDepth, autoTrees, hasHeader, algorithm, regression, targetList
+	pct, m, no, forest, none := 1.0, 2, false, algoForest, ""
+	n_folds, treesPerFold, finalForest, keepCVTrees = &folds, &trees, &final, &keepCV
+	subsetSizePercent, overrideFeatureSplitSize, charMode, dataFile, saveTo = &pct, &m, &no, &data, &path
+	maxDepth, autoTrees, hasHeader, algorithm, regression, targetList = 1, &no, &no, &forest, &no, &none
+	return func() {
+		n_folds, treesPerFold, finalForest, keepCVTrees = folds0, trees0, final0, keepCV0
+		subsetSizePercent, overrideFeatureSplitSize, charMode, dataFile, saveTo = pct0, m0, char0, data0, save0
+		maxDepth, autoTrees, hasHeader, algorithm, regression, targetList = depth0, auto0, header0, algo0, reg0, targets0
+	}
+}
+
+// rootRows is how many training rows reached the root of each tree
+func rootRows(trees []*Tree) (rows []int) {
+	for _, t := range trees {
+		rows = append(rows, len(t.leftSamples)+len(t.rightSamples))
+	}
+	return rows
+}
+
+func TestTrainProcedures(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	// four features, since lastColumn reads the label from row[4]
+	var lines []string
+	for i := 0; i < 50; i++ {
+		x0, x1, x2, x3 := r.Float32(), r.Float32(), r.Float32(), r.Float32()
+		label := "low"
+		if x0 > 0.5 {
+			label = "high"
+		}
+		lines = append(lines, fmt.Sprintf("%v,%v,%v,%v,%s", x0, x1, x2, x3, label))
+	}
+	data := filepath.Join(t.TempDir(), "data.csv")
+	if err := ioutil.WriteFile(data, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		final, keepCV bool
+		procedure     string
+		trees         int
+	}{
+		{false, false, procedureCVFolds, 15},
+		{true, false, procedureFinal, 3},
+		{true, true, procedureFinalWithCV, 18},
+	} {
+		path := filepath.Join(t.TempDir(), "model.gob")
+		restore := useTrainFlags(5, 3, c.final, c.keepCV, data, path)
+		trainingCases, indexedVariables = nil, nil
+		train()
+		restore()
+
+		var loaded saveFormat
+		if err := load(path, &loaded); err != nil {
+			t.Fatal(err)
+		}
+		meta := loaded.Meta
+		if meta.Procedure != c.procedure {
+			t.Fatal("expected procedure", c.procedure, "got", meta.Procedure)
+		}
+		if len(loaded.Trees) != c.trees {
+			t.Fatal(c.procedure, "saved", len(loaded.Trees), "trees, expected", c.trees)
+		}
+		finalTrees := 0
+		if c.final {
+			finalTrees = 3
+		}
+		if meta.FinalTrees != finalTrees || meta.TreesPerFold != 3 || meta.Folds != 5 || len(meta.FoldScores) != 5 {
+			t.Fatalf("%s meta %+v", c.procedure, meta)
+		}
+	}
+
+	// the saved trees do not keep their rows, so check the final forest's
+	// sampling directly: with -subsetpct=1 each tree gets as many rows as
+	// there are training cases
+	restore := useTrainFlags(5, 3, true, false, data, "")
+	defer restore()
+	setColumnGlobals(5)
+	n_features = 2
+	if got, expected := rootRows(trainForest("final", trainingCases, 3, nil)), repeatInt(50, 3); !reflect.DeepEqual(got, expected) {
+		t.Fatal("final trees were trained on", got, "rows, expected", expected)
+	}
+}
+
+func repeatInt(v, n int) (s []int) {
+	for i := 0; i < n; i++ {
+		s = append(s, v)
+	}
+	return s
+}
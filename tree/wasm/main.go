@@ -0,0 +1,184 @@
+//go:build js && wasm
+
+/*
+The wasm predictor scores rows in a browser, using a model exported by
+tree -tojson. Build it with `make wasm` from the tree directory.
+
+It adds these functions to the javascript global object:
+
+	pineLoad(json)      load a model from its json text; returns an error message or null
+	pinePredict(row)    the predicted label for an array of feature numbers
+	pinePredictProba(row)  an object of label to the share of trees that voted for it
+
+Only what is needed for prediction is decoded, so this does not depend on the
+rest of the tree package.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"syscall/js"
+)
+
+// model is the part of the -tojson output needed to predict
+type model struct {
+	Flat struct {
+		Roots     []int32
+		Feature   []int32
+		Threshold []float32
+		Left      []int32
+		Right     []int32
+		Leaf      []float32
+		Weights   []float32
+		// an oblique node i splits on the sum of its features times their
+		// weights, from ObliqueStart[i] up to ObliqueStart[i+1]
+		ObliqueStart   []int32
+		ObliqueFeature []int32
+		ObliqueWeight  []float32
+		Additive       bool
+		Outputs        int
+	}
+	IndexedVariables []string
+	Meta             struct {
+		Columns int
+		Task    string
+	}
+	minFeatures int // the fewest a row can have when Meta.Columns is unknown
+}
+
+var loaded *model
+
+// splitFeatures is how many features a row needs for every split to find its
+// value, the same as splitFeatures in the tree package
+func (m *model) splitFeatures() (n int) {
+	for _, feature := range append(append([]int32(nil), m.Flat.Feature...), m.Flat.ObliqueFeature...) {
+		if int(feature)+1 > n {
+			n = int(feature) + 1
+		}
+	}
+	return n
+}
+
+// predictTree follows the same rules as flatForest.predictTree
+func (m *model) predictTree(node int32, row []float32) int {
+	f := &m.Flat
+	for {
+		value := row[f.Feature[node]]
+		if f.ObliqueStart != nil && f.ObliqueStart[node] < f.ObliqueStart[node+1] {
+			value = 0
+			for i := f.ObliqueStart[node]; i < f.ObliqueStart[node+1]; i++ {
+				value += row[f.ObliqueFeature[i]] * f.ObliqueWeight[i]
+			}
+		}
+		var next int32
+		if value < f.Threshold[node] {
+			next = f.Left[node]
+		} else {
+			next = f.Right[node]
+		}
+		if next < 0 {
+			return int(f.Leaf[-next-1])
+		}
+		node = next
+	}
+}
+
+func (m *model) proba(row []float32) (best int, proba []float32) {
+	proba = make([]float32, len(m.IndexedVariables))
+	// each tree's vote counts the same, unless the model weights them
+	weights := m.Flat.Weights
+	if weights == nil {
+		weights = make([]float32, len(m.Flat.Roots))
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	var total float32
+	for _, w := range weights {
+		total += w
+	}
+	for i, root := range m.Flat.Roots {
+		proba[m.predictTree(root, row)] += weights[i] / total
+	}
+	for i, p := range proba {
+		if p > proba[best] {
+			best = i
+		}
+	}
+	return best, proba
+}
+
+func load(this js.Value, args []js.Value) interface{} {
+	var m model
+	if err := json.Unmarshal([]byte(args[0].String()), &m); err != nil {
+		return err.Error()
+	}
+	if len(m.Flat.Roots) == 0 {
+		return "the model has no flattened trees; export it again with tree -tojson"
+	}
+	if m.Flat.Additive {
+		return "additive forests, like imported ones, cannot be used here yet"
+	}
+	if m.Meta.Task == "anomaly" {
+		return "isolation forests cannot be used here yet"
+	}
+	if m.Flat.Outputs > 0 {
+		return "multi-output forests cannot be used here yet"
+	}
+	if m.Meta.Task == "regression" {
+		return "regression forests cannot be used here yet"
+	}
+	m.minFeatures = m.splitFeatures()
+	loaded = &m
+	return nil
+}
+
+// toRow reads the javascript array of features
+func toRow(v js.Value) ([]float32, error) {
+	if loaded == nil {
+		return nil, fmt.Errorf("call pineLoad first")
+	}
+	n := v.Length()
+	if loaded.Meta.Columns > 0 && n != loaded.Meta.Columns-1 {
+		return nil, fmt.Errorf("row has %d features, expected %d", n, loaded.Meta.Columns-1)
+	}
+	if loaded.Meta.Columns == 0 && n < loaded.minFeatures {
+		return nil, fmt.Errorf("row has %d features, expected at least %d", n, loaded.minFeatures)
+	}
+	row := make([]float32, n+1)
+	for i := 0; i < n; i++ {
+		row[i] = float32(v.Index(i).Float())
+	}
+	return row, nil
+}
+
+func predict(this js.Value, args []js.Value) interface{} {
+	row, err := toRow(args[0])
+	if err != nil {
+		return js.Global().Get("Error").New(err.Error())
+	}
+	best, _ := loaded.proba(row)
+	return loaded.IndexedVariables[best]
+}
+
+func predictProba(this js.Value, args []js.Value) interface{} {
+	row, err := toRow(args[0])
+	if err != nil {
+		return js.Global().Get("Error").New(err.Error())
+	}
+	_, proba := loaded.proba(row)
+	named := make(map[string]interface{})
+	for i, p := range proba {
+		named[loaded.IndexedVariables[i]] = p
+	}
+	return named
+}
+
+func main() {
+	js.Global().Set("pineLoad", js.FuncOf(load))
+	js.Global().Set("pinePredict", js.FuncOf(predict))
+	js.Global().Set("pinePredictProba", js.FuncOf(predictProba))
+	// stay alive to answer calls
+	select {}
+}
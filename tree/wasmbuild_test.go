@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// the wasm predictor only builds for js, so the other tests never compile it
+func TestWasmBuilds(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go tool to build the wasm predictor with")
+	}
+	cmd := exec.Command(goTool, "build", "-o", filepath.Join(t.TempDir(), "pine.wasm"), "./wasm")
+	cmd.Env = append(os.Environ(), "GOOS=js", "GOARCH=wasm")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatal(err, "\n"+string(out))
+	}
+}